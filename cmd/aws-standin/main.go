@@ -0,0 +1,78 @@
+// aws-standin serves a local stand-in for the EC2, STS and instance metadata APIs used by ebs-autoscale. Point the tool
+// at it with the `aws.endpoint-url` and `host.imds.endpoint` config settings to run init, grow and monitor without an
+// AWS account.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/BobTheTerrible/ebs-autoscale/ebs_autoscale/awsfake"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// tagFlags collects repeated -tag key=value flags
+type tagFlags []types.Tag
+
+func (t *tagFlags) String() string {
+	return fmt.Sprint(*t)
+}
+
+func (t *tagFlags) Set(s string) error {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok {
+		return fmt.Errorf("tag must be of the form key=value: %s", s)
+	}
+	*t = append(*t, types.Tag{Key: aws.String(key), Value: aws.String(value)})
+	return nil
+}
+
+func main() {
+
+	var tags tagFlags
+	listen := flag.String("listen", "127.0.0.1:4566", "Address to serve the ec2 and sts apis on")
+	account := flag.String("account", "123456789012", "Account id returned by sts GetCallerIdentity")
+	imdsListen := flag.String("imds-listen", "", "Address to serve the instance metadata service on, disabled when empty")
+	instanceId := flag.String("instance-id", "i-0123456789abcdef0", "Instance id served by the metadata service and whose tags are returned by DescribeTags")
+	availabilityZone := flag.String("availability-zone", "ap-southeast-2a", "Availability zone served by the metadata service")
+	createDevices := flag.Bool("create-devices", false, "Create a sparse file at the device path of each attached volume, removing it on detach")
+	debug := flag.Bool("debug", false, "Log every request")
+	flag.Var(&tags, "tag", "Instance tag as key=value, may be repeated")
+	flag.Parse()
+
+	if *debug {
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+	}
+
+	fake := awsfake.NewEc2()
+	fake.PutInstance(*instanceId, tags)
+
+	if *createDevices {
+		fake.OnAttach = func(v types.Volume, instanceId string, device string) error {
+			f, err := os.OpenFile(device, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+			if err != nil {
+				return err
+			}
+			defer f.Close() //nolint:errcheck
+			return f.Truncate(int64(aws.ToInt32(v.Size)) << 30)
+		}
+		fake.OnDetach = func(v types.Volume, instanceId string, device string) error {
+			return os.Remove(device)
+		}
+	}
+
+	if *imdsListen != "" {
+		go func() {
+			slog.Info(fmt.Sprintf("aws-standin: serving instance metadata on http://%s", *imdsListen))
+			log.Fatalln(http.ListenAndServe(*imdsListen, awsfake.NewImds(*instanceId, *availabilityZone)))
+		}()
+	}
+
+	slog.Info(fmt.Sprintf("aws-standin: serving ec2 and sts on http://%s", *listen))
+	log.Fatalln(http.ListenAndServe(*listen, awsfake.NewServer(fake, *account)))
+}
@@ -1,18 +1,23 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
-	awsconfig "github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
 	"github.com/BobTheTerrible/ebs-autoscale/ebs_autoscale"
 	"github.com/BobTheTerrible/ebs-autoscale/ebs_autoscale/filesystem"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
 	"io"
 	"log"
 	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
 )
 
 var VersionName string
@@ -62,6 +67,14 @@ func main() {
 		growVolume(ctx, os.Args[2:])
 	case "monitor":
 		monitorVolume(ctx, os.Args[2:])
+	case "repair":
+		repairVolume(ctx, os.Args[2:])
+	case "gc":
+		collectGarbage(ctx, os.Args[2:])
+	case "status":
+		volumeStatus(ctx, os.Args[2:])
+	case "destroy":
+		destroyVolume(ctx, os.Args[2:])
 	case "version":
 		fmt.Printf("Version: %s", VersionName)
 	}
@@ -71,6 +84,7 @@ func createVolume(ctx context.Context, args []string) *ebs_autoscale.Volume {
 
 	cmd := flag.NewFlagSet("init", flag.ExitOnError)
 	configPath := cmd.String("config", defaultConfigPath, "Path to a json config file")
+	forceNew := cmd.Bool("force-new", false, "Destroy any existing managed volumes and create the filesystem afresh")
 
 	err := cmd.Parse(args)
 	if err != nil {
@@ -81,10 +95,13 @@ func createVolume(ctx context.Context, args []string) *ebs_autoscale.Volume {
 	if err != nil {
 		log.Fatalln(err)
 	}
+	if err := openJournal(ctx, config, volume, true); err != nil {
+		log.Fatalln(err)
+	}
 
 	slog.Info(fmt.Sprintf("createVolume: Creating New volume: %s", config.Volume.MountPoint))
 
-	err = volume.CreateVolume(ctx)
+	err = volume.CreateVolume(ctx, *forceNew)
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -96,16 +113,23 @@ func growVolume(ctx context.Context, args []string) *ebs_autoscale.Volume {
 
 	cmd := flag.NewFlagSet("grow", flag.ExitOnError)
 	configPath := cmd.String("config", defaultConfigPath, "Path to a json config file")
+	strategy := cmd.String("strategy", "", "Overrides the configured grow strategy: attach|modify")
 
 	err := cmd.Parse(args)
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	_, volume, err := base(ctx, *configPath)
+	config, volume, err := base(ctx, *configPath)
 	if err != nil {
 		log.Fatalln(err)
 	}
+	if err := openJournal(ctx, config, volume, true); err != nil {
+		log.Fatalln(err)
+	}
+	if *strategy != "" {
+		volume.GrowStrategy = *strategy
+	}
 
 	slog.Info("growVolume: Growing volume")
 
@@ -131,12 +155,41 @@ func monitorVolume(ctx context.Context, args []string) *ebs_autoscale.MonitorVol
 	if err != nil {
 		log.Fatalln(err)
 	}
+	if err := openJournal(ctx, config, volume, true); err != nil {
+		log.Fatalln(err)
+	}
 
 	monitor := ebs_autoscale.NewMonitor(
-		*volume,
-		config.Monitor.Interval,
+		volume,
+		time.Duration(config.Monitor.Interval),
 		config.Monitor.ThresholdPc,
 	)
+	tiers, err := ebs_autoscale.NewTiers(config.Monitor.Tiers)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	monitor.Tiers = tiers
+	monitor.MinPollInterval = time.Duration(config.Monitor.MinInterval)
+	monitor.MaxPollInterval = time.Duration(config.Monitor.MaxInterval)
+	monitor.MinFreeGb = config.Monitor.MinFreeGb
+	monitor.MinHoursToFull = config.Monitor.MinHoursToFull
+	monitor.TriggerMode = config.Monitor.TriggerMode
+	monitor.MetadataThresholdPc = config.Monitor.MetadataThresholdPc
+	monitor.InodeThresholdPc = config.Monitor.InodeThresholdPc
+	monitor.FillRateWindowSec = config.Monitor.Predictive.Window
+	monitor.CooldownSec = config.Monitor.Cooldown
+	monitor.RearmPc = config.Monitor.RearmPc
+	monitor.MaxGrowsPerHour = config.Monitor.MaxGrowsPerHour
+	monitor.MaxGrowsPerDay = config.Monitor.MaxGrowsPerDay
+	monitor.MaxBackoffSec = config.Monitor.MaxBackoff
+	monitor.BreakerFailures = config.Monitor.BreakerFailures
+	monitor.BreakerResetSec = config.Monitor.BreakerReset
+	if config.Gc.Enabled {
+		monitor.Gc = &config.Gc
+	}
+	if config.Monitor.Predictive.Enabled {
+		monitor.Predictive = &config.Monitor.Predictive
+	}
 
 	slog.Info(fmt.Sprintf("monitorVolume: Monitoring volume: %s", config.Volume.MountPoint))
 
@@ -148,6 +201,238 @@ func monitorVolume(ctx context.Context, args []string) *ebs_autoscale.MonitorVol
 	return monitor
 }
 
+func repairVolume(ctx context.Context, args []string) *ebs_autoscale.RepairResult {
+
+	cmd := flag.NewFlagSet("repair", flag.ExitOnError)
+	configPath := cmd.String("config", defaultConfigPath, "Path to a json config file")
+	policy := cmd.String("policy", "", "Overrides the configured repair policy: adopt|remove|report")
+
+	err := cmd.Parse(args)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	config, volume, err := base(ctx, *configPath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if err := openJournal(ctx, config, volume, false); err != nil {
+		log.Fatalln(err)
+	}
+	if *policy != "" {
+		volume.RepairPolicy = *policy
+	}
+
+	slog.Info(fmt.Sprintf("repairVolume: Repairing volume: %s", config.Volume.MountPoint))
+
+	result, err := volume.Repair(ctx)
+	if result != nil {
+		fmt.Printf("adopted: %v\nremoved: %v\nignored: %v\n", result.Adopted, result.Removed, result.Ignored)
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	return result
+}
+
+func collectGarbage(ctx context.Context, args []string) *ebs_autoscale.GcResult {
+
+	cmd := flag.NewFlagSet("gc", flag.ExitOnError)
+	configPath := cmd.String("config", defaultConfigPath, "Path to a json config file")
+	dryRun := cmd.Bool("dry-run", false, "Report orphaned volumes without deleting them")
+	gracePeriod := cmd.Int("grace-period", 0, "Overrides the configured grace period in seconds")
+
+	err := cmd.Parse(args)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	config, volume, err := base(ctx, *configPath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if err := openJournal(ctx, config, volume, false); err != nil {
+		log.Fatalln(err)
+	}
+	gcCfg := config.Gc
+	gcCfg.DryRun = gcCfg.DryRun || *dryRun
+	// the override is checked by GarbageCollect against the same rule as the configured grace period
+	cmd.Visit(func(f *flag.Flag) {
+		if f.Name == "grace-period" {
+			gcCfg.GracePeriod = int32(*gracePeriod)
+		}
+	})
+
+	slog.Info(fmt.Sprintf("collectGarbage: Collecting orphaned volumes, dry-run: %t", gcCfg.DryRun))
+
+	result, err := volume.GarbageCollect(ctx, gcCfg, time.Now)
+	if result != nil {
+		for _, o := range result.Orphans {
+			fmt.Printf("%s\t%s\t%s\tdeleted:%t\n", o.VolumeId, o.Reason, o.SourceInstance, o.Deleted)
+		}
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	return result
+}
+
+func destroyVolume(ctx context.Context, args []string) *ebs_autoscale.Volume {
+
+	cmd := flag.NewFlagSet("destroy", flag.ExitOnError)
+	configPath := cmd.String("config", defaultConfigPath, "Path to a json config file")
+	yes := cmd.Bool("yes", false, "Do not ask for confirmation")
+	force := cmd.Bool("force", false, "Detach the mount point lazily if it is busy")
+	keepVolumes := cmd.Bool("keep-volumes", false, "Detach the volumes without deleting them")
+
+	err := cmd.Parse(args)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	config, volume, err := base(ctx, *configPath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if err := openJournal(ctx, config, volume, false); err != nil {
+		log.Fatalln(err)
+	}
+	// refuse to run alongside an operation in flight, i.e. the monitor attaching a volume
+	if err := volume.Journal.Lock(); err != nil {
+		log.Fatalln(err)
+	}
+
+	vols, err := volume.DestroyVolumes(ctx)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if !*yes {
+		action := "detach and delete"
+		if *keepVolumes {
+			action = "detach"
+		}
+		fmt.Printf("This will unmount %s, remove it from /etc/fstab and %s %d volume(s):\n", config.Volume.MountPoint, action, len(vols))
+		for _, mv := range vols {
+			fmt.Printf("  %s\t%dGb\t%s\n", mv.VolumeId, mv.SizeGb, mv.Device)
+		}
+		fmt.Print("Continue? [y/N]: ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			log.Fatalln("destroyVolume: aborted")
+		}
+	}
+
+	slog.Info(fmt.Sprintf("destroyVolume: Destroying volume: %s", config.Volume.MountPoint))
+
+	err = volume.Destroy(ctx, *force, *keepVolumes)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	return volume
+}
+
+func volumeStatus(ctx context.Context, args []string) *ebs_autoscale.VolumeStatus {
+
+	cmd := flag.NewFlagSet("status", flag.ExitOnError)
+	configPath := cmd.String("config", defaultConfigPath, "Path to a json config file")
+	output := cmd.String("output", "text", "Output format: text|json")
+
+	err := cmd.Parse(args)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if *output != "text" && *output != "json" {
+		log.Fatalf("volumeStatus: unsupported output format: %s", *output)
+	}
+
+	// status only reports, it neither replays nor needs the journal, so the state dir need not be writable
+	config, volume, err := base(ctx, *configPath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	status, err := volume.Status(ctx, config.Volume.Backend.Type)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(status)
+	} else {
+		err = printStatus(os.Stdout, status)
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	return status
+}
+
+// printStatus writes the status in a human readable form
+func printStatus(out io.Writer, status *ebs_autoscale.VolumeStatus) error {
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(w, "Id:\t%s\n", status.Id)
+	fmt.Fprintf(w, "Mount point:\t%s\n", status.MountPoint)
+	fmt.Fprintf(w, "Backend:\t%s\n", status.Backend)
+	if status.Filesystem != nil {
+		fmt.Fprintf(w, "Filesystem:\ttotal %s, used %s, free %s\n", formatBytes(status.Filesystem.TotalBytes),
+			formatBytes(status.Filesystem.UsedBytes), formatBytes(status.Filesystem.FreeBytes))
+		if usage := status.Filesystem.Usage; usage != nil {
+			fmt.Fprintf(w, "Data:\t%s of %s allocated\n", formatBytes(usage.Data.Used), formatBytes(usage.Data.Allocated))
+			// only file systems with block groups, i.e. btrfs, allocate metadata separately
+			if usage.Metadata.Allocated > 0 {
+				fmt.Fprintf(w, "Metadata:\t%s of %s allocated, %.1f%% used\n", formatBytes(usage.Metadata.Used),
+					formatBytes(usage.Metadata.Allocated), *status.Filesystem.MetadataPercent)
+				fmt.Fprintf(w, "Unallocated:\t%s\n", formatBytes(usage.UnallocatedTotal()))
+			}
+			if usage.Inodes > 0 {
+				fmt.Fprintf(w, "Inodes:\t%d of %d free, %.1f%% used\n", usage.InodesFree, usage.Inodes, usage.InodePercent())
+			}
+		}
+	} else {
+		fmt.Fprintf(w, "Filesystem:\tunavailable: %s\n", status.FilesystemError)
+	}
+	fmt.Fprintf(w, "Headroom:\t%dGb of %dGb, %d of %d volumes\n", status.Headroom.SizeGb, status.Headroom.MaxLogicalSizeGb,
+		status.Headroom.Volumes, status.Headroom.MaxVolumes)
+	if status.NextGrowSizeGb != nil {
+		fmt.Fprintf(w, "Next grow:\t%dGb\n", *status.NextGrowSizeGb)
+	} else {
+		fmt.Fprintf(w, "Next grow:\tunavailable: %s\n", status.NextGrowError)
+	}
+	if status.Drift != nil {
+		fmt.Fprintf(w, "Drift:\tnot-in-filesystem:%d unmanaged-devices:%v\n", len(status.Drift.NotInFilesystem), status.Drift.UnmanagedDevices)
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "VOLUME\tSIZE\tTYPE\tIOPS\tTHROUGHPUT\tDEVICE\tATTACHMENT")
+	for _, mv := range status.ManagedVolumes {
+		fmt.Fprintf(w, "%s\t%dGb\t%s\t%s\t%s\t%s\t%s\n", mv.VolumeId, mv.SizeGb, mv.Type, formatOptInt32(mv.Iops),
+			formatOptInt32(mv.Throughput), mv.Device, mv.AttachmentState)
+	}
+
+	return w.Flush()
+}
+
+// formatBytes formats a byte count in Gb to two decimal places
+func formatBytes(b uint64) string {
+	return fmt.Sprintf("%.2fGb", float64(b)/float64(1<<30))
+}
+
+func formatOptInt32(i *int32) string {
+	if i == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%d", *i)
+}
+
 func base(ctx context.Context, configPath string) (*ebs_autoscale.Config, *ebs_autoscale.Volume, error) {
 
 	config, err := ebs_autoscale.NewConfig(configPath)
@@ -155,7 +440,13 @@ func base(ctx context.Context, configPath string) (*ebs_autoscale.Config, *ebs_a
 		return nil, nil, err
 	}
 
-	host, err := ebs_autoscale.NewEc2Host(ctx)
+	var host *ebs_autoscale.Ec2Host
+	if config.Volume.Provider.Type == "loop" {
+		// The loop provider runs without AWS, so describe the local machine instead
+		host, err = ebs_autoscale.NewLocalHost(config.Host)
+	} else {
+		host, err = ebs_autoscale.NewEc2Host(ctx, config.Host, config.Aws)
+	}
 	if err != nil {
 		return nil, nil, err
 	}
@@ -170,6 +461,7 @@ func base(ctx context.Context, configPath string) (*ebs_autoscale.Config, *ebs_a
 		*host,
 		fs,
 		config.Volume,
+		config.Aws,
 	)
 	if err != nil {
 		return config, nil, err
@@ -186,6 +478,21 @@ func base(ctx context.Context, configPath string) (*ebs_autoscale.Config, *ebs_a
 	return config, volume, nil
 }
 
+// openJournal opens the journal of the volume's in-flight operations. When replay is set, an operation interrupted by
+// a previous process is finished or rolled back before doing anything else, only init, grow and monitor replay.
+func openJournal(ctx context.Context, config *ebs_autoscale.Config, volume *ebs_autoscale.Volume, replay bool) error {
+
+	journal, err := ebs_autoscale.NewJournal(config.StateDir, volume.Id)
+	if err != nil {
+		return err
+	}
+	volume.Journal = journal
+	if !replay {
+		return nil
+	}
+	return volume.Replay(ctx)
+}
+
 func initLogger(ctx context.Context, region string, cfg ebs_autoscale.LoggingCfg, prefix string) (*ebs_autoscale.CwLogWriter, error) {
 
 	slog.Info(fmt.Sprintf("initLogger: Init cloudwatch logger to: %s", cfg.LogGroupName))
@@ -0,0 +1,636 @@
+// Package awsfake provides in-memory stand-ins for the AWS APIs used by ebs-autoscale so the volume lifecycle can be
+// exercised without an AWS account.
+package awsfake
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ec2 is a stateful, in-memory fake of the EC2 volume API. Volumes are created directly in the available state and
+// attachments complete immediately, so the SDK waiters return on their first poll. Volume modifications go straight to
+// the optimizing state with the new size applied. Failures can be injected per operation with FailNext.
+type Ec2 struct {
+	mu sync.Mutex
+	// volumes keyed by volume id
+	volumes map[string]*types.Volume
+	// order the volume ids in creation order, so describe calls are deterministic
+	order []string
+	// instanceTags the tags of the known instances, keyed by instance id
+	instanceTags map[string][]types.Tag
+	// terminated the ids of the known instances that have been terminated
+	terminated map[string]bool
+	// modifications the latest modification of each volume, keyed by volume id
+	modifications map[string]*types.VolumeModification
+	// faults queued errors keyed by operation name
+	faults map[string][]error
+	// calls the operation names invoked, in order
+	calls  []string
+	nextId int
+	// OnAttach is called when a volume is attached. It can be used to simulate the device appearing on the host.
+	OnAttach func(volume types.Volume, instanceId string, device string) error
+	// OnDetach is called when a volume is detached.
+	OnDetach func(volume types.Volume, instanceId string, device string) error
+	// Now returns the time used for create and attach timestamps
+	Now func() time.Time
+}
+
+// NewEc2 returns an empty fake
+func NewEc2() *Ec2 {
+	return &Ec2{
+		volumes:       map[string]*types.Volume{},
+		instanceTags:  map[string][]types.Tag{},
+		terminated:    map[string]bool{},
+		modifications: map[string]*types.VolumeModification{},
+		faults:        map[string][]error{},
+		Now:           time.Now,
+	}
+}
+
+// FailNext queues an error to be returned by the next call of the given operation i.e. "AttachVolume". Errors queued
+// for the same operation are returned in order, a nil error lets that call through.
+func (e *Ec2) FailNext(operation string, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.faults[operation] = append(e.faults[operation], err)
+}
+
+// Calls returns the operation names invoked so far, in order
+func (e *Ec2) Calls() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string{}, e.calls...)
+}
+
+// Volume returns a copy of the volume with the given id
+func (e *Ec2) Volume(volumeId string) (types.Volume, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	v, ok := e.volumes[volumeId]
+	if !ok {
+		return types.Volume{}, false
+	}
+	return copyVolume(*v), true
+}
+
+// Volumes returns a copy of all volumes that have not been deleted, in creation order
+func (e *Ec2) Volumes() []types.Volume {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	vols := make([]types.Volume, 0, len(e.order))
+	for _, id := range e.order {
+		vols = append(vols, copyVolume(*e.volumes[id]))
+	}
+	return vols
+}
+
+// PutVolume adds or replaces a volume, allowing tests to seed pre-existing state
+func (e *Ec2) PutVolume(v types.Volume) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	c := copyVolume(v)
+	if _, ok := e.volumes[*c.VolumeId]; !ok {
+		e.order = append(e.order, *c.VolumeId)
+	}
+	e.volumes[*c.VolumeId] = &c
+}
+
+// PutInstance registers an instance and its tags
+func (e *Ec2) PutInstance(instanceId string, tags []types.Tag) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.instanceTags[instanceId] = append([]types.Tag{}, tags...)
+}
+
+// TerminateInstance marks a known instance as terminated, it is still returned by DescribeInstances
+func (e *Ec2) TerminateInstance(instanceId string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.terminated[instanceId] = true
+}
+
+// PutVolumeModification records a modification of a volume, i.e. to simulate one made before the test or one still
+// in progress. It replaces any earlier modification of the volume.
+func (e *Ec2) PutVolumeModification(m types.VolumeModification) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	c := m
+	e.modifications[aws.ToString(m.VolumeId)] = &c
+}
+
+// SetVolumeState forces the state of a volume, i.e. to simulate a volume that never becomes available
+func (e *Ec2) SetVolumeState(volumeId string, state types.VolumeState) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	v, ok := e.volumes[volumeId]
+	if !ok {
+		return volumeNotFound(volumeId)
+	}
+	v.State = state
+	return nil
+}
+
+// begin records the call and returns any fault queued for the operation. The caller must hold the lock.
+func (e *Ec2) begin(operation string) error {
+	e.calls = append(e.calls, operation)
+	queued := e.faults[operation]
+	if len(queued) == 0 {
+		return nil
+	}
+	e.faults[operation] = queued[1:]
+	return queued[0]
+}
+
+func (e *Ec2) DescribeVolumes(_ context.Context, params *ec2.DescribeVolumesInput, _ ...func(*ec2.Options)) (*ec2.DescribeVolumesOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.begin("DescribeVolumes"); err != nil {
+		return nil, err
+	}
+
+	for _, id := range params.VolumeIds {
+		if _, ok := e.volumes[id]; !ok {
+			return nil, volumeNotFound(id)
+		}
+	}
+
+	out := &ec2.DescribeVolumesOutput{Volumes: []types.Volume{}}
+	for _, id := range e.order {
+		v := e.volumes[id]
+		if len(params.VolumeIds) > 0 && !contains(params.VolumeIds, id) {
+			continue
+		}
+		if !matchesFilters(*v, params.Filters) {
+			continue
+		}
+		out.Volumes = append(out.Volumes, copyVolume(*v))
+	}
+	return out, nil
+}
+
+func (e *Ec2) CreateVolume(_ context.Context, params *ec2.CreateVolumeInput, _ ...func(*ec2.Options)) (*ec2.CreateVolumeOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.begin("CreateVolume"); err != nil {
+		return nil, err
+	}
+	if params.AvailabilityZone == nil || *params.AvailabilityZone == "" {
+		return nil, apiError("MissingParameter", "The request must contain the parameter AvailabilityZone")
+	}
+	if params.Size == nil || *params.Size <= 0 {
+		return nil, apiError("InvalidParameterValue", "The parameter Size must be a positive integer")
+	}
+
+	e.nextId++
+	volumeId := fmt.Sprintf("vol-%017x", e.nextId)
+	v := types.Volume{
+		VolumeId:         aws.String(volumeId),
+		AvailabilityZone: params.AvailabilityZone,
+		Size:             params.Size,
+		VolumeType:       params.VolumeType,
+		Iops:             params.Iops,
+		Throughput:       params.Throughput,
+		Encrypted:        params.Encrypted,
+		CreateTime:       aws.Time(e.Now()),
+		State:            types.VolumeStateAvailable,
+		Attachments:      []types.VolumeAttachment{},
+		Tags:             []types.Tag{},
+	}
+	for _, spec := range params.TagSpecifications {
+		if spec.ResourceType == types.ResourceTypeVolume {
+			v.Tags = append(v.Tags, spec.Tags...)
+		}
+	}
+	e.volumes[volumeId] = &v
+	e.order = append(e.order, volumeId)
+
+	c := copyVolume(v)
+	return &ec2.CreateVolumeOutput{
+		Attachments:      c.Attachments,
+		AvailabilityZone: c.AvailabilityZone,
+		CreateTime:       c.CreateTime,
+		Encrypted:        c.Encrypted,
+		Iops:             c.Iops,
+		Size:             c.Size,
+		State:            types.VolumeStateCreating,
+		Tags:             c.Tags,
+		Throughput:       c.Throughput,
+		VolumeId:         c.VolumeId,
+		VolumeType:       c.VolumeType,
+	}, nil
+}
+
+func (e *Ec2) AttachVolume(_ context.Context, params *ec2.AttachVolumeInput, _ ...func(*ec2.Options)) (*ec2.AttachVolumeOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.begin("AttachVolume"); err != nil {
+		return nil, err
+	}
+	v, ok := e.volumes[aws.ToString(params.VolumeId)]
+	if !ok {
+		return nil, volumeNotFound(aws.ToString(params.VolumeId))
+	}
+	if v.State != types.VolumeStateAvailable {
+		return nil, apiError("IncorrectState", fmt.Sprintf("vol '%s' is not 'available'.", *v.VolumeId))
+	}
+	for _, o := range e.volumes {
+		for _, a := range o.Attachments {
+			if aws.ToString(a.InstanceId) == aws.ToString(params.InstanceId) && aws.ToString(a.Device) == aws.ToString(params.Device) {
+				return nil, apiError("InvalidParameterValue", fmt.Sprintf("Attachment point %s is already in use", aws.ToString(params.Device)))
+			}
+		}
+	}
+
+	if e.OnAttach != nil {
+		if err := e.OnAttach(copyVolume(*v), aws.ToString(params.InstanceId), aws.ToString(params.Device)); err != nil {
+			return nil, err
+		}
+	}
+
+	a := types.VolumeAttachment{
+		AttachTime:          aws.Time(e.Now()),
+		DeleteOnTermination: aws.Bool(false),
+		Device:              params.Device,
+		InstanceId:          params.InstanceId,
+		State:               types.VolumeAttachmentStateAttached,
+		VolumeId:            v.VolumeId,
+	}
+	v.Attachments = []types.VolumeAttachment{a}
+	v.State = types.VolumeStateInUse
+
+	return &ec2.AttachVolumeOutput{
+		AttachTime:          a.AttachTime,
+		DeleteOnTermination: a.DeleteOnTermination,
+		Device:              a.Device,
+		InstanceId:          a.InstanceId,
+		State:               types.VolumeAttachmentStateAttaching,
+		VolumeId:            a.VolumeId,
+	}, nil
+}
+
+func (e *Ec2) DetachVolume(_ context.Context, params *ec2.DetachVolumeInput, _ ...func(*ec2.Options)) (*ec2.DetachVolumeOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.begin("DetachVolume"); err != nil {
+		return nil, err
+	}
+	v, ok := e.volumes[aws.ToString(params.VolumeId)]
+	if !ok {
+		return nil, volumeNotFound(aws.ToString(params.VolumeId))
+	}
+	if len(v.Attachments) == 0 {
+		return nil, apiError("IncorrectState", fmt.Sprintf("Volume '%s' is in the 'available' state.", *v.VolumeId))
+	}
+
+	a := v.Attachments[0]
+	if e.OnDetach != nil {
+		if err := e.OnDetach(copyVolume(*v), aws.ToString(a.InstanceId), aws.ToString(a.Device)); err != nil {
+			return nil, err
+		}
+	}
+	v.Attachments = []types.VolumeAttachment{}
+	v.State = types.VolumeStateAvailable
+
+	return &ec2.DetachVolumeOutput{
+		AttachTime: a.AttachTime,
+		Device:     a.Device,
+		InstanceId: a.InstanceId,
+		State:      types.VolumeAttachmentStateDetaching,
+		VolumeId:   a.VolumeId,
+	}, nil
+}
+
+func (e *Ec2) DeleteVolume(_ context.Context, params *ec2.DeleteVolumeInput, _ ...func(*ec2.Options)) (*ec2.DeleteVolumeOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.begin("DeleteVolume"); err != nil {
+		return nil, err
+	}
+	volumeId := aws.ToString(params.VolumeId)
+	v, ok := e.volumes[volumeId]
+	if !ok {
+		return nil, volumeNotFound(volumeId)
+	}
+	// as in ec2, volumes that failed are deleted as well as available ones
+	if v.State != types.VolumeStateAvailable && v.State != types.VolumeStateError {
+		return nil, apiError("VolumeInUse", fmt.Sprintf("Volume %s is currently attached", volumeId))
+	}
+
+	delete(e.volumes, volumeId)
+	delete(e.modifications, volumeId)
+	for i, id := range e.order {
+		if id == volumeId {
+			e.order = append(e.order[:i], e.order[i+1:]...)
+			break
+		}
+	}
+	return &ec2.DeleteVolumeOutput{}, nil
+}
+
+func (e *Ec2) ModifyInstanceAttribute(_ context.Context, params *ec2.ModifyInstanceAttributeInput, _ ...func(*ec2.Options)) (*ec2.ModifyInstanceAttributeOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.begin("ModifyInstanceAttribute"); err != nil {
+		return nil, err
+	}
+
+	for _, m := range params.BlockDeviceMappings {
+		found := false
+		for _, v := range e.volumes {
+			for i, a := range v.Attachments {
+				if aws.ToString(a.InstanceId) != aws.ToString(params.InstanceId) || aws.ToString(a.Device) != aws.ToString(m.DeviceName) {
+					continue
+				}
+				if m.Ebs != nil {
+					v.Attachments[i].DeleteOnTermination = m.Ebs.DeleteOnTermination
+				}
+				found = true
+			}
+		}
+		if !found {
+			return nil, apiError("InvalidInstanceAttributeValue", fmt.Sprintf("No device is currently mapped at %s", aws.ToString(m.DeviceName)))
+		}
+	}
+	return &ec2.ModifyInstanceAttributeOutput{}, nil
+}
+
+// ModifyVolume changes the size of a volume, other attributes are not supported. The modification is recorded in the
+// optimizing state. The six hour wait between modifications of a volume is not enforced, a modification still in
+// the modifying state is rejected.
+func (e *Ec2) ModifyVolume(_ context.Context, params *ec2.ModifyVolumeInput, _ ...func(*ec2.Options)) (*ec2.ModifyVolumeOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.begin("ModifyVolume"); err != nil {
+		return nil, err
+	}
+	volumeId := aws.ToString(params.VolumeId)
+	v, ok := e.volumes[volumeId]
+	if !ok {
+		return nil, volumeNotFound(volumeId)
+	}
+	if m, ok := e.modifications[volumeId]; ok && m.ModificationState == types.VolumeModificationStateModifying {
+		return nil, apiError("IncorrectModificationState", fmt.Sprintf("Volume %s is already being modified", volumeId))
+	}
+	if params.Size == nil || *params.Size < aws.ToInt32(v.Size) {
+		return nil, apiError("InvalidParameterValue", fmt.Sprintf("New size must be at least the current size of %dGiB", aws.ToInt32(v.Size)))
+	}
+
+	m := types.VolumeModification{
+		VolumeId:           v.VolumeId,
+		ModificationState:  types.VolumeModificationStateOptimizing,
+		OriginalSize:       v.Size,
+		TargetSize:         params.Size,
+		OriginalVolumeType: v.VolumeType,
+		TargetVolumeType:   v.VolumeType,
+		Progress:           aws.Int64(0),
+		StartTime:          aws.Time(e.Now()),
+	}
+	e.modifications[volumeId] = &m
+	v.Size = aws.Int32(*params.Size)
+
+	c := m
+	return &ec2.ModifyVolumeOutput{VolumeModification: &c}, nil
+}
+
+// DescribeVolumesModifications returns the latest modification of the given volumes, or of every modified volume.
+// Filters are not supported.
+func (e *Ec2) DescribeVolumesModifications(_ context.Context, params *ec2.DescribeVolumesModificationsInput, _ ...func(*ec2.Options)) (*ec2.DescribeVolumesModificationsOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.begin("DescribeVolumesModifications"); err != nil {
+		return nil, err
+	}
+
+	for _, id := range params.VolumeIds {
+		if _, ok := e.modifications[id]; !ok {
+			return nil, apiError("InvalidVolumeModification.NotFound", fmt.Sprintf("Modification for volume '%s' does not exist.", id))
+		}
+	}
+
+	out := &ec2.DescribeVolumesModificationsOutput{VolumesModifications: []types.VolumeModification{}}
+	for _, id := range e.order {
+		m, ok := e.modifications[id]
+		if !ok || (len(params.VolumeIds) > 0 && !contains(params.VolumeIds, id)) {
+			continue
+		}
+		out.VolumesModifications = append(out.VolumesModifications, *m)
+	}
+	return out, nil
+}
+
+// DescribeInstances returns the state of the known instances. Instances are running unless terminated with
+// TerminateInstance, filters are not supported.
+func (e *Ec2) DescribeInstances(_ context.Context, params *ec2.DescribeInstancesInput, _ ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.begin("DescribeInstances"); err != nil {
+		return nil, err
+	}
+
+	for _, id := range params.InstanceIds {
+		if _, ok := e.instanceTags[id]; !ok {
+			return nil, apiError("InvalidInstanceID.NotFound", fmt.Sprintf("The instance ID '%s' does not exist", id))
+		}
+	}
+
+	instanceIds := params.InstanceIds
+	if len(instanceIds) == 0 {
+		for id := range e.instanceTags {
+			instanceIds = append(instanceIds, id)
+		}
+		sort.Strings(instanceIds)
+	}
+
+	out := &ec2.DescribeInstancesOutput{Reservations: []types.Reservation{}}
+	for _, id := range instanceIds {
+		state := types.InstanceState{Code: aws.Int32(16), Name: types.InstanceStateNameRunning}
+		if e.terminated[id] {
+			state = types.InstanceState{Code: aws.Int32(48), Name: types.InstanceStateNameTerminated}
+		}
+		out.Reservations = append(out.Reservations, types.Reservation{
+			Instances: []types.Instance{{
+				InstanceId: aws.String(id),
+				State:      &state,
+				Tags:       append([]types.Tag{}, e.instanceTags[id]...),
+			}},
+		})
+	}
+	return out, nil
+}
+
+// CreateTags adds or replaces tags on volumes. Instance tags are managed with PutInstance.
+func (e *Ec2) CreateTags(_ context.Context, params *ec2.CreateTagsInput, _ ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.begin("CreateTags"); err != nil {
+		return nil, err
+	}
+
+	for _, id := range params.Resources {
+		if _, ok := e.volumes[id]; !ok {
+			return nil, volumeNotFound(id)
+		}
+	}
+	for _, id := range params.Resources {
+		v := e.volumes[id]
+		for _, t := range params.Tags {
+			replaced := false
+			for i := range v.Tags {
+				if aws.ToString(v.Tags[i].Key) == aws.ToString(t.Key) {
+					v.Tags[i].Value = aws.String(aws.ToString(t.Value))
+					replaced = true
+				}
+			}
+			if !replaced {
+				v.Tags = append(v.Tags, types.Tag{Key: aws.String(aws.ToString(t.Key)), Value: aws.String(aws.ToString(t.Value))})
+			}
+		}
+	}
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+func (e *Ec2) DescribeTags(_ context.Context, params *ec2.DescribeTagsInput, _ ...func(*ec2.Options)) (*ec2.DescribeTagsOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.begin("DescribeTags"); err != nil {
+		return nil, err
+	}
+
+	out := &ec2.DescribeTagsOutput{Tags: []types.TagDescription{}}
+	add := func(resourceId string, resourceType types.ResourceType, tags []types.Tag) {
+		for _, t := range tags {
+			d := types.TagDescription{
+				Key:          t.Key,
+				Value:        t.Value,
+				ResourceId:   aws.String(resourceId),
+				ResourceType: resourceType,
+			}
+			if matchesTagFilters(d, params.Filters) {
+				out.Tags = append(out.Tags, d)
+			}
+		}
+	}
+
+	instanceIds := make([]string, 0, len(e.instanceTags))
+	for id := range e.instanceTags {
+		instanceIds = append(instanceIds, id)
+	}
+	sort.Strings(instanceIds)
+	for _, id := range instanceIds {
+		add(id, types.ResourceTypeInstance, e.instanceTags[id])
+	}
+	for _, id := range e.order {
+		add(id, types.ResourceTypeVolume, e.volumes[id].Tags)
+	}
+	return out, nil
+}
+
+// matchesTagFilters evaluates the DescribeTags filters. Unknown filters never match.
+func matchesTagFilters(d types.TagDescription, filters []types.Filter) bool {
+	for _, f := range filters {
+		var value string
+		switch aws.ToString(f.Name) {
+		case "resource-id":
+			value = aws.ToString(d.ResourceId)
+		case "resource-type":
+			value = string(d.ResourceType)
+		case "key":
+			value = aws.ToString(d.Key)
+		case "value":
+			value = aws.ToString(d.Value)
+		default:
+			return false
+		}
+		if !contains(f.Values, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesFilters evaluates the subset of DescribeVolumes filters used by ebs-autoscale. Unknown filters never match.
+func matchesFilters(v types.Volume, filters []types.Filter) bool {
+	for _, f := range filters {
+		name := aws.ToString(f.Name)
+		var values []string
+		switch {
+		case name == "volume-id":
+			values = []string{aws.ToString(v.VolumeId)}
+		case name == "status":
+			values = []string{string(v.State)}
+		case name == "availability-zone":
+			values = []string{aws.ToString(v.AvailabilityZone)}
+		case name == "volume-type":
+			values = []string{string(v.VolumeType)}
+		case name == "attachment.instance-id":
+			for _, a := range v.Attachments {
+				values = append(values, aws.ToString(a.InstanceId))
+			}
+		case name == "attachment.device":
+			for _, a := range v.Attachments {
+				values = append(values, aws.ToString(a.Device))
+			}
+		case name == "attachment.status":
+			for _, a := range v.Attachments {
+				values = append(values, string(a.State))
+			}
+		case name == "tag-key":
+			for _, t := range v.Tags {
+				values = append(values, aws.ToString(t.Key))
+			}
+		case strings.HasPrefix(name, "tag:"):
+			for _, t := range v.Tags {
+				if aws.ToString(t.Key) == strings.TrimPrefix(name, "tag:") {
+					values = append(values, aws.ToString(t.Value))
+				}
+			}
+		default:
+			return false
+		}
+
+		matched := false
+		for _, want := range f.Values {
+			if contains(values, want) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// copyVolume returns a copy of the volume that shares no slices with the original
+func copyVolume(v types.Volume) types.Volume {
+	c := v
+	c.Attachments = append([]types.VolumeAttachment{}, v.Attachments...)
+	c.Tags = append([]types.Tag{}, v.Tags...)
+	return c
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+func apiError(code string, message string) error {
+	return &smithy.GenericAPIError{Code: code, Message: message, Fault: smithy.FaultClient}
+}
+
+func volumeNotFound(volumeId string) error {
+	return apiError("InvalidVolume.NotFound", fmt.Sprintf("The volume '%s' does not exist.", volumeId))
+}
@@ -0,0 +1,146 @@
+package awsfake
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	imdsTokenPath     = "/latest/api/token"
+	imdsMetadataPath  = "/latest/meta-data/"
+	imdsTokenHeader   = "X-Aws-Ec2-Metadata-Token"
+	imdsTTLHeader     = "X-Aws-Ec2-Metadata-Token-Ttl-Seconds"
+	imdsMaxTokenTTL   = 21600
+	imdsCredentialKey = "standin"
+)
+
+// Imds is an IMDSv2 compatible stand-in for the instance metadata service. Metadata requests must carry a token
+// obtained from PUT /latest/api/token. It also serves static role credentials so the sdk default credential chain
+// resolves without an AWS account.
+type Imds struct {
+	mu sync.Mutex
+	// Metadata the values served under /latest/meta-data/, keyed by path i.e. "instance-id"
+	Metadata map[string]string
+	// Role the name of the instance profile role credentials are served for
+	Role string
+	// tokens the issued tokens and their expiry
+	tokens map[string]time.Time
+	// failures the number of upcoming requests to reject, simulating a metadata service that is not yet available
+	failures int
+	// Now returns the time used to expire tokens
+	Now func() time.Time
+}
+
+// NewImds returns a stand-in serving the given instance identity
+func NewImds(instanceId string, availabilityZone string) *Imds {
+	return &Imds{
+		Metadata: map[string]string{
+			"instance-id":                 instanceId,
+			"placement/availability-zone": availabilityZone,
+			"placement/region":            availabilityZone[:len(availabilityZone)-1],
+		},
+		Role:   "ebs-autoscale-standin",
+		tokens: map[string]time.Time{},
+		Now:    time.Now,
+	}
+}
+
+// FailNext rejects the next n requests with a 503, as the metadata service does while an instance is booting
+func (i *Imds) FailNext(n int) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.failures += n
+}
+
+// ServeHTTP implements http.Handler
+func (i *Imds) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.failures > 0 {
+		i.failures--
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	if r.URL.Path == imdsTokenPath {
+		i.issueToken(w, r)
+		return
+	}
+
+	if !strings.HasPrefix(r.URL.Path, imdsMetadataPath) {
+		http.NotFound(w, r)
+		return
+	}
+	expires, ok := i.tokens[r.Header.Get(imdsTokenHeader)]
+	if !ok || i.Now().After(expires) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, imdsMetadataPath)
+	body, ok := i.metadata(path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte(body))
+}
+
+// issueToken handles PUT /latest/api/token. The caller must hold the lock.
+func (i *Imds) issueToken(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ttl, err := strconv.Atoi(r.Header.Get(imdsTTLHeader))
+	if err != nil || ttl < 1 || ttl > imdsMaxTokenTTL {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	token := uuid.NewString()
+	i.tokens[token] = i.Now().Add(time.Duration(ttl) * time.Second)
+
+	w.Header().Set(imdsTTLHeader, strconv.Itoa(ttl))
+	_, _ = w.Write([]byte(token))
+}
+
+// metadata returns the value served at the given path. The caller must hold the lock.
+func (i *Imds) metadata(path string) (string, bool) {
+
+	switch path {
+	case "iam/security-credentials", "iam/security-credentials/":
+		return i.Role, true
+	case "iam/security-credentials/" + i.Role:
+		now := i.Now().UTC()
+		b, _ := json.Marshal(map[string]string{
+			"Code":            "Success",
+			"Type":            "AWS-HMAC",
+			"AccessKeyId":     imdsCredentialKey,
+			"SecretAccessKey": imdsCredentialKey,
+			"Token":           imdsCredentialKey,
+			"LastUpdated":     now.Format(time.RFC3339),
+			"Expiration":      now.Add(6 * time.Hour).Format(time.RFC3339),
+		})
+		return string(b), true
+	}
+
+	value, ok := i.Metadata[path]
+	if !ok {
+		return "", false
+	}
+	return value, true
+}
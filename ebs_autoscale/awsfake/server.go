@@ -0,0 +1,627 @@
+package awsfake
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+	"github.com/google/uuid"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ec2Namespace = "http://ec2.amazonaws.com/doc/2016-11-15/"
+	stsNamespace = "https://sts.amazonaws.com/doc/2011-06-15/"
+	// isoTime is the timestamp format used by the query protocols
+	isoTime = "2006-01-02T15:04:05.000Z"
+)
+
+// Server speaks the EC2 and STS query protocols for the actions used by ebs-autoscale, backed by an Ec2 fake. Both
+// services are served from the same endpoint, requests are routed by their Action parameter.
+type Server struct {
+	Ec2 *Ec2
+	// Account the account id returned by GetCallerIdentity
+	Account string
+}
+
+// NewServer returns a Server backed by the given fake
+func NewServer(fake *Ec2, account string) *Server {
+	return &Server{
+		Ec2:     fake,
+		Account: account,
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	requestId := uuid.NewString()
+
+	if err := r.ParseForm(); err != nil {
+		writeError(w, requestId, apiError("MalformedQueryString", err.Error()))
+		return
+	}
+	action := r.Form.Get("Action")
+
+	slog.Debug(fmt.Sprintf("ServeHTTP: %s %v", action, r.Form))
+
+	var (
+		body any
+		err  error
+	)
+	switch action {
+	case "GetCallerIdentity":
+		body = s.getCallerIdentity(requestId)
+	case "DescribeVolumes":
+		body, err = s.describeVolumes(r.Context(), requestId, r.Form)
+	case "CreateVolume":
+		body, err = s.createVolume(r.Context(), requestId, r.Form)
+	case "AttachVolume":
+		body, err = s.attachVolume(r.Context(), requestId, r.Form)
+	case "DetachVolume":
+		body, err = s.detachVolume(r.Context(), requestId, r.Form)
+	case "DeleteVolume":
+		body, err = s.deleteVolume(r.Context(), requestId, r.Form)
+	case "ModifyVolume":
+		body, err = s.modifyVolume(r.Context(), requestId, r.Form)
+	case "DescribeVolumesModifications":
+		body, err = s.describeVolumesModifications(r.Context(), requestId, r.Form)
+	case "ModifyInstanceAttribute":
+		body, err = s.modifyInstanceAttribute(r.Context(), requestId, r.Form)
+	case "DescribeInstances":
+		body, err = s.describeInstances(r.Context(), requestId, r.Form)
+	case "DescribeTags":
+		body, err = s.describeTags(r.Context(), requestId, r.Form)
+	case "CreateTags":
+		body, err = s.createTags(r.Context(), requestId, r.Form)
+	default:
+		err = apiError("InvalidAction", fmt.Sprintf("The action %s is not valid for this web service.", action))
+	}
+	if err != nil {
+		writeError(w, requestId, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/xml;charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(body); err != nil {
+		slog.Error(fmt.Sprintf("ServeHTTP: encoding %s response: %s", action, err))
+	}
+}
+
+func (s *Server) getCallerIdentity(requestId string) any {
+	type result struct {
+		Arn     string
+		UserId  string
+		Account string
+	}
+	return struct {
+		XMLName xml.Name `xml:"GetCallerIdentityResponse"`
+		Xmlns   string   `xml:"xmlns,attr"`
+		Result  result   `xml:"GetCallerIdentityResult"`
+		Meta    struct {
+			RequestId string
+		} `xml:"ResponseMetadata"`
+	}{
+		Xmlns: stsNamespace,
+		Result: result{
+			Arn:     fmt.Sprintf("arn:aws:sts::%s:assumed-role/ebs-autoscale/standin", s.Account),
+			UserId:  "AROASTANDIN:standin",
+			Account: s.Account,
+		},
+		Meta: struct{ RequestId string }{RequestId: requestId},
+	}
+}
+
+func (s *Server) describeVolumes(ctx context.Context, requestId string, form url.Values) (any, error) {
+	out, err := s.Ec2.DescribeVolumes(ctx, &ec2.DescribeVolumesInput{
+		Filters:   parseFilters(form),
+		VolumeIds: parseStrings(form, "VolumeId"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	vols := make([]xmlVolume, 0, len(out.Volumes))
+	for _, v := range out.Volumes {
+		vols = append(vols, toXmlVolume(v))
+	}
+	return struct {
+		XMLName   xml.Name    `xml:"DescribeVolumesResponse"`
+		Xmlns     string      `xml:"xmlns,attr"`
+		RequestId string      `xml:"requestId"`
+		Volumes   []xmlVolume `xml:"volumeSet>item"`
+	}{Xmlns: ec2Namespace, RequestId: requestId, Volumes: vols}, nil
+}
+
+func (s *Server) createVolume(ctx context.Context, requestId string, form url.Values) (any, error) {
+	in := &ec2.CreateVolumeInput{
+		AvailabilityZone: optString(form, "AvailabilityZone"),
+		VolumeType:       types.VolumeType(form.Get("VolumeType")),
+	}
+	var err error
+	if in.Size, err = optInt32(form, "Size"); err != nil {
+		return nil, err
+	}
+	if in.Iops, err = optInt32(form, "Iops"); err != nil {
+		return nil, err
+	}
+	if in.Throughput, err = optInt32(form, "Throughput"); err != nil {
+		return nil, err
+	}
+	if in.Encrypted, err = optBool(form, "Encrypted"); err != nil {
+		return nil, err
+	}
+	for _, p := range listPrefixes(form, "TagSpecification") {
+		in.TagSpecifications = append(in.TagSpecifications, types.TagSpecification{
+			ResourceType: types.ResourceType(form.Get(p + ".ResourceType")),
+			Tags:         parseTags(form, p+".Tag"),
+		})
+	}
+
+	out, err := s.Ec2.CreateVolume(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	v := toXmlVolume(types.Volume{
+		Attachments:      out.Attachments,
+		AvailabilityZone: out.AvailabilityZone,
+		CreateTime:       out.CreateTime,
+		Encrypted:        out.Encrypted,
+		Iops:             out.Iops,
+		Size:             out.Size,
+		State:            out.State,
+		Tags:             out.Tags,
+		Throughput:       out.Throughput,
+		VolumeId:         out.VolumeId,
+		VolumeType:       out.VolumeType,
+	})
+	return struct {
+		XMLName   xml.Name `xml:"CreateVolumeResponse"`
+		Xmlns     string   `xml:"xmlns,attr"`
+		RequestId string   `xml:"requestId"`
+		xmlVolume
+	}{Xmlns: ec2Namespace, RequestId: requestId, xmlVolume: v}, nil
+}
+
+func (s *Server) attachVolume(ctx context.Context, requestId string, form url.Values) (any, error) {
+	out, err := s.Ec2.AttachVolume(ctx, &ec2.AttachVolumeInput{
+		Device:     optString(form, "Device"),
+		InstanceId: optString(form, "InstanceId"),
+		VolumeId:   optString(form, "VolumeId"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		XMLName   xml.Name `xml:"AttachVolumeResponse"`
+		Xmlns     string   `xml:"xmlns,attr"`
+		RequestId string   `xml:"requestId"`
+		xmlAttachment
+	}{Xmlns: ec2Namespace, RequestId: requestId, xmlAttachment: toXmlAttachment(types.VolumeAttachment{
+		AttachTime:          out.AttachTime,
+		DeleteOnTermination: out.DeleteOnTermination,
+		Device:              out.Device,
+		InstanceId:          out.InstanceId,
+		State:               out.State,
+		VolumeId:            out.VolumeId,
+	})}, nil
+}
+
+func (s *Server) detachVolume(ctx context.Context, requestId string, form url.Values) (any, error) {
+	out, err := s.Ec2.DetachVolume(ctx, &ec2.DetachVolumeInput{
+		VolumeId:   optString(form, "VolumeId"),
+		Device:     optString(form, "Device"),
+		InstanceId: optString(form, "InstanceId"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		XMLName   xml.Name `xml:"DetachVolumeResponse"`
+		Xmlns     string   `xml:"xmlns,attr"`
+		RequestId string   `xml:"requestId"`
+		xmlAttachment
+	}{Xmlns: ec2Namespace, RequestId: requestId, xmlAttachment: toXmlAttachment(types.VolumeAttachment{
+		AttachTime: out.AttachTime,
+		Device:     out.Device,
+		InstanceId: out.InstanceId,
+		State:      out.State,
+		VolumeId:   out.VolumeId,
+	})}, nil
+}
+
+func (s *Server) deleteVolume(ctx context.Context, requestId string, form url.Values) (any, error) {
+	_, err := s.Ec2.DeleteVolume(ctx, &ec2.DeleteVolumeInput{
+		VolumeId: optString(form, "VolumeId"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return returnResponse("DeleteVolumeResponse", requestId), nil
+}
+
+func (s *Server) modifyVolume(ctx context.Context, requestId string, form url.Values) (any, error) {
+	in := &ec2.ModifyVolumeInput{
+		VolumeId: optString(form, "VolumeId"),
+	}
+	var err error
+	if in.Size, err = optInt32(form, "Size"); err != nil {
+		return nil, err
+	}
+
+	out, err := s.Ec2.ModifyVolume(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		XMLName      xml.Name              `xml:"ModifyVolumeResponse"`
+		Xmlns        string                `xml:"xmlns,attr"`
+		RequestId    string                `xml:"requestId"`
+		Modification xmlVolumeModification `xml:"volumeModification"`
+	}{Xmlns: ec2Namespace, RequestId: requestId, Modification: toXmlVolumeModification(*out.VolumeModification)}, nil
+}
+
+func (s *Server) describeVolumesModifications(ctx context.Context, requestId string, form url.Values) (any, error) {
+	out, err := s.Ec2.DescribeVolumesModifications(ctx, &ec2.DescribeVolumesModificationsInput{
+		VolumeIds: parseStrings(form, "VolumeId"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	mods := make([]xmlVolumeModification, 0, len(out.VolumesModifications))
+	for _, m := range out.VolumesModifications {
+		mods = append(mods, toXmlVolumeModification(m))
+	}
+	return struct {
+		XMLName       xml.Name                `xml:"DescribeVolumesModificationsResponse"`
+		Xmlns         string                  `xml:"xmlns,attr"`
+		RequestId     string                  `xml:"requestId"`
+		Modifications []xmlVolumeModification `xml:"volumeModificationSet>item"`
+	}{Xmlns: ec2Namespace, RequestId: requestId, Modifications: mods}, nil
+}
+
+func (s *Server) modifyInstanceAttribute(ctx context.Context, requestId string, form url.Values) (any, error) {
+	in := &ec2.ModifyInstanceAttributeInput{
+		InstanceId: optString(form, "InstanceId"),
+	}
+	for _, p := range listPrefixes(form, "BlockDeviceMapping") {
+		m := types.InstanceBlockDeviceMappingSpecification{
+			DeviceName: optString(form, p+".DeviceName"),
+		}
+		if hasPrefix(form, p+".Ebs") {
+			deleteOnTermination, err := optBool(form, p+".Ebs.DeleteOnTermination")
+			if err != nil {
+				return nil, err
+			}
+			m.Ebs = &types.EbsInstanceBlockDeviceSpecification{
+				DeleteOnTermination: deleteOnTermination,
+				VolumeId:            optString(form, p+".Ebs.VolumeId"),
+			}
+		}
+		in.BlockDeviceMappings = append(in.BlockDeviceMappings, m)
+	}
+
+	_, err := s.Ec2.ModifyInstanceAttribute(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return returnResponse("ModifyInstanceAttributeResponse", requestId), nil
+}
+
+func (s *Server) createTags(ctx context.Context, requestId string, form url.Values) (any, error) {
+	_, err := s.Ec2.CreateTags(ctx, &ec2.CreateTagsInput{
+		Resources: parseStrings(form, "ResourceId"),
+		Tags:      parseTags(form, "Tag"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return returnResponse("CreateTagsResponse", requestId), nil
+}
+
+func (s *Server) describeInstances(ctx context.Context, requestId string, form url.Values) (any, error) {
+	out, err := s.Ec2.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: parseStrings(form, "InstanceId"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	type instanceState struct {
+		Code int32  `xml:"code"`
+		Name string `xml:"name"`
+	}
+	type instance struct {
+		InstanceId string        `xml:"instanceId"`
+		State      instanceState `xml:"instanceState"`
+		Tags       []xmlTag      `xml:"tagSet>item"`
+	}
+	type reservation struct {
+		ReservationId string     `xml:"reservationId"`
+		Instances     []instance `xml:"instancesSet>item"`
+	}
+	reservations := make([]reservation, 0, len(out.Reservations))
+	for _, r := range out.Reservations {
+		res := reservation{ReservationId: "r-" + strings.TrimPrefix(aws.ToString(r.Instances[0].InstanceId), "i-")}
+		for _, i := range r.Instances {
+			inst := instance{
+				InstanceId: aws.ToString(i.InstanceId),
+				State:      instanceState{Code: aws.ToInt32(i.State.Code), Name: string(i.State.Name)},
+			}
+			for _, t := range i.Tags {
+				inst.Tags = append(inst.Tags, xmlTag{Key: aws.ToString(t.Key), Value: aws.ToString(t.Value)})
+			}
+			res.Instances = append(res.Instances, inst)
+		}
+		reservations = append(reservations, res)
+	}
+	return struct {
+		XMLName      xml.Name      `xml:"DescribeInstancesResponse"`
+		Xmlns        string        `xml:"xmlns,attr"`
+		RequestId    string        `xml:"requestId"`
+		Reservations []reservation `xml:"reservationSet>item"`
+	}{Xmlns: ec2Namespace, RequestId: requestId, Reservations: reservations}, nil
+}
+
+func (s *Server) describeTags(ctx context.Context, requestId string, form url.Values) (any, error) {
+	out, err := s.Ec2.DescribeTags(ctx, &ec2.DescribeTagsInput{
+		Filters: parseFilters(form),
+	})
+	if err != nil {
+		return nil, err
+	}
+	type tagDescription struct {
+		ResourceId   string `xml:"resourceId"`
+		ResourceType string `xml:"resourceType"`
+		Key          string `xml:"key"`
+		Value        string `xml:"value"`
+	}
+	tags := make([]tagDescription, 0, len(out.Tags))
+	for _, t := range out.Tags {
+		tags = append(tags, tagDescription{
+			ResourceId:   aws.ToString(t.ResourceId),
+			ResourceType: string(t.ResourceType),
+			Key:          aws.ToString(t.Key),
+			Value:        aws.ToString(t.Value),
+		})
+	}
+	return struct {
+		XMLName   xml.Name         `xml:"DescribeTagsResponse"`
+		Xmlns     string           `xml:"xmlns,attr"`
+		RequestId string           `xml:"requestId"`
+		Tags      []tagDescription `xml:"tagSet>item"`
+	}{Xmlns: ec2Namespace, RequestId: requestId, Tags: tags}, nil
+}
+
+type xmlTag struct {
+	Key   string `xml:"key"`
+	Value string `xml:"value"`
+}
+
+type xmlAttachment struct {
+	VolumeId            string `xml:"volumeId,omitempty"`
+	InstanceId          string `xml:"instanceId,omitempty"`
+	Device              string `xml:"device,omitempty"`
+	Status              string `xml:"status,omitempty"`
+	AttachTime          string `xml:"attachTime,omitempty"`
+	DeleteOnTermination *bool  `xml:"deleteOnTermination,omitempty"`
+}
+
+type xmlVolume struct {
+	VolumeId         string          `xml:"volumeId"`
+	Size             *int32          `xml:"size,omitempty"`
+	AvailabilityZone string          `xml:"availabilityZone,omitempty"`
+	Status           string          `xml:"status"`
+	CreateTime       string          `xml:"createTime,omitempty"`
+	Attachments      []xmlAttachment `xml:"attachmentSet>item"`
+	Tags             []xmlTag        `xml:"tagSet>item"`
+	VolumeType       string          `xml:"volumeType,omitempty"`
+	Encrypted        bool            `xml:"encrypted"`
+	Iops             *int32          `xml:"iops,omitempty"`
+	Throughput       *int32          `xml:"throughput,omitempty"`
+}
+
+func toXmlVolume(v types.Volume) xmlVolume {
+	x := xmlVolume{
+		VolumeId:         aws.ToString(v.VolumeId),
+		Size:             v.Size,
+		AvailabilityZone: aws.ToString(v.AvailabilityZone),
+		Status:           string(v.State),
+		CreateTime:       formatTime(v.CreateTime),
+		VolumeType:       string(v.VolumeType),
+		Encrypted:        aws.ToBool(v.Encrypted),
+		Iops:             v.Iops,
+		Throughput:       v.Throughput,
+	}
+	for _, a := range v.Attachments {
+		x.Attachments = append(x.Attachments, toXmlAttachment(a))
+	}
+	for _, t := range v.Tags {
+		x.Tags = append(x.Tags, xmlTag{Key: aws.ToString(t.Key), Value: aws.ToString(t.Value)})
+	}
+	return x
+}
+
+func toXmlAttachment(a types.VolumeAttachment) xmlAttachment {
+	return xmlAttachment{
+		VolumeId:            aws.ToString(a.VolumeId),
+		InstanceId:          aws.ToString(a.InstanceId),
+		Device:              aws.ToString(a.Device),
+		Status:              string(a.State),
+		AttachTime:          formatTime(a.AttachTime),
+		DeleteOnTermination: a.DeleteOnTermination,
+	}
+}
+
+type xmlVolumeModification struct {
+	VolumeId           string `xml:"volumeId"`
+	ModificationState  string `xml:"modificationState"`
+	StatusMessage      string `xml:"statusMessage,omitempty"`
+	TargetSize         *int32 `xml:"targetSize,omitempty"`
+	TargetVolumeType   string `xml:"targetVolumeType,omitempty"`
+	OriginalSize       *int32 `xml:"originalSize,omitempty"`
+	OriginalVolumeType string `xml:"originalVolumeType,omitempty"`
+	Progress           *int64 `xml:"progress,omitempty"`
+	StartTime          string `xml:"startTime,omitempty"`
+	EndTime            string `xml:"endTime,omitempty"`
+}
+
+func toXmlVolumeModification(m types.VolumeModification) xmlVolumeModification {
+	return xmlVolumeModification{
+		VolumeId:           aws.ToString(m.VolumeId),
+		ModificationState:  string(m.ModificationState),
+		StatusMessage:      aws.ToString(m.StatusMessage),
+		TargetSize:         m.TargetSize,
+		TargetVolumeType:   string(m.TargetVolumeType),
+		OriginalSize:       m.OriginalSize,
+		OriginalVolumeType: string(m.OriginalVolumeType),
+		Progress:           m.Progress,
+		StartTime:          formatTime(m.StartTime),
+		EndTime:            formatTime(m.EndTime),
+	}
+}
+
+// returnResponse builds the <return>true</return> response used by actions with no other output
+func returnResponse(name string, requestId string) any {
+	return struct {
+		XMLName   xml.Name
+		Xmlns     string `xml:"xmlns,attr"`
+		RequestId string `xml:"requestId"`
+		Return    bool   `xml:"return"`
+	}{XMLName: xml.Name{Local: name}, Xmlns: ec2Namespace, RequestId: requestId, Return: true}
+}
+
+// writeError writes an error in the EC2 query error format. Errors that are not API errors are reported as server
+// faults.
+func writeError(w http.ResponseWriter, requestId string, err error) {
+
+	status := http.StatusBadRequest
+	code, message := "InternalError", err.Error()
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		code, message = apiErr.ErrorCode(), apiErr.ErrorMessage()
+		if apiErr.ErrorFault() == smithy.FaultServer {
+			status = http.StatusInternalServerError
+		}
+		if code == "RequestLimitExceeded" {
+			status = http.StatusServiceUnavailable
+		}
+	} else {
+		status = http.StatusInternalServerError
+	}
+
+	type xmlError struct {
+		Code    string
+		Message string
+	}
+	body := struct {
+		XMLName   xml.Name   `xml:"Response"`
+		Errors    []xmlError `xml:"Errors>Error"`
+		RequestID string
+	}{
+		Errors:    []xmlError{{Code: code, Message: message}},
+		RequestID: requestId,
+	}
+
+	w.Header().Set("Content-Type", "text/xml;charset=UTF-8")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(xml.Header))
+	_ = xml.NewEncoder(w).Encode(body)
+}
+
+// listPrefixes returns the prefixes of the members of a flattened query list i.e. Filter.1, Filter.2...
+func listPrefixes(form url.Values, prefix string) []string {
+	var prefixes []string
+	for i := 1; ; i++ {
+		p := fmt.Sprintf("%s.%d", prefix, i)
+		if !hasPrefix(form, p) {
+			return prefixes
+		}
+		prefixes = append(prefixes, p)
+	}
+}
+
+// hasPrefix reports whether the form holds the key or any member of it
+func hasPrefix(form url.Values, prefix string) bool {
+	for k := range form {
+		if k == prefix || strings.HasPrefix(k, prefix+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func parseStrings(form url.Values, prefix string) []string {
+	var values []string
+	for _, p := range listPrefixes(form, prefix) {
+		values = append(values, form.Get(p))
+	}
+	return values
+}
+
+func parseFilters(form url.Values) []types.Filter {
+	var filters []types.Filter
+	for _, p := range listPrefixes(form, "Filter") {
+		filters = append(filters, types.Filter{
+			Name:   optString(form, p+".Name"),
+			Values: parseStrings(form, p+".Value"),
+		})
+	}
+	return filters
+}
+
+func parseTags(form url.Values, prefix string) []types.Tag {
+	var tags []types.Tag
+	for _, p := range listPrefixes(form, prefix) {
+		tags = append(tags, types.Tag{
+			Key:   optString(form, p+".Key"),
+			Value: aws.String(form.Get(p + ".Value")),
+		})
+	}
+	return tags
+}
+
+func optString(form url.Values, key string) *string {
+	if !form.Has(key) {
+		return nil
+	}
+	return aws.String(form.Get(key))
+}
+
+func optInt32(form url.Values, key string) (*int32, error) {
+	if !form.Has(key) {
+		return nil, nil
+	}
+	i, err := strconv.ParseInt(form.Get(key), 10, 32)
+	if err != nil {
+		return nil, apiError("InvalidParameterValue", fmt.Sprintf("Invalid value '%s' for %s", form.Get(key), key))
+	}
+	return aws.Int32(int32(i)), nil
+}
+
+func optBool(form url.Values, key string) (*bool, error) {
+	if !form.Has(key) {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(form.Get(key))
+	if err != nil {
+		return nil, apiError("InvalidParameterValue", fmt.Sprintf("Invalid value '%s' for %s", form.Get(key), key))
+	}
+	return aws.Bool(b), nil
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(isoTime)
+}
@@ -0,0 +1,239 @@
+package awsfake
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	"gotest.tools/assert"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestClients starts a Server and returns real sdk clients pointed at it
+func newTestClients(t *testing.T) (*Ec2, *ec2.Client, *sts.Client) {
+
+	fake := NewEc2()
+	srv := httptest.NewServer(NewServer(fake, "123456789012"))
+	t.Cleanup(srv.Close)
+
+	ec2Client := ec2.New(ec2.Options{
+		Region:           "ap-southeast-2",
+		BaseEndpoint:     aws.String(srv.URL),
+		Credentials:      aws.AnonymousCredentials{},
+		RetryMaxAttempts: 1,
+	})
+	stsClient := sts.New(sts.Options{
+		Region:       "ap-southeast-2",
+		BaseEndpoint: aws.String(srv.URL),
+		Credentials:  aws.AnonymousCredentials{},
+	})
+	return fake, ec2Client, stsClient
+}
+
+func TestServerVolumeLifecycle(t *testing.T) {
+
+	ctx := context.Background()
+	fake, client, _ := newTestClients(t)
+
+	created, err := client.CreateVolume(ctx, &ec2.CreateVolumeInput{
+		AvailabilityZone: aws.String("ap-southeast-2a"),
+		VolumeType:       types.VolumeTypeGp3,
+		Size:             aws.Int32(50),
+		Throughput:       aws.Int32(150),
+		TagSpecifications: []types.TagSpecification{
+			{
+				ResourceType: types.ResourceTypeVolume,
+				Tags: []types.Tag{
+					{Key: aws.String("ebs-autoscale-id"), Value: aws.String("vol_id")},
+					{Key: aws.String("source-instance"), Value: aws.String("i-1")},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume Returned an unexpected error: %s", err)
+	}
+	assert.Equal(t, *created.Size, int32(50))
+	assert.Equal(t, *created.Throughput, int32(150))
+	assert.Equal(t, created.VolumeType, types.VolumeTypeGp3)
+	assert.Equal(t, len(created.Tags), 2)
+
+	// The sdk waiter must be satisfied by the stand-in
+	err = ec2.NewVolumeAvailableWaiter(client).Wait(ctx, &ec2.DescribeVolumesInput{
+		VolumeIds: []string{*created.VolumeId},
+	}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("VolumeAvailableWaiter Returned an unexpected error: %s", err)
+	}
+
+	attached, err := client.AttachVolume(ctx, &ec2.AttachVolumeInput{
+		Device:     aws.String("/dev/xvdba"),
+		InstanceId: aws.String("i-1"),
+		VolumeId:   created.VolumeId,
+	})
+	if err != nil {
+		t.Fatalf("AttachVolume Returned an unexpected error: %s", err)
+	}
+	assert.Equal(t, *attached.Device, "/dev/xvdba")
+
+	_, err = client.ModifyInstanceAttribute(ctx, &ec2.ModifyInstanceAttributeInput{
+		InstanceId: aws.String("i-1"),
+		BlockDeviceMappings: []types.InstanceBlockDeviceMappingSpecification{
+			{
+				DeviceName: aws.String("/dev/xvdba"),
+				Ebs: &types.EbsInstanceBlockDeviceSpecification{
+					DeleteOnTermination: aws.Bool(true),
+					VolumeId:            created.VolumeId,
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("ModifyInstanceAttribute Returned an unexpected error: %s", err)
+	}
+
+	described, err := client.DescribeVolumes(ctx, &ec2.DescribeVolumesInput{
+		Filters: []types.Filter{
+			{Name: aws.String("attachment.instance-id"), Values: []string{"i-1"}},
+			{Name: aws.String("tag:ebs-autoscale-id"), Values: []string{"vol_id"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("DescribeVolumes Returned an unexpected error: %s", err)
+	}
+	assert.Equal(t, len(described.Volumes), 1)
+	vol := described.Volumes[0]
+	assert.Equal(t, vol.State, types.VolumeStateInUse)
+	assert.Equal(t, len(vol.Attachments), 1)
+	assert.Equal(t, *vol.Attachments[0].DeleteOnTermination, true)
+	assert.Equal(t, vol.Attachments[0].State, types.VolumeAttachmentStateAttached)
+	assert.Equal(t, *vol.Tags[0].Value, "vol_id")
+	assert.Assert(t, vol.CreateTime != nil)
+
+	// Existing tags are replaced and new tags appended
+	_, err = client.CreateTags(ctx, &ec2.CreateTagsInput{
+		Resources: []string{*created.VolumeId},
+		Tags: []types.Tag{
+			{Key: aws.String("source-instance"), Value: aws.String("i-2")},
+			{Key: aws.String("filesystem"), Value: aws.String("scratch")},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateTags Returned an unexpected error: %s", err)
+	}
+	tagged, _ := fake.Volume(*created.VolumeId)
+	assert.Equal(t, len(tagged.Tags), 3)
+	assert.Equal(t, *tagged.Tags[1].Value, "i-2")
+
+	// A volume never modified has no modification to describe
+	_, err = client.DescribeVolumesModifications(ctx, &ec2.DescribeVolumesModificationsInput{VolumeIds: []string{*created.VolumeId}})
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) || apiErr.ErrorCode() != "InvalidVolumeModification.NotFound" {
+		t.Errorf("DescribeVolumesModifications Expected InvalidVolumeModification.NotFound Got: %s", err)
+	}
+
+	modified, err := client.ModifyVolume(ctx, &ec2.ModifyVolumeInput{VolumeId: created.VolumeId, Size: aws.Int32(80)})
+	if err != nil {
+		t.Fatalf("ModifyVolume Returned an unexpected error: %s", err)
+	}
+	assert.Equal(t, *modified.VolumeModification.OriginalSize, int32(50))
+	assert.Equal(t, *modified.VolumeModification.TargetSize, int32(80))
+
+	mods, err := client.DescribeVolumesModifications(ctx, &ec2.DescribeVolumesModificationsInput{VolumeIds: []string{*created.VolumeId}})
+	if err != nil {
+		t.Fatalf("DescribeVolumesModifications Returned an unexpected error: %s", err)
+	}
+	assert.Equal(t, len(mods.VolumesModifications), 1)
+	assert.Equal(t, mods.VolumesModifications[0].ModificationState, types.VolumeModificationStateOptimizing)
+	assert.Assert(t, mods.VolumesModifications[0].StartTime != nil)
+	resized, _ := fake.Volume(*created.VolumeId)
+	assert.Equal(t, *resized.Size, int32(80))
+
+	// Volumes cannot shrink
+	_, err = client.ModifyVolume(ctx, &ec2.ModifyVolumeInput{VolumeId: created.VolumeId, Size: aws.Int32(60)})
+	if !errors.As(err, &apiErr) || apiErr.ErrorCode() != "InvalidParameterValue" {
+		t.Errorf("ModifyVolume Expected InvalidParameterValue Got: %s", err)
+	}
+
+	_, err = client.DetachVolume(ctx, &ec2.DetachVolumeInput{VolumeId: created.VolumeId})
+	if err != nil {
+		t.Fatalf("DetachVolume Returned an unexpected error: %s", err)
+	}
+	_, err = client.DeleteVolume(ctx, &ec2.DeleteVolumeInput{VolumeId: created.VolumeId})
+	if err != nil {
+		t.Fatalf("DeleteVolume Returned an unexpected error: %s", err)
+	}
+	assert.Equal(t, len(fake.Volumes()), 0)
+}
+
+func TestServerErrors(t *testing.T) {
+
+	ctx := context.Background()
+	fake, client, _ := newTestClients(t)
+
+	_, err := client.DeleteVolume(ctx, &ec2.DeleteVolumeInput{VolumeId: aws.String("vol-missing")})
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("DeleteVolume Expected an api error Got: %s", err)
+	}
+	assert.Equal(t, apiErr.ErrorCode(), "InvalidVolume.NotFound")
+
+	fake.FailNext("DescribeVolumes", apiError("UnauthorizedOperation", "You are not authorized to perform this operation."))
+	_, err = client.DescribeVolumes(ctx, &ec2.DescribeVolumesInput{})
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("DescribeVolumes Expected an api error Got: %s", err)
+	}
+	assert.Equal(t, apiErr.ErrorCode(), "UnauthorizedOperation")
+}
+
+func TestServerDescribeTagsAndCallerIdentity(t *testing.T) {
+
+	ctx := context.Background()
+	fake, client, stsClient := newTestClients(t)
+
+	fake.PutInstance("i-1", []types.Tag{
+		{Key: aws.String("Name"), Value: aws.String("worker")},
+		{Key: aws.String("aws:autoscaling:groupName"), Value: aws.String("asg")},
+	})
+	fake.PutInstance("i-2", []types.Tag{
+		{Key: aws.String("Name"), Value: aws.String("other")},
+	})
+
+	tags, err := client.DescribeTags(ctx, &ec2.DescribeTagsInput{
+		Filters: []types.Filter{
+			{Name: aws.String("resource-id"), Values: []string{"i-1"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("DescribeTags Returned an unexpected error: %s", err)
+	}
+	assert.Equal(t, len(tags.Tags), 2)
+	assert.Equal(t, *tags.Tags[0].Value, "worker")
+	assert.Equal(t, tags.Tags[0].ResourceType, types.ResourceTypeInstance)
+
+	fake.TerminateInstance("i-2")
+	instances, err := client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{InstanceIds: []string{"i-1", "i-2"}})
+	if err != nil {
+		t.Fatalf("DescribeInstances Returned an unexpected error: %s", err)
+	}
+	assert.Equal(t, len(instances.Reservations), 2)
+	assert.Equal(t, instances.Reservations[0].Instances[0].State.Name, types.InstanceStateNameRunning)
+	assert.Equal(t, instances.Reservations[1].Instances[0].State.Name, types.InstanceStateNameTerminated)
+
+	_, err = client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{InstanceIds: []string{"i-unknown"}})
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) || apiErr.ErrorCode() != "InvalidInstanceID.NotFound" {
+		t.Errorf("DescribeInstances Expected InvalidInstanceID.NotFound Got: %s", err)
+	}
+
+	identity, err := stsClient.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		t.Fatalf("GetCallerIdentity Returned an unexpected error: %s", err)
+	}
+	assert.Equal(t, *identity.Account, "123456789012")
+}
@@ -3,10 +3,35 @@
 package ebs_autoscale
 
 import (
+	"fmt"
 	"gopkg.in/yaml.v3"
 	"os"
+	"time"
 )
 
+// Duration is a time.Duration read from either a Go duration string such as "500ms", or a number of seconds
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+
+	var seconds float64
+	if err := value.Decode(&seconds); err == nil {
+		*d = Duration(seconds * float64(time.Second))
+		return nil
+	}
+
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration: %s: %w", s, err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
 type LoggingCfg struct {
 	LogGroupName     string `yaml:"log-group-name" envconfig:"EBS_AUTO_LOGGING_LOG_GROUP_NAME"`
 	PollIntervalSecs uint32 `yaml:"poll-interval" envconfig:"EBS_AUTO_LOGGING_POLL_INTERVAL_SEC" default:"5"`
@@ -14,9 +39,90 @@ type LoggingCfg struct {
 	Loglevel         string `yaml:"log-level" envconfig:"EBS_AUTO_LOGGING_LOG_LEVEL" default:"INFO"`
 }
 
+type AwsCfg struct {
+	// EndpointUrl overrides the ec2 and sts endpoints, i.e. to point the clients at a local stand-in
+	EndpointUrl string `yaml:"endpoint-url" envconfig:"EBS_AUTO_AWS_ENDPOINT_URL"`
+}
+
+type ImdsCfg struct {
+	// Endpoint overrides the instance metadata service endpoint, i.e. to point at a local stand-in
+	Endpoint    string `yaml:"endpoint" envconfig:"EBS_AUTO_HOST_IMDS_ENDPOINT"`
+	MaxAttempts uint32 `yaml:"max-attempts" envconfig:"EBS_AUTO_HOST_IMDS_MAX_ATTEMPTS" default:"5"`
+	TimeoutSecs uint32 `yaml:"timeout" envconfig:"EBS_AUTO_HOST_IMDS_TIMEOUT_SEC" default:"2"`
+}
+
+type HostCfg struct {
+	// InstanceId and AvailabilityZone, when both set, are used in place of the instance metadata service
+	InstanceId       string `yaml:"instance-id" envconfig:"EBS_AUTO_HOST_INSTANCE_ID"`
+	AvailabilityZone string `yaml:"availability-zone" envconfig:"EBS_AUTO_HOST_AVAILABILITY_ZONE"`
+	// AccountId when set is used in place of sts GetCallerIdentity
+	AccountId string  `yaml:"account-id" envconfig:"EBS_AUTO_HOST_ACCOUNT_ID"`
+	Imds      ImdsCfg `yaml:"imds"`
+}
+
+type PredictiveCfg struct {
+	// Enabled grows ahead of the threshold when the filesystem is projected to fill before a grow could complete
+	Enabled bool `yaml:"enabled" envconfig:"EBS_AUTO_MONITOR_PREDICTIVE_ENABLED"`
+	// Window the seconds of usage samples the fill rate is estimated from
+	Window int32 `yaml:"window" envconfig:"EBS_AUTO_MONITOR_PREDICTIVE_WINDOW" default:"60"`
+	// ProvisioningLatency the seconds a grow is expected to take, SafetyMargin the seconds allowed on top of it
+	ProvisioningLatency int32 `yaml:"provisioning-latency" envconfig:"EBS_AUTO_MONITOR_PREDICTIVE_PROVISIONING_LATENCY" default:"70"`
+	SafetyMargin        int32 `yaml:"safety-margin" envconfig:"EBS_AUTO_MONITOR_PREDICTIVE_SAFETY_MARGIN" default:"30"`
+	// Horizon the seconds of projected writes a grow must make room for
+	Horizon int32 `yaml:"horizon" envconfig:"EBS_AUTO_MONITOR_PREDICTIVE_HORIZON" default:"600"`
+}
+
+type TierCfg struct {
+	ThresholdPc float32 `yaml:"threshold-pc"`
+	// SizeGb or Sizing when set replace the filesystem sizing policy for the grows of the tier
+	SizeGb int32      `yaml:"size-gb"`
+	Sizing *SizingCfg `yaml:"sizing"`
+	// Interval when set replaces the monitor interval while usage is within the tier
+	Interval Duration `yaml:"interval"`
+}
+
 type MonitorCfg struct {
-	Interval    int32   `yaml:"interval" envconfig:"EBS_AUTO_MONITOR_INTERVAL" default:"3"`
-	ThresholdPc float32 `yaml:"threshold-pc" envconfig:"EBS_AUTO_MONITOR_THRESHOLD_PC" default:"50"`
+	Interval    Duration      `yaml:"interval" envconfig:"EBS_AUTO_MONITOR_INTERVAL" default:"3s"`
+	ThresholdPc float32       `yaml:"threshold-pc" envconfig:"EBS_AUTO_MONITOR_THRESHOLD_PC" default:"50"`
+	Predictive  PredictiveCfg `yaml:"predictive"`
+	// MaxInterval when set, the interval adapts between MinInterval and MaxInterval to the usage and fill rate
+	MinInterval Duration `yaml:"min-interval" envconfig:"EBS_AUTO_MONITOR_MIN_INTERVAL" default:"250ms"`
+	MaxInterval Duration `yaml:"max-interval" envconfig:"EBS_AUTO_MONITOR_MAX_INTERVAL"`
+	// Tiers when set replace ThresholdPc, the highest tier the usage has reached triggers the grow
+	Tiers []TierCfg `yaml:"tiers"`
+	// MinFreeGb and MinHoursToFull when set also trigger a grow, combined with the usage threshold by TriggerMode
+	MinFreeGb      int32   `yaml:"min-free-gb" envconfig:"EBS_AUTO_MONITOR_MIN_FREE_GB"`
+	MinHoursToFull float32 `yaml:"min-hours-to-full" envconfig:"EBS_AUTO_MONITOR_MIN_HOURS_TO_FULL"`
+	TriggerMode    string  `yaml:"trigger-mode" envconfig:"EBS_AUTO_MONITOR_TRIGGER_MODE" default:"any"`
+	// MetadataThresholdPc when set also triggers a grow once the filesystem metadata usage reaches it
+	MetadataThresholdPc float32 `yaml:"metadata-threshold-pc" envconfig:"EBS_AUTO_MONITOR_METADATA_THRESHOLD_PC"`
+	// InodeThresholdPc when set also triggers a grow once the inode usage reaches it, or an alert where growing does not
+	// add inodes
+	InodeThresholdPc float32 `yaml:"inode-threshold-pc" envconfig:"EBS_AUTO_MONITOR_INODE_THRESHOLD_PC"`
+	// Cooldown the seconds after a grow before another is allowed, giving the filesystem usage time to settle, 0 for no
+	// cooldown
+	Cooldown int32 `yaml:"cooldown" envconfig:"EBS_AUTO_MONITOR_COOLDOWN" default:"60"`
+	// RearmPc when set, the usage threshold only triggers again once usage has fallen below it since the last grow
+	RearmPc float32 `yaml:"rearm-pc" envconfig:"EBS_AUTO_MONITOR_REARM_PC"`
+	// MaxGrowsPerHour and MaxGrowsPerDay limit the grows in any hour or day, 0 for no limit
+	MaxGrowsPerHour int32 `yaml:"max-grows-per-hour" envconfig:"EBS_AUTO_MONITOR_MAX_GROWS_PER_HOUR"`
+	MaxGrowsPerDay  int32 `yaml:"max-grows-per-day" envconfig:"EBS_AUTO_MONITOR_MAX_GROWS_PER_DAY"`
+	// MaxBackoff the longest delay in seconds between assessments while they fail with retryable errors
+	MaxBackoff int32 `yaml:"max-backoff" envconfig:"EBS_AUTO_MONITOR_MAX_BACKOFF" default:"300"`
+	// BreakerFailures the consecutive failed grows that stop grows for BreakerReset seconds, 0 to never stop them
+	BreakerFailures int32 `yaml:"breaker-failures" envconfig:"EBS_AUTO_MONITOR_BREAKER_FAILURES" default:"5"`
+	BreakerReset    int32 `yaml:"breaker-reset" envconfig:"EBS_AUTO_MONITOR_BREAKER_RESET" default:"1800"`
+}
+
+type GcCfg struct {
+	// Enabled runs garbage collection periodically from the monitor, the gc command runs regardless
+	Enabled     bool  `yaml:"enabled" envconfig:"EBS_AUTO_GC_ENABLED"`
+	Interval    int32 `yaml:"interval" envconfig:"EBS_AUTO_GC_INTERVAL" default:"3600"`
+	GracePeriod int32 `yaml:"grace-period" envconfig:"EBS_AUTO_GC_GRACE_PERIOD" default:"86400"`
+	DryRun      bool  `yaml:"dry-run" envconfig:"EBS_AUTO_GC_DRY_RUN"`
+	// AllowList the ebs-autoscale-id values whose volumes may be collected, "*" allows any. Defaults to the id of the
+	// configured filesystem.
+	AllowList []string `yaml:"allow-list" envconfig:"EBS_AUTO_GC_ALLOW_LIST"`
 }
 
 type BackendCfg struct {
@@ -24,28 +130,72 @@ type BackendCfg struct {
 	FsSpecific map[string]interface{} `yaml:"fs-specific" envconfig:"EBS_AUTO_FILESYSTEM_FS_SPECIFIC"`
 }
 
+type ProviderCfg struct {
+	Type    string                 `yaml:"type" envconfig:"EBS_AUTO_FILESYSTEM_PROVIDER_TYPE" default:"ebs"`
+	Options map[string]interface{} `yaml:"options" envconfig:"EBS_AUTO_FILESYSTEM_PROVIDER_OPTIONS"`
+}
+
+type SizingCfg struct {
+	Type    string                 `yaml:"type" envconfig:"EBS_AUTO_FILESYSTEM_SIZING_TYPE" default:"even"`
+	Options map[string]interface{} `yaml:"options" envconfig:"EBS_AUTO_FILESYSTEM_SIZING_OPTIONS"`
+}
+
 type VolumeCfg struct {
-	MountPoint            string `yaml:"path" envconfig:"EBS_AUTO_FILESYSTEM_PATH" default:"/mnt/ebs-autoscale"`
-	EbsType               string `yaml:"ebs-type" envconfig:"EBS_AUTO_FILESYSTEM_EBS_TYPE" default:"gp3"`
-	EbsThroughput         *int32 `yaml:"ebs-throughput" envconfig:"EBS_AUTO_FILESYSTEM_EBS_THROUGHPUT"`
-	EbsIops               *int32 `yaml:"ebs-ipos" envconfig:"EBS_AUTO_FILESYSTEM_EBS_IOPST"`
-	InitialSizeGb         int32  `yaml:"initial-size-gb" envconfig:"EBS_AUTO_FILESYSTEM_INITIAL_SIZE" default:"100"`
-	MaxSizeGb             int32  `yaml:"max-size-gb" envconfig:"EBS_AUTO_FILESYSTEM_MAX_SIZE" default:"500"`
-	EbsMaxAttachedVolumes int32  `yaml:"ebs-max-attached-volumes" envconfig:"EBS_AUTO_FILESYSTEM_MAX_ATTACHED_VOLUMES" default:"16"`
-	EbsMaxCreatedVolumes  int32  `yaml:"ebs-max-created-volumes" envconfig:"EBS_AUTO_FILESYSTEM_MAX_CREATED_VOLUMES" default:"5"`
+	MountPoint            string       `yaml:"path" envconfig:"EBS_AUTO_FILESYSTEM_PATH" default:"/mnt/ebs-autoscale"`
+	EbsType               string       `yaml:"ebs-type" envconfig:"EBS_AUTO_FILESYSTEM_EBS_TYPE" default:"gp3"`
+	EbsThroughput         *int32       `yaml:"ebs-throughput" envconfig:"EBS_AUTO_FILESYSTEM_EBS_THROUGHPUT"`
+	EbsIops               *int32       `yaml:"ebs-ipos" envconfig:"EBS_AUTO_FILESYSTEM_EBS_IOPST"`
+	InitialSizeGb         int32        `yaml:"initial-size-gb" envconfig:"EBS_AUTO_FILESYSTEM_INITIAL_SIZE" default:"100"`
+	MaxSizeGb             int32        `yaml:"max-size-gb" envconfig:"EBS_AUTO_FILESYSTEM_MAX_SIZE" default:"500"`
+	EbsMaxAttachedVolumes int32        `yaml:"ebs-max-attached-volumes" envconfig:"EBS_AUTO_FILESYSTEM_MAX_ATTACHED_VOLUMES" default:"16"`
+	EbsMaxCreatedVolumes  int32        `yaml:"ebs-max-created-volumes" envconfig:"EBS_AUTO_FILESYSTEM_MAX_CREATED_VOLUMES" default:"5"`
+	DevicePrefix          string       `yaml:"device-prefix" envconfig:"EBS_AUTO_FILESYSTEM_DEVICE_PREFIX" default:"/dev/xvdb"`
+	RepairPolicy          string       `yaml:"repair-policy" envconfig:"EBS_AUTO_FILESYSTEM_REPAIR_POLICY" default:"adopt"`
+	GrowStrategy          string       `yaml:"grow-strategy" envconfig:"EBS_AUTO_FILESYSTEM_GROW_STRATEGY" default:"attach"`
 	Backend               *BackendCfg  `yaml:"backend"`
+	Provider              *ProviderCfg `yaml:"provider"`
+	Sizing                *SizingCfg   `yaml:"sizing"`
+	// Persistent volumes outlive the instance and are identified by FilesystemName, so a replacement instance can
+	// re-attach them
+	Persistent     bool   `yaml:"persistent" envconfig:"EBS_AUTO_FILESYSTEM_PERSISTENT"`
+	FilesystemName string `yaml:"filesystem-name" envconfig:"EBS_AUTO_FILESYSTEM_NAME"`
 }
 
 type Config struct {
-	Logging *LoggingCfg `yaml:"logging"`
-	Monitor MonitorCfg  `yaml:"monitor"`
-	Volume  VolumeCfg   `yaml:"filesystem"`
+	// StateDir is where the operation journal is kept
+	StateDir string      `yaml:"state-dir" envconfig:"EBS_AUTO_STATE_DIR" default:"/var/lib/ebs-autoscale"`
+	Aws      AwsCfg      `yaml:"aws"`
+	Host     HostCfg     `yaml:"host"`
+	Logging  *LoggingCfg `yaml:"logging"`
+	Monitor  MonitorCfg  `yaml:"monitor"`
+	Gc       GcCfg       `yaml:"gc"`
+	Volume   VolumeCfg   `yaml:"filesystem"`
 }
 
 // NewConfig marshals the given path into a Config object. It will then look at environment variables for values to
 // override.
 func NewConfig(path string) (*Config, error) {
-	var cfg Config
+	// the defaults of the numbers are set before the file is read, so a 0 in it is kept rather than defaulted
+	cfg := Config{
+		Monitor: MonitorCfg{
+			Interval:    Duration(defaultPollInterval),
+			MinInterval: Duration(defaultMinPollInterval),
+			Predictive: PredictiveCfg{
+				Window:              defaultPredictiveWindowSec,
+				ProvisioningLatency: defaultProvisioningLatencySec,
+				SafetyMargin:        defaultSafetyMarginSec,
+				Horizon:             defaultPredictiveHorizonSec,
+			},
+			Cooldown:        defaultGrowCooldownSec,
+			MaxBackoff:      defaultMaxBackoffSec,
+			BreakerFailures: defaultBreakerFailures,
+			BreakerReset:    defaultBreakerResetSec,
+		},
+		Gc: GcCfg{
+			Interval:    defaultGcIntervalSec,
+			GracePeriod: defaultGcGracePeriodSec,
+		},
+	}
 	err := readFile(&cfg, path)
 	if err != nil {
 		return nil, err
@@ -66,6 +216,47 @@ func NewConfig(path string) (*Config, error) {
 		cfg.Volume.Backend.Type = "btrfs"
 	}
 
+	// Initialize Provider to the ebs provider if not provided
+	if cfg.Volume.Provider == nil {
+		cfg.Volume.Provider = &ProviderCfg{}
+	}
+	if cfg.Volume.Provider.Type == "" {
+		cfg.Volume.Provider.Type = "ebs"
+	}
+	if cfg.Volume.Provider.Options == nil {
+		cfg.Volume.Provider.Options = make(map[string]interface{})
+	}
+
+	// Initialize Sizing to the even policy if not provided
+	if cfg.Volume.Sizing == nil {
+		cfg.Volume.Sizing = &SizingCfg{}
+	}
+	if cfg.Volume.Sizing.Type == "" {
+		cfg.Volume.Sizing.Type = defaultSizingPolicy
+	}
+	if cfg.Volume.Sizing.Options == nil {
+		cfg.Volume.Sizing.Options = make(map[string]interface{})
+	}
+
+	if cfg.Volume.RepairPolicy == "" {
+		cfg.Volume.RepairPolicy = defaultRepairPolicy
+	}
+	if cfg.Volume.GrowStrategy == "" {
+		cfg.Volume.GrowStrategy = defaultGrowStrategy
+	}
+
+	if cfg.Monitor.TriggerMode == "" {
+		cfg.Monitor.TriggerMode = defaultTriggerMode
+	}
+
+	if cfg.StateDir == "" {
+		cfg.StateDir = defaultStateDir
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
 	// TODO this is not working as expected...
 	//err = readEnv(&cfg)
 	//if err != nil {
@@ -74,6 +265,48 @@ func NewConfig(path string) (*Config, error) {
 	return &cfg, nil
 }
 
+// validate rejects the numbers out of range, rather than leaving the monitor to fail on them
+func (cfg *Config) validate() error {
+
+	if cfg.Monitor.Interval <= 0 {
+		return fmt.Errorf("NewConfig: monitor.interval must be greater than 0: %s", time.Duration(cfg.Monitor.Interval))
+	}
+	if cfg.Monitor.MinInterval <= 0 {
+		return fmt.Errorf("NewConfig: monitor.min-interval must be greater than 0: %s", time.Duration(cfg.Monitor.MinInterval))
+	}
+	if cfg.Monitor.MaxInterval < 0 {
+		return fmt.Errorf("NewConfig: monitor.max-interval must not be negative: %s", time.Duration(cfg.Monitor.MaxInterval))
+	}
+	if cfg.Monitor.Predictive.Window <= 0 {
+		return fmt.Errorf("NewConfig: monitor.predictive.window must be greater than 0: %d", cfg.Monitor.Predictive.Window)
+	}
+	if cfg.Monitor.Predictive.ProvisioningLatency < 0 {
+		return fmt.Errorf("NewConfig: monitor.predictive.provisioning-latency must not be negative: %d", cfg.Monitor.Predictive.ProvisioningLatency)
+	}
+	if cfg.Monitor.Predictive.SafetyMargin < 0 {
+		return fmt.Errorf("NewConfig: monitor.predictive.safety-margin must not be negative: %d", cfg.Monitor.Predictive.SafetyMargin)
+	}
+	if cfg.Monitor.Predictive.Horizon <= 0 {
+		return fmt.Errorf("NewConfig: monitor.predictive.horizon must be greater than 0: %d", cfg.Monitor.Predictive.Horizon)
+	}
+	if cfg.Monitor.Cooldown < 0 {
+		return fmt.Errorf("NewConfig: monitor.cooldown must not be negative: %d", cfg.Monitor.Cooldown)
+	}
+	if cfg.Monitor.MaxBackoff <= 0 {
+		return fmt.Errorf("NewConfig: monitor.max-backoff must be greater than 0: %d", cfg.Monitor.MaxBackoff)
+	}
+	if cfg.Monitor.BreakerFailures < 0 {
+		return fmt.Errorf("NewConfig: monitor.breaker-failures must not be negative: %d", cfg.Monitor.BreakerFailures)
+	}
+	if cfg.Monitor.BreakerReset <= 0 {
+		return fmt.Errorf("NewConfig: monitor.breaker-reset must be greater than 0: %d", cfg.Monitor.BreakerReset)
+	}
+	if err := cfg.Gc.Validate(); err != nil {
+		return fmt.Errorf("NewConfig: %w", err)
+	}
+	return nil
+}
+
 func readFile(cfg *Config, path string) error {
 	f, err := os.Open(path)
 	if err != nil {
@@ -0,0 +1,118 @@
+package ebs_autoscale
+
+import (
+	"gotest.tools/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type TestNewConfigInputs struct {
+	Name                    string
+	Monitor                 string
+	ExpectedCooldown        int32
+	ExpectedMaxBackoff      int32
+	ExpectedBreakerFailures int32
+	ExpectedBreakerReset    int32
+	ExpectedSafetyMargin    int32
+	Error                   bool
+}
+
+func TestNewConfig(t *testing.T) {
+
+	tests := []TestNewConfigInputs{
+		{
+			Name:                    "Defaults",
+			Monitor:                 "{}",
+			ExpectedCooldown:        defaultGrowCooldownSec,
+			ExpectedMaxBackoff:      defaultMaxBackoffSec,
+			ExpectedBreakerFailures: defaultBreakerFailures,
+			ExpectedBreakerReset:    defaultBreakerResetSec,
+			ExpectedSafetyMargin:    defaultSafetyMarginSec,
+		},
+		{
+			Name:                    "Explicit zero turns the feature off",
+			Monitor:                 "{cooldown: 0, breaker-failures: 0, predictive: {safety-margin: 0}}",
+			ExpectedCooldown:        0,
+			ExpectedMaxBackoff:      defaultMaxBackoffSec,
+			ExpectedBreakerFailures: 0,
+			ExpectedBreakerReset:    defaultBreakerResetSec,
+			ExpectedSafetyMargin:    0,
+		},
+		{
+			Name:                    "Configured",
+			Monitor:                 "{cooldown: 300, max-backoff: 60, breaker-failures: 2, breaker-reset: 600}",
+			ExpectedCooldown:        300,
+			ExpectedMaxBackoff:      60,
+			ExpectedBreakerFailures: 2,
+			ExpectedBreakerReset:    600,
+			ExpectedSafetyMargin:    defaultSafetyMarginSec,
+		},
+		{
+			Name:    "Zero max backoff",
+			Monitor: "{max-backoff: 0}",
+			Error:   true,
+		},
+		{
+			Name:    "Zero breaker reset",
+			Monitor: "{breaker-reset: 0}",
+			Error:   true,
+		},
+		{
+			Name:    "Zero interval",
+			Monitor: "{interval: 0}",
+			Error:   true,
+		},
+		{
+			Name:    "Zero min interval",
+			Monitor: "{min-interval: 0}",
+			Error:   true,
+		},
+		{
+			Name:    "Negative max interval",
+			Monitor: "{max-interval: -1}",
+			Error:   true,
+		},
+		{
+			Name:    "Zero predictive window",
+			Monitor: "{predictive: {window: 0}}",
+			Error:   true,
+		},
+		{
+			Name:    "Zero predictive horizon",
+			Monitor: "{predictive: {horizon: 0}}",
+			Error:   true,
+		},
+		{
+			Name:    "Negative cooldown",
+			Monitor: "{cooldown: -1}",
+			Error:   true,
+		},
+		{
+			Name:    "Negative breaker failures",
+			Monitor: "{breaker-failures: -1}",
+			Error:   true,
+		},
+	}
+
+	for _, i := range tests {
+
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(path, []byte("monitor: "+i.Monitor+"\n"), 0600); err != nil {
+			t.Fatalf("WriteFile(%s) Returned an unexpected error: %s", i.Name, err)
+		}
+
+		cfg, err := NewConfig(path)
+		if (err == nil) == i.Error {
+			t.Errorf("NewConfig(%s) Returned an unexpected error: %s", i.Name, err)
+		}
+		if err != nil {
+			continue
+		}
+		assert.Equal(t, cfg.Monitor.Cooldown, i.ExpectedCooldown, i.Name)
+		assert.Equal(t, cfg.Monitor.MaxBackoff, i.ExpectedMaxBackoff, i.Name)
+		assert.Equal(t, cfg.Monitor.BreakerFailures, i.ExpectedBreakerFailures, i.Name)
+		assert.Equal(t, cfg.Monitor.BreakerReset, i.ExpectedBreakerReset, i.Name)
+		assert.Equal(t, cfg.Monitor.Predictive.SafetyMargin, i.ExpectedSafetyMargin, i.Name)
+	}
+}
@@ -0,0 +1,101 @@
+package ebs_autoscale
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// DestroyVolumes returns the volumes Destroy removes: those tagged for the filesystem that are attached to the host,
+// or that are detached and were created by it or are persistent. Volumes attached to other hosts, which share the
+// autoscale id when they use the same mount point, are left alone.
+func (v *Volume) DestroyVolumes(ctx context.Context) ([]ManagedVolume, error) {
+
+	taggedVolumes, err := v.Provider.ListVolumes(ctx, map[string]string{autoscaleIdTag: v.Id})
+	if err != nil {
+		return nil, err
+	}
+
+	vols := make([]ManagedVolume, 0, len(taggedVolumes))
+	for _, mv := range taggedVolumes {
+		source, _ := mv.Tag(sourceInstanceTag)
+		if mv.AttachedTo == v.Host.InstanceId || (mv.AttachedTo == "" && (source == v.Host.InstanceId || v.Persistent)) {
+			vols = append(vols, mv)
+		}
+	}
+	return vols, nil
+}
+
+// Destroy tears down the filesystem created by CreateVolume. The filesystem is unmounted and removed from fstab, a busy
+// mount is refused unless forced. Each of the DestroyVolumes is then detached, and deleted unless keepVolumes is set.
+// Every volume is attempted, the errors of those that failed are returned together.
+func (v *Volume) Destroy(ctx context.Context, force bool, keepVolumes bool) error {
+
+	vols, err := v.DestroyVolumes(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = v.Fs.DestroyFileSystem(force)
+	if err != nil {
+		return err
+	}
+
+	var errList []error
+	for _, mv := range vols {
+		if mv.AttachedTo != "" {
+			slog.Info(fmt.Sprintf("Destroy: detaching volume: %s", mv.VolumeId))
+			if err := v.Provider.DetachVolume(ctx, mv.VolumeId); err != nil {
+				errList = append(errList, fmt.Errorf("Destroy: %s: %w", mv.VolumeId, err))
+				continue
+			}
+		}
+		v.ManagedVolumes = removeVolumeId(v.ManagedVolumes, mv.VolumeId)
+		if keepVolumes {
+			continue
+		}
+		slog.Info(fmt.Sprintf("Destroy: deleting volume: %s", mv.VolumeId))
+		err := v.Provider.DeleteVolume(ctx, mv.VolumeId)
+		if err == nil {
+			err = v.waitVolumeDeleted(ctx, mv.VolumeId)
+		}
+		if err != nil {
+			errList = append(errList, fmt.Errorf("Destroy: %s: %w", mv.VolumeId, err))
+		}
+	}
+	if len(errList) > 0 {
+		return errors.Join(errList...)
+	}
+
+	// nothing is left in flight once the volumes are gone
+	v.Journal.Complete()
+	return nil
+}
+
+// waitVolumeDeleted waits until the provider no longer lists the volume or volumeDeletedTimeout expires
+func (v *Volume) waitVolumeDeleted(ctx context.Context, volumeId string) error {
+
+	ctxTimeout, timeoutCancel := context.WithTimeout(ctx, volumeDeletedTimeout)
+	ticker := time.NewTicker(volumeDeletedPollInterval)
+	defer func() {
+		ticker.Stop()
+		timeoutCancel()
+	}()
+
+	for {
+		taggedVolumes, err := v.Provider.ListVolumes(ctxTimeout, map[string]string{autoscaleIdTag: v.Id})
+		if err != nil {
+			return err
+		}
+		if !containsVolumeId(taggedVolumes, volumeId) {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctxTimeout.Done():
+			return fmt.Errorf("waitVolumeDeleted: waiting for volume: %s to be deleted appears to have %w", volumeId, errTimedOut)
+		}
+	}
+}
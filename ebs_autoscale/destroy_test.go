@@ -0,0 +1,117 @@
+package ebs_autoscale
+
+import (
+	"context"
+	"fmt"
+	"github.com/BobTheTerrible/ebs-autoscale/ebs_autoscale/awsfake"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"gotest.tools/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+type TestDestroyInputs struct {
+	Name        string
+	Force       bool
+	KeepVolumes bool
+	// Setup prepares the fake and filesystem before the call
+	Setup           func(fake *awsfake.Ec2, volume *Volume, fs *recordingFS)
+	ExpectedFsCalls []string
+	// ExpectedVolumes the ids and states of the volumes remaining in ec2
+	ExpectedVolumes map[string]types.VolumeState
+	Error           bool
+}
+
+func TestDestroy(t *testing.T) {
+
+	volumeAvailableTimeout = time.Second
+	volumeDeletedTimeout = time.Second
+
+	// seed adds two volumes attached to the host, a detached one created by it and one attached elsewhere
+	seed := func(fake *awsfake.Ec2, volume *Volume, fs *recordingFS) {
+		for n, id := range []string{"vol-1", "vol-2"} {
+			device := volume.devicePrefix + string(rune('a'+n))
+			putManagedVolume(fake, id, volume.Id, volume.Host.InstanceId, device)
+			_ = os.WriteFile(device, []byte{}, 0600)
+		}
+		putTaggedVolume(fake, "vol-3", volume.Id, volume.Host.InstanceId, time.Now(), types.VolumeStateAvailable)
+		putManagedVolume(fake, "vol-other", volume.Id, "i-other", "/dev/xvdba")
+	}
+
+	tests := []TestDestroyInputs{
+		{
+			Name:            "Unmounts and deletes the volumes",
+			Setup:           seed,
+			ExpectedFsCalls: []string{"DestroyFileSystem:false"},
+			ExpectedVolumes: map[string]types.VolumeState{"vol-other": types.VolumeStateInUse},
+			Error:           false,
+		},
+		{
+			Name:            "Keeps the volumes detached",
+			KeepVolumes:     true,
+			Setup:           seed,
+			ExpectedFsCalls: []string{"DestroyFileSystem:false"},
+			ExpectedVolumes: map[string]types.VolumeState{
+				"vol-1":     types.VolumeStateAvailable,
+				"vol-2":     types.VolumeStateAvailable,
+				"vol-3":     types.VolumeStateAvailable,
+				"vol-other": types.VolumeStateInUse,
+			},
+			Error: false,
+		},
+		{
+			Name:  "Busy mount leaves the volumes",
+			Force: true,
+			Setup: func(fake *awsfake.Ec2, volume *Volume, fs *recordingFS) {
+				seed(fake, volume, fs)
+				fs.Err = fmt.Errorf("busy")
+			},
+			ExpectedFsCalls: []string{"DestroyFileSystem:true"},
+			ExpectedVolumes: map[string]types.VolumeState{
+				"vol-1":     types.VolumeStateInUse,
+				"vol-2":     types.VolumeStateInUse,
+				"vol-3":     types.VolumeStateAvailable,
+				"vol-other": types.VolumeStateInUse,
+			},
+			Error: true,
+		},
+		{
+			Name: "Failed detach carries on with the other volumes",
+			Setup: func(fake *awsfake.Ec2, volume *Volume, fs *recordingFS) {
+				seed(fake, volume, fs)
+				fake.FailNext("DetachVolume", fmt.Errorf("mock error"))
+			},
+			ExpectedFsCalls: []string{"DestroyFileSystem:false"},
+			ExpectedVolumes: map[string]types.VolumeState{
+				"vol-1":     types.VolumeStateInUse,
+				"vol-other": types.VolumeStateInUse,
+			},
+			Error: true,
+		},
+	}
+
+	for _, i := range tests {
+
+		fake := awsfake.NewEc2()
+		volume := newFakeVolume(t, fake)
+		var calls []string
+		fs := recordingFS{mockFS: mockFS{MountPoint: aws.String("/mnt/mock")}, calls: &calls}
+		i.Setup(fake, &volume, &fs)
+		volume.Fs = fs
+
+		err := volume.Destroy(context.Background(), i.Force, i.KeepVolumes)
+
+		if (err == nil) == i.Error {
+			t.Errorf("Destroy(%s) Returned an unexpected error: %s", i.Name, err)
+		}
+		assert.DeepEqual(t, calls, i.ExpectedFsCalls, cmpopts.EquateEmpty())
+		remaining := map[string]types.VolumeState{}
+		for _, v := range fake.Volumes() {
+			remaining[*v.VolumeId] = v.State
+		}
+		assert.DeepEqual(t, remaining, i.ExpectedVolumes)
+	}
+}
@@ -0,0 +1,44 @@
+package ebs_autoscale
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// Ec2Api is the subset of the EC2 API used to manage the volumes of a filesystem. It is satisfied by *ec2.Client and
+// by the in-memory fake in the awsfake package.
+type Ec2Api interface {
+	// DescribeVolumes is also used to drive the ec2.VolumeAvailableWaiter
+	ec2.DescribeVolumesAPIClient
+	CreateVolume(ctx context.Context, params *ec2.CreateVolumeInput, optFns ...func(*ec2.Options)) (*ec2.CreateVolumeOutput, error)
+	AttachVolume(ctx context.Context, params *ec2.AttachVolumeInput, optFns ...func(*ec2.Options)) (*ec2.AttachVolumeOutput, error)
+	DetachVolume(ctx context.Context, params *ec2.DetachVolumeInput, optFns ...func(*ec2.Options)) (*ec2.DetachVolumeOutput, error)
+	DeleteVolume(ctx context.Context, params *ec2.DeleteVolumeInput, optFns ...func(*ec2.Options)) (*ec2.DeleteVolumeOutput, error)
+	ModifyInstanceAttribute(ctx context.Context, params *ec2.ModifyInstanceAttributeInput, optFns ...func(*ec2.Options)) (*ec2.ModifyInstanceAttributeOutput, error)
+	DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error)
+	ModifyVolume(ctx context.Context, params *ec2.ModifyVolumeInput, optFns ...func(*ec2.Options)) (*ec2.ModifyVolumeOutput, error)
+	DescribeVolumesModifications(ctx context.Context, params *ec2.DescribeVolumesModificationsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeVolumesModificationsOutput, error)
+	CreateTags(ctx context.Context, params *ec2.CreateTagsInput, optFns ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error)
+}
+
+var _ Ec2Api = (*ec2.Client)(nil)
+
+// ec2Endpoint returns an ec2 client option applying the configured endpoint override, if any
+func ec2Endpoint(cfg AwsCfg) func(*ec2.Options) {
+	return func(o *ec2.Options) {
+		if cfg.EndpointUrl != "" {
+			o.BaseEndpoint = aws.String(cfg.EndpointUrl)
+		}
+	}
+}
+
+// stsEndpoint returns a sts client option applying the configured endpoint override, if any
+func stsEndpoint(cfg AwsCfg) func(*sts.Options) {
+	return func(o *sts.Options) {
+		if cfg.EndpointUrl != "" {
+			o.BaseEndpoint = aws.String(cfg.EndpointUrl)
+		}
+	}
+}
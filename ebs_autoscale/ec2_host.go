@@ -10,6 +10,12 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"io"
+	"os"
+)
+
+const (
+	defaultLocalAvailabilityZone = "local-1a"
+	defaultLocalAccountId        = "000000000000"
 )
 
 type Ec2Host struct {
@@ -17,27 +23,23 @@ type Ec2Host struct {
 	InstanceArn      string
 	AvailabilityZone string
 	Region           string
-	Tags             []types.Tag
+	Tags             []Tag
 }
 
-func NewEc2Host(ctx context.Context) (*Ec2Host, error) {
+// NewEc2Host describes the instance this process is running on. The instance identity is read from the metadata source
+// selected by hostCfg, the ec2 and sts endpoints can be overridden by awsCfg.
+func NewEc2Host(ctx context.Context, hostCfg HostCfg, awsCfg AwsCfg) (*Ec2Host, error) {
 
-	// We do not need to know the Region for imds calls
-	imdsCfg, err := config.LoadDefaultConfig(ctx)
-	if err != nil {
-		return nil, err
-	}
-	imdsClient := imds.NewFromConfig(imdsCfg)
-	_, err = GetAWSEc2Metadata(ctx, "instance-id", *imdsClient)
+	metadata, err := NewMetadataSource(ctx, hostCfg)
 	if err != nil {
 		return nil, err
 	}
 
-	instanceId, err := GetAWSEc2Metadata(ctx, "instance-id", *imdsClient)
+	instanceId, err := metadata.InstanceId(ctx)
 	if err != nil {
 		return nil, err
 	}
-	availabilityZone, err := GetAWSEc2Metadata(ctx, "placement/availability-zone", *imdsClient)
+	availabilityZone, err := metadata.AvailabilityZone(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -50,19 +52,23 @@ func NewEc2Host(ctx context.Context) (*Ec2Host, error) {
 		return nil, err
 	}
 
-	ec2Client := ec2.NewFromConfig(awsConfig)
-	stsClient := sts.NewFromConfig(awsConfig)
+	ec2Client := ec2.NewFromConfig(awsConfig, ec2Endpoint(awsCfg))
+	stsClient := sts.NewFromConfig(awsConfig, stsEndpoint(awsCfg))
 
 	// This is a bit of a hack because there is no way to fetch the actual arn
 	// We will use this arn externally to limit the attach/detach actions we can perform on a volume
 	// see https://github.com/awslabs/amazon-ebs-autoscale/issues/28
 	//arn:aws:ec2:<Region>:<account-number>:instance/<instance-id>
-	callerID, err := stsClient.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
-	if err != nil {
-		return nil, err
+	account := hostCfg.AccountId
+	if account == "" {
+		callerID, err := stsClient.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+		if err != nil {
+			return nil, err
+		}
+		account = *callerID.Account
 	}
 
-	instanceArn := fmt.Sprintf("arn:aws:ec2:%s:%s:instance/%s", region, *callerID.Account, instanceId)
+	instanceArn := fmt.Sprintf("arn:aws:ec2:%s:%s:instance/%s", region, account, instanceId)
 	tagsOutput, err := ec2Client.DescribeTags(ctx, &ec2.DescribeTagsInput{
 		Filters: []types.Filter{
 			{
@@ -82,13 +88,13 @@ func NewEc2Host(ctx context.Context) (*Ec2Host, error) {
 		InstanceArn:      instanceArn,
 		AvailabilityZone: availabilityZone,
 		Region:           region,
-		Tags: func(tags []types.TagDescription) []types.Tag {
-			volumeTags := make([]types.Tag, 0)
+		Tags: func(tags []types.TagDescription) []Tag {
+			volumeTags := make([]Tag, 0)
 			for _, t := range tags {
 				volumeTags = append(volumeTags,
-					types.Tag{
-						Key:   t.Key,
-						Value: t.Value,
+					Tag{
+						Key:   aws.ToString(t.Key),
+						Value: aws.ToString(t.Value),
 					},
 				)
 			}
@@ -99,6 +105,37 @@ func NewEc2Host(ctx context.Context) (*Ec2Host, error) {
 	return &e, nil
 }
 
+// NewLocalHost describes the local machine without calling AWS, for use with the loop provider. The static host
+// config is used where given, otherwise the hostname stands in for the instance id.
+func NewLocalHost(hostCfg HostCfg) (*Ec2Host, error) {
+
+	instanceId := hostCfg.InstanceId
+	if instanceId == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		instanceId = hostname
+	}
+	availabilityZone := hostCfg.AvailabilityZone
+	if availabilityZone == "" {
+		availabilityZone = defaultLocalAvailabilityZone
+	}
+	account := hostCfg.AccountId
+	if account == "" {
+		account = defaultLocalAccountId
+	}
+	region := availabilityZone[:len(availabilityZone)-1]
+
+	return &Ec2Host{
+		InstanceId:       instanceId,
+		InstanceArn:      fmt.Sprintf("arn:aws:ec2:%s:%s:instance/%s", region, account, instanceId),
+		AvailabilityZone: availabilityZone,
+		Region:           region,
+		Tags:             []Tag{},
+	}, nil
+}
+
 // GetAWSEc2Metadata get EC2 instance metadata using
 // https://pkg.go.dev/github.com/aws/aws-sdk-go-v2/feature/ec2/imds#Client.GetMetadata
 func GetAWSEc2Metadata(ctx context.Context, path string, client imds.Client) (value string, err error) {
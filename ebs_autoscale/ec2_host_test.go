@@ -0,0 +1,130 @@
+package ebs_autoscale
+
+import (
+	"context"
+	"github.com/BobTheTerrible/ebs-autoscale/ebs_autoscale/awsfake"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"gotest.tools/assert"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type TestNewEc2HostInputs struct {
+	Name string
+	// HostCfg builds the host config from the imds stand-in url
+	HostCfg func(imdsUrl string) HostCfg
+	// ImdsFailures the number of requests the metadata service rejects
+	ImdsFailures int
+	Expected     Ec2Host
+	Error        bool
+}
+
+func TestNewEc2Host(t *testing.T) {
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "standin")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "standin")
+	imdsRetryDelay = 10 * time.Millisecond
+
+	instanceTags := []Tag{
+		{Key: "Name", Value: "worker"},
+	}
+
+	tests := []TestNewEc2HostInputs{
+		{
+			Name: "Instance metadata service",
+			HostCfg: func(imdsUrl string) HostCfg {
+				return HostCfg{Imds: ImdsCfg{Endpoint: imdsUrl}}
+			},
+			Expected: Ec2Host{
+				InstanceId:       "i-imds",
+				InstanceArn:      "arn:aws:ec2:ap-southeast-2:123456789012:instance/i-imds",
+				AvailabilityZone: "ap-southeast-2b",
+				Region:           "ap-southeast-2",
+				Tags:             instanceTags,
+			},
+			Error: false,
+		},
+		{
+			Name: "Instance metadata service recovers",
+			HostCfg: func(imdsUrl string) HostCfg {
+				return HostCfg{Imds: ImdsCfg{Endpoint: imdsUrl, MaxAttempts: 5}}
+			},
+			ImdsFailures: 6,
+			Expected: Ec2Host{
+				InstanceId:       "i-imds",
+				InstanceArn:      "arn:aws:ec2:ap-southeast-2:123456789012:instance/i-imds",
+				AvailabilityZone: "ap-southeast-2b",
+				Region:           "ap-southeast-2",
+				Tags:             instanceTags,
+			},
+			Error: false,
+		},
+		{
+			Name: "Instance metadata service never available",
+			HostCfg: func(imdsUrl string) HostCfg {
+				return HostCfg{Imds: ImdsCfg{Endpoint: imdsUrl, MaxAttempts: 2}}
+			},
+			ImdsFailures: 100,
+			Error:        true,
+		},
+		{
+			Name: "Static host",
+			HostCfg: func(imdsUrl string) HostCfg {
+				return HostCfg{InstanceId: "i-static", AvailabilityZone: "us-east-1a", AccountId: "210987654321"}
+			},
+			Expected: Ec2Host{
+				InstanceId:       "i-static",
+				InstanceArn:      "arn:aws:ec2:us-east-1:210987654321:instance/i-static",
+				AvailabilityZone: "us-east-1a",
+				Region:           "us-east-1",
+				Tags:             []Tag{},
+			},
+			Error: false,
+		},
+		{
+			Name: "Static host without account",
+			HostCfg: func(imdsUrl string) HostCfg {
+				return HostCfg{InstanceId: "i-static", AvailabilityZone: "us-east-1a"}
+			},
+			Expected: Ec2Host{
+				InstanceId:       "i-static",
+				InstanceArn:      "arn:aws:ec2:us-east-1:123456789012:instance/i-static",
+				AvailabilityZone: "us-east-1a",
+				Region:           "us-east-1",
+				Tags:             []Tag{},
+			},
+			Error: false,
+		},
+		{
+			Name: "Incomplete static host",
+			HostCfg: func(imdsUrl string) HostCfg {
+				return HostCfg{InstanceId: "i-static"}
+			},
+			Error: true,
+		},
+	}
+
+	for _, i := range tests {
+
+		fake := awsfake.NewEc2()
+		fake.PutInstance("i-imds", []types.Tag{{Key: aws.String("Name"), Value: aws.String("worker")}})
+		awsSrv := httptest.NewServer(awsfake.NewServer(fake, "123456789012"))
+		imdsStandin := awsfake.NewImds("i-imds", "ap-southeast-2b")
+		imdsStandin.FailNext(i.ImdsFailures)
+		imdsSrv := httptest.NewServer(imdsStandin)
+
+		got, err := NewEc2Host(context.Background(), i.HostCfg(imdsSrv.URL), AwsCfg{EndpointUrl: awsSrv.URL})
+
+		awsSrv.Close()
+		imdsSrv.Close()
+
+		if (err == nil) == i.Error {
+			t.Errorf("NewEc2Host(%s) Returned an unexpected error: %s", i.Name, err)
+		}
+		if err == nil {
+			assert.DeepEqual(t, *got, i.Expected)
+		}
+	}
+}
@@ -1,12 +1,10 @@
 package filesystem
 
 import (
-	"bytes"
 	"fmt"
-	"golang.org/x/sys/unix"
 	"log/slog"
-	"os"
-	"os/exec"
+	"strconv"
+	"strings"
 )
 
 func init() {
@@ -30,70 +28,239 @@ func (fs BtrfsFileSystem) GetMountPoint() string {
 // CreateFileSystem creates a btrfs file system on the given device
 func (fs BtrfsFileSystem) CreateFileSystem(device string) error {
 
-	if err := runCommand("mkfs.btrfs", "-f", "-d", "single", device); err != nil {
+	if err := RunCommand("mkfs.btrfs", "-f", "-d", "single", device); err != nil {
 		return err
 	}
 
-	if err := runCommand("mount", device, fs.MountPoint); err != nil {
+	if err := RunCommand("mount", device, fs.MountPoint); err != nil {
 		return err
 	}
 
 	slog.Info("CreateFileSystem: writing to fstab")
-	f, err := os.OpenFile("/etc/fstab", os.O_APPEND|os.O_WRONLY, os.ModeAppend)
+	return ensureFstabEntry(fstabPath, device, fs.MountPoint, "btrfs", defaultMountOptions)
+}
+
+// HasFileSystem reports whether blkid finds a btrfs signature on the device
+func (fs BtrfsFileSystem) HasFileSystem(device string) (bool, error) {
+
+	signature, err := deviceSignature(device)
 	if err != nil {
-		return err
+		return false, err
 	}
-	defer f.Close() //nolint:errcheck
+	return signature == "btrfs", nil
+}
 
-	fsTabLine := fmt.Sprintf("%s\t%s\tbtrfs\tdefaults\t0\t0\n", device, fs.MountPoint)
+// MountFileSystem mounts the existing btrfs file system of the device, scanning for its other devices first, unless
+// the mount point is already mounted. The fstab line is written if missing.
+func (fs BtrfsFileSystem) MountFileSystem(device string) error {
 
-	if _, err = f.WriteString(fsTabLine); err != nil {
+	mounted, err := hasMountEntry(mountsPath, fs.MountPoint)
+	if err != nil {
 		return err
 	}
+	if !mounted {
+		if err := RunCommand("btrfs", "device", "scan"); err != nil {
+			return err
+		}
+		if err := RunCommand("mount", device, fs.MountPoint); err != nil {
+			return err
+		}
+	}
 
-	return nil
+	return ensureFstabEntry(fstabPath, device, fs.MountPoint, "btrfs", defaultMountOptions)
 }
 
-// runCommand is a convenience method that wraps a system call
-func runCommand(prog string, arg ...string) error {
-
-	cmd := exec.Command(prog, arg...)
-
-	slog.Debug(fmt.Sprintf("runCommand:  %s", cmd.String()))
+// DestroyFileSystem unmounts the btrfs file system and removes the fstab line written by CreateFileSystem. A file
+// system that is not mounted is only removed from fstab. When forced, a busy mount is lazily detached.
+func (fs BtrfsFileSystem) DestroyFileSystem(force bool) error {
 
-	var outb, errb bytes.Buffer
-	cmd.Stdout = &outb
-	cmd.Stderr = &errb
-
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("runCommand: %s: %w: %s: %s", cmd.String(), err, outb.String(), errb.String())
+	if err := unmountFileSystem(fs.MountPoint, force); err != nil {
+		return err
 	}
-	return nil
+
+	slog.Info("DestroyFileSystem: removing from fstab")
+	return removeFstabEntry(fstabPath, fs.MountPoint, "btrfs")
 }
 
 // GrowFileSystem adds a device to the existing btrfs file system and grows the underlying partition
 func (fs BtrfsFileSystem) GrowFileSystem(device string) error {
 
-	if err := runCommand("btrfs", "device", "add", device, fs.MountPoint); err != nil {
+	if err := RunCommand("btrfs", "device", "add", device, fs.MountPoint); err != nil {
 		return err
 	}
 
-	if err := runCommand("btrfs", "balance", "start", "-m", fs.MountPoint); err != nil {
+	if err := RunCommand("btrfs", "balance", "start", "-m", fs.MountPoint); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// ResizeDevice resizes the btrfs device matching the given device to its maximum size
+func (fs BtrfsFileSystem) ResizeDevice(device string) error {
+
+	out, err := RunCommandOutput("btrfs", "filesystem", "show", "--raw", fs.MountPoint)
+	if err != nil {
+		return err
+	}
+	devid, ok := parseBtrfsShowDevid(out, resolvePath(device))
+	if !ok {
+		return fmt.Errorf("ResizeDevice: %s is not a device of %s", device, fs.MountPoint)
+	}
+
+	return RunCommand("btrfs", "filesystem", "resize", devid+":max", fs.MountPoint)
+}
+
 // Stat stats the underlying file system. Returns total_space, used_space, free_space in bytes
 func (fs BtrfsFileSystem) Stat() (uint64, uint64, uint64, error) {
-	var stat unix.Statfs_t
-	err := unix.Statfs(fs.GetMountPoint(), &stat)
+	return statFileSystem(fs.GetMountPoint())
+}
+
+// Usage reports the block group allocation of the btrfs file system, as reported by btrfs filesystem usage. statfs
+// does not account for the unallocated space, nor for the metadata running out while the data still has room.
+func (fs BtrfsFileSystem) Usage() (*Usage, error) {
+
+	out, err := RunCommandOutput("btrfs", "filesystem", "usage", "-b", fs.MountPoint)
 	if err != nil {
-		return 0, 0, 0, err
+		return nil, err
+	}
+	usage, err := parseBtrfsUsage(out)
+	if err != nil {
+		return nil, err
+	}
+
+	// btrfs allocates inodes on demand, statfs reports none
+	usage.Inodes, usage.InodesFree, err = statInodes(fs.MountPoint)
+	if err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// parseBtrfsUsage parses the output of btrfs filesystem usage -b. The block groups of a type with more than one
+// profile, i.e. part way through a balance, are summed.
+func parseBtrfsUsage(out string) (*Usage, error) {
+
+	usage := &Usage{Unallocated: map[string]uint64{}}
+	var err error
+	section := ""
+	for _, line := range strings.Split(out, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(trimmed, ":")
+
+		// a section header such as "Data,single: Size:8594128896, Used:4516696064 (52.56%)" or "Unallocated:"
+		if line[0] != ' ' && line[0] != '\t' {
+			section, _, _ = strings.Cut(key, ",")
+			if section == "Overall" || section == "Unallocated" {
+				continue
+			}
+			var space *SpaceUsage
+			switch section {
+			case "Data":
+				space = &usage.Data
+			case "Metadata":
+				space = &usage.Metadata
+			case "System":
+				space = &usage.System
+			default:
+				continue
+			}
+			var allocated, used uint64
+			if _, err := fmt.Sscanf(strings.TrimSpace(value), "Size:%d, Used:%d", &allocated, &used); err != nil {
+				return nil, fmt.Errorf("parseBtrfsUsage: unexpected block group line: %s: %w", trimmed, err)
+			}
+			space.Allocated += allocated
+			space.Used += used
+			continue
+		}
+
+		switch section {
+		case "Overall":
+			fields := strings.Fields(value)
+			if len(fields) == 0 {
+				continue
+			}
+			switch key {
+			case "Device size":
+				usage.Size, err = strconv.ParseUint(fields[0], 10, 64)
+			case "Data ratio":
+				usage.DataRatio, err = strconv.ParseFloat(fields[0], 64)
+			case "Metadata ratio":
+				usage.MetadataRatio, err = strconv.ParseFloat(fields[0], 64)
+			case "Global reserve":
+				// "Global reserve:     5685248      (used: 0)"
+				usage.GlobalReserve.Allocated, err = strconv.ParseUint(fields[0], 10, 64)
+				if err == nil && len(fields) == 3 {
+					usage.GlobalReserve.Used, err = strconv.ParseUint(strings.TrimSuffix(fields[2], ")"), 10, 64)
+				}
+			}
+		case "Unallocated":
+			// "   /dev/nvme1n1    96619986944"
+			fields := strings.Fields(trimmed)
+			if len(fields) == 2 {
+				usage.Unallocated[fields[0]], err = strconv.ParseUint(fields[1], 10, 64)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parseBtrfsUsage: unexpected line: %s: %w", trimmed, err)
+		}
+	}
+
+	if usage.Size == 0 {
+		return nil, fmt.Errorf("parseBtrfsUsage: no device size found in: %s", out)
+	}
+	return usage, nil
+}
+
+// Devices lists the devices of the mounted btrfs file system, as reported by btrfs filesystem show
+func (fs BtrfsFileSystem) Devices() ([]string, error) {
+
+	out, err := RunCommandOutput("btrfs", "filesystem", "show", "--raw", fs.MountPoint)
+	if err != nil {
+		return nil, err
+	}
+	return parseBtrfsShowDevices(out), nil
+}
+
+// parseBtrfsShowDevices extracts the device paths from btrfs filesystem show output. Missing devices are skipped.
+func parseBtrfsShowDevices(out string) []string {
+
+	devices := make([]string, 0)
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] != "devid" {
+			continue
+		}
+		for i := 0; i < len(fields)-1; i++ {
+			if fields[i] != "path" {
+				continue
+			}
+			if !strings.HasPrefix(fields[i+1], "<missing") && !strings.Contains(line, "MISSING") {
+				devices = append(devices, fields[i+1])
+			}
+			break
+		}
+	}
+	return devices
+}
+
+// parseBtrfsShowDevid returns the devid of the device from btrfs filesystem show output. Device paths in the output are
+// resolved before comparing, as ebs devices are links to the nvme device btrfs reports.
+func parseBtrfsShowDevid(out string, device string) (string, bool) {
+
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "devid" {
+			continue
+		}
+		for i := 0; i < len(fields)-1; i++ {
+			if fields[i] == "path" && resolvePath(fields[i+1]) == device {
+				return fields[1], true
+			}
+		}
 	}
-	freeSpace := stat.Bfree * uint64(stat.Bsize)
-	totalSpace := stat.Blocks * uint64(stat.Bsize)
-	usage := totalSpace - freeSpace
-	return totalSpace, usage, freeSpace, nil
+	return "", false
 }
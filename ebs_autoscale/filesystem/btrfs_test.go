@@ -0,0 +1,172 @@
+package filesystem
+
+import (
+	"gotest.tools/assert"
+	"testing"
+)
+
+type TestParseBtrfsShowDevicesInputs struct {
+	Name     string
+	Output   string
+	Expected []string
+}
+
+func TestParseBtrfsShowDevices(t *testing.T) {
+
+	tests := []TestParseBtrfsShowDevicesInputs{
+		{
+			Name: "Two devices",
+			Output: `Label: none  uuid: 4bd6e3f2-8a3e-4d4e-9a43-3a1a4c7e2f10
+	Total devices 2 FS bytes used 1048576
+	devid    1 size 53687091200 used 2155872256 path /dev/nvme1n1
+	devid    2 size 80530636800 used 0 path /dev/nvme2n1`,
+			Expected: []string{"/dev/nvme1n1", "/dev/nvme2n1"},
+		},
+		{
+			Name: "Missing device",
+			Output: `Label: none  uuid: 4bd6e3f2-8a3e-4d4e-9a43-3a1a4c7e2f10
+	Total devices 2 FS bytes used 1048576
+	devid    1 size 53687091200 used 2155872256 path /dev/nvme1n1
+	devid    2 size 0 used 0 path <missing disk> MISSING
+	*** Some devices missing`,
+			Expected: []string{"/dev/nvme1n1"},
+		},
+		{
+			Name:     "No output",
+			Output:   "",
+			Expected: []string{},
+		},
+	}
+
+	for _, i := range tests {
+		assert.DeepEqual(t, parseBtrfsShowDevices(i.Output), i.Expected)
+	}
+}
+
+func TestParseBtrfsShowDevid(t *testing.T) {
+
+	out := `Label: none  uuid: 4bd6e3f2-8a3e-4d4e-9a43-3a1a4c7e2f10
+	Total devices 2 FS bytes used 1048576
+	devid    1 size 53687091200 used 2155872256 path /dev/nvme1n1
+	devid    3 size 80530636800 used 0 path /dev/nvme2n1`
+
+	devid, ok := parseBtrfsShowDevid(out, "/dev/nvme2n1")
+	assert.Assert(t, ok)
+	assert.Equal(t, devid, "3")
+
+	_, ok = parseBtrfsShowDevid(out, "/dev/nvme3n1")
+	assert.Assert(t, !ok)
+}
+
+func TestParseBtrfsUsage(t *testing.T) {
+
+	out := `Overall:
+    Device size:                 107374182400
+    Device allocated:             10754195456
+    Device unallocated:           96619986944
+    Device missing:                         0
+    Device slack:                           0
+    Used:                          4562411520
+    Free (estimated):            100698611712      (min: 52388618240)
+    Free (statfs, df):           100697563136
+    Data ratio:                          1.00
+    Metadata ratio:                      2.00
+    Global reserve:                   5685248      (used: 16384)
+    Multiple profiles:                     no
+
+Data,single: Size:8594128896, Used:4516696064 (52.56%)
+   /dev/nvme1n1    8594128896
+
+Metadata,DUP: Size:1073741824, Used:22839296 (2.13%)
+   /dev/nvme1n1    2147483648
+
+System,DUP: Size:8388608, Used:16384 (0.20%)
+   /dev/nvme1n1      16777216
+
+Unallocated:
+   /dev/nvme1n1    53687091200
+   /dev/nvme2n1    42932895744`
+
+	usage, err := parseBtrfsUsage(out)
+	if err != nil {
+		t.Fatalf("parseBtrfsUsage Returned an unexpected error: %s", err)
+	}
+	assert.DeepEqual(t, *usage, Usage{
+		Size:          107374182400,
+		Data:          SpaceUsage{Allocated: 8594128896, Used: 4516696064},
+		Metadata:      SpaceUsage{Allocated: 1073741824, Used: 22839296},
+		System:        SpaceUsage{Allocated: 8388608, Used: 16384},
+		Unallocated:   map[string]uint64{"/dev/nvme1n1": 53687091200, "/dev/nvme2n1": 42932895744},
+		GlobalReserve: SpaceUsage{Allocated: 5685248, Used: 16384},
+		DataRatio:     1,
+		MetadataRatio: 2,
+	})
+
+	if _, err := parseBtrfsUsage("ERROR: not a btrfs filesystem"); err == nil {
+		t.Errorf("parseBtrfsUsage Expected an error for output without a device size")
+	}
+}
+
+type TestUsagePercentInputs struct {
+	Name             string
+	Usage            Usage
+	ExpectedData     float32
+	ExpectedMetadata float32
+	ExpectedInodes   float32
+}
+
+func TestUsagePercent(t *testing.T) {
+
+	gb := uint64(1 << 30)
+
+	tests := []TestUsagePercentInputs{
+		{
+			Name: "Unallocated space is room for both",
+			Usage: Usage{
+				Data:          SpaceUsage{Allocated: 10 * gb, Used: 5 * gb},
+				Metadata:      SpaceUsage{Allocated: 1 * gb, Used: gb / 2},
+				Unallocated:   map[string]uint64{"/dev/nvme1n1": 10 * gb},
+				DataRatio:     1,
+				MetadataRatio: 2,
+			},
+			ExpectedData:     25, // 5 of 10 + 10
+			ExpectedMetadata: 8.333333,
+		},
+		{
+			Name: "Metadata exhausted with the data half empty",
+			Usage: Usage{
+				Data:          SpaceUsage{Allocated: 10 * gb, Used: 5 * gb},
+				Metadata:      SpaceUsage{Allocated: 1 * gb, Used: gb - gb/64},
+				GlobalReserve: SpaceUsage{Allocated: gb / 64},
+				Unallocated:   map[string]uint64{"/dev/nvme1n1": 0},
+				DataRatio:     1,
+				MetadataRatio: 2,
+			},
+			ExpectedData:     50,
+			ExpectedMetadata: 100,
+		},
+		{
+			Name: "Inodes",
+			Usage: Usage{
+				Data:       SpaceUsage{Allocated: 10 * gb, Used: 5 * gb},
+				DataRatio:  1,
+				Inodes:     1000,
+				InodesFree: 250,
+			},
+			ExpectedData:   50,
+			ExpectedInodes: 75,
+		},
+		{
+			Name:             "Empty",
+			Usage:            Usage{},
+			ExpectedData:     0,
+			ExpectedMetadata: 0,
+		},
+	}
+
+	for _, i := range tests {
+		assert.Equal(t, i.Usage.DataPercent(), i.ExpectedData, i.Name)
+		assert.Equal(t, i.Usage.MetadataPercent(), i.ExpectedMetadata, i.Name)
+		assert.Equal(t, i.Usage.InodePercent(), i.ExpectedInodes, i.Name)
+	}
+}
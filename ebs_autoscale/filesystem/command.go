@@ -0,0 +1,67 @@
+package filesystem
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// RunCommand is a convenience method that wraps a system call
+func RunCommand(prog string, arg ...string) error {
+
+	cmd := exec.Command(prog, arg...)
+
+	slog.Debug(fmt.Sprintf("RunCommand:  %s", cmd.String()))
+
+	var outb, errb bytes.Buffer
+	cmd.Stdout = &outb
+	cmd.Stderr = &errb
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("RunCommand: %s: %w: %s: %s", cmd.String(), err, outb.String(), errb.String())
+	}
+	return nil
+}
+
+// RunCommandOutput runs the command as RunCommand does, returning its trimmed standard output
+func RunCommandOutput(prog string, arg ...string) (string, error) {
+
+	cmd := exec.Command(prog, arg...)
+
+	slog.Debug(fmt.Sprintf("RunCommandOutput:  %s", cmd.String()))
+
+	var outb, errb bytes.Buffer
+	cmd.Stdout = &outb
+	cmd.Stderr = &errb
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("RunCommandOutput: %s: %w: %s: %s", cmd.String(), err, outb.String(), errb.String())
+	}
+	return strings.TrimSpace(outb.String()), nil
+}
+
+// deviceSignature returns the type of the signature blkid finds on the device, i.e. btrfs or LVM2_member, empty when
+// the device carries none
+func deviceSignature(device string) (string, error) {
+
+	out, err := RunCommandOutput("blkid", "-o", "value", "-s", "TYPE", device)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 2 {
+		// blkid exits with 2 when the device carries no signature
+		return "", nil
+	}
+	return out, err
+}
+
+// resolvePath follows links in the path, returning it unchanged when it cannot be resolved
+func resolvePath(path string) string {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return path
+	}
+	return resolved
+}
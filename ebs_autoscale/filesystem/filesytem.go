@@ -1,21 +1,36 @@
 package filesystem
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 type FileSystem interface {
 	// CreateFileSystem physically creates the file system on the device
 	CreateFileSystem(device string) error
+	// HasFileSystem reports whether the device already carries a signature of this file system
+	HasFileSystem(device string) (bool, error)
+	// MountFileSystem mounts an existing file system from the device, unless already mounted, and ensures it is in
+	// fstab. It never formats the device.
+	MountFileSystem(device string) error
 	// GrowFileSystem grows the file system across an additional device
 	GrowFileSystem(device string) error
+	// ResizeDevice grows the file system to fill a device it already spans, once the device has been enlarged
+	ResizeDevice(device string) error
 	// GetMountPoint returns the file system mount point
 	GetMountPoint() string
 	// Stat stats the underlying file system. Returns total_size, used_space, free_space in bytes
 	Stat() (uint64, uint64, uint64, error)
+	// Usage reports the space of the file system in detail, i.e. the metadata that can run out while Stat shows room
+	Usage() (*Usage, error)
+	// Devices returns the devices the mounted file system currently spans
+	Devices() ([]string, error)
+	// DestroyFileSystem unmounts the file system and removes it from fstab. A busy mount is refused unless forced.
+	DestroyFileSystem(force bool) error
 }
 
 var backends = map[string]func(mountPoint string, options map[string]interface{}) (FileSystem, error){}
 
-
 // RegisterBackend allows adding a new filesystem type to the registry
 func RegisterBackend(name string, fsConstructor func(mountPoint string, options map[string]interface{}) (FileSystem, error)) {
 	backends[name] = fsConstructor
@@ -28,3 +43,75 @@ func GetFileSystem(fsType string, mountPoint string, options map[string]interfac
 	}
 	return nil, fmt.Errorf("unsupported filesystem type: %s", fsType)
 }
+
+// stringOption returns the string option, or the default when it is not set
+func stringOption(options map[string]interface{}, key string, defaultValue string) (string, error) {
+	value, ok := options[key]
+	if !ok {
+		return defaultValue, nil
+	}
+	str, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("option %s must be a string: %v", key, value)
+	}
+	return str, nil
+}
+
+// intOption returns the whole number option, which the yaml and json decoders produce as either int or float64, or
+// the default when it is not set
+func intOption(options map[string]interface{}, key string, defaultValue int) (int, error) {
+	value, ok := options[key]
+	if !ok {
+		return defaultValue, nil
+	}
+	switch n := value.(type) {
+	case int:
+		return n, nil
+	case int64:
+		return int(n), nil
+	case float64:
+		if n == float64(int(n)) {
+			return int(n), nil
+		}
+	}
+	return 0, fmt.Errorf("option %s must be a whole number: %v", key, value)
+}
+
+// floatOption returns the number option, or the default when it is not set
+func floatOption(options map[string]interface{}, key string, defaultValue float64) (float64, error) {
+	value, ok := options[key]
+	if !ok {
+		return defaultValue, nil
+	}
+	switch n := value.(type) {
+	case int:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case float64:
+		return n, nil
+	}
+	return 0, fmt.Errorf("option %s must be a number: %v", key, value)
+}
+
+// stringsOption returns the option given either as a list of strings or as a single string split on whitespace, i.e.
+// the arguments to pass a command
+func stringsOption(options map[string]interface{}, key string) ([]string, error) {
+	switch value := options[key].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return strings.Fields(value), nil
+	case []interface{}:
+		strs := make([]string, 0, len(value))
+		for _, v := range value {
+			str, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("option %s must be a list of strings: %v", key, v)
+			}
+			strs = append(strs, str)
+		}
+		return strs, nil
+	}
+	return nil, fmt.Errorf("option %s must be a string or a list of strings: %v", key, options[key])
+}
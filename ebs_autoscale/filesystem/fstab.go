@@ -0,0 +1,108 @@
+package filesystem
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+var (
+	// fstabPath the fstab file systems are recorded in
+	fstabPath = "/etc/fstab"
+	// mountsPath lists the mounted file systems
+	mountsPath = "/proc/self/mounts"
+)
+
+// defaultMountOptions the mount options of the fstab line when none are configured
+const defaultMountOptions = "defaults"
+
+// unmountFileSystem unmounts the file system at the mount point, a mount point that is not mounted is ignored. When
+// forced, a busy mount is lazily detached.
+func unmountFileSystem(mountPoint string, force bool) error {
+
+	err := unix.Unmount(mountPoint, 0)
+	if errors.Is(err, unix.EBUSY) {
+		if !force {
+			return fmt.Errorf("DestroyFileSystem: %s is busy, refusing to unmount", mountPoint)
+		}
+		slog.Warn(fmt.Sprintf("DestroyFileSystem: %s is busy, detaching lazily", mountPoint))
+		err = unix.Unmount(mountPoint, unix.MNT_DETACH)
+	}
+	// EINVAL is returned when the mount point is not mounted
+	if err != nil && !errors.Is(err, unix.EINVAL) {
+		return fmt.Errorf("DestroyFileSystem: unmounting %s: %w", mountPoint, err)
+	}
+	return nil
+}
+
+// ensureFstabEntry appends a line mounting the device at the mount point with the mount options, unless fstab already
+// mounts something there
+func ensureFstabEntry(path string, device string, mountPoint string, fsType string, options string) error {
+
+	found, err := hasMountEntry(path, mountPoint)
+	if err != nil || found {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, os.ModeAppend)
+	if err != nil {
+		return err
+	}
+	defer f.Close() //nolint:errcheck
+
+	_, err = f.WriteString(fmt.Sprintf("%s\t%s\t%s\t%s\t0\t0\n", device, mountPoint, fsType, options))
+	return err
+}
+
+// hasMountEntry reports whether the fstab formatted file at path, i.e. /etc/fstab or /proc/self/mounts, has a line for
+// the mount point
+func hasMountEntry(path string, mountPoint string) (bool, error) {
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && !strings.HasPrefix(fields[0], "#") && fields[1] == mountPoint {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// removeFstabEntry removes the lines mounting a file system of the given type at the mount point, replacing the file
+// atomically
+func removeFstabEntry(path string, mountPoint string, fsType string) error {
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	lines := strings.SplitAfter(string(b), "\n")
+	kept := make([]string, 0, len(lines))
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && !strings.HasPrefix(fields[0], "#") && fields[1] == mountPoint && fields[2] == fsType {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if len(kept) == len(lines) {
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".ebs-autoscale.tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(kept, "")), info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
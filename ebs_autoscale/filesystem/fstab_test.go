@@ -0,0 +1,55 @@
+package filesystem
+
+import (
+	"gotest.tools/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRemoveFstabEntry(t *testing.T) {
+
+	fstab := `UUID=1234 / xfs defaults 0 0
+/dev/xvdba	/mnt/ebs-autoscale	btrfs	defaults	0	0
+# /dev/xvdba	/mnt/ebs-autoscale	btrfs	defaults	0	0
+/dev/xvdca	/mnt/other	btrfs	defaults	0	0
+`
+	path := filepath.Join(t.TempDir(), "fstab")
+	if err := os.WriteFile(path, []byte(fstab), 0644); err != nil {
+		t.Fatalf("WriteFile Returned an unexpected error: %s", err)
+	}
+
+	if err := removeFstabEntry(path, "/mnt/ebs-autoscale", "btrfs"); err != nil {
+		t.Fatalf("removeFstabEntry Returned an unexpected error: %s", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile Returned an unexpected error: %s", err)
+	}
+	assert.Equal(t, string(b), `UUID=1234 / xfs defaults 0 0
+# /dev/xvdba	/mnt/ebs-autoscale	btrfs	defaults	0	0
+/dev/xvdca	/mnt/other	btrfs	defaults	0	0
+`)
+}
+
+func TestEnsureFstabEntry(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "fstab")
+	if err := os.WriteFile(path, []byte("UUID=1234 / xfs defaults 0 0\n"), 0644); err != nil {
+		t.Fatalf("WriteFile Returned an unexpected error: %s", err)
+	}
+
+	// The line is only written once
+	for n := 0; n < 2; n++ {
+		if err := ensureFstabEntry(path, "/dev/xvdba", "/mnt/ebs-autoscale", "btrfs", defaultMountOptions); err != nil {
+			t.Fatalf("ensureFstabEntry Returned an unexpected error: %s", err)
+		}
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile Returned an unexpected error: %s", err)
+	}
+	assert.Equal(t, string(b), "UUID=1234 / xfs defaults 0 0\n/dev/xvdba\t/mnt/ebs-autoscale\tbtrfs\tdefaults\t0\t0\n")
+}
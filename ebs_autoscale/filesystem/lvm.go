@@ -0,0 +1,218 @@
+package filesystem
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultVolumeGroup   = "ebs-autoscale"
+	defaultLogicalVolume = "data"
+)
+
+// lvmName the names lvm accepts for volume groups and logical volumes
+var lvmName = regexp.MustCompile(`^[a-zA-Z0-9+_.][a-zA-Z0-9+_.-]*$`)
+
+// Lvm spans a logical volume across the devices of a volume group, for the file systems that cannot span devices
+// themselves. Each device is a physical volume of the group.
+type Lvm struct {
+	VolumeGroup   string
+	LogicalVolume string
+	// Stripes the most devices each segment of the logical volume is striped across, 1 for linear
+	Stripes int
+	// StripeSizeKb the size of each stripe, lvm's default when 0
+	StripeSizeKb int
+}
+
+// physicalVolume a device of the volume group and its space not yet allocated to the logical volume, in bytes
+type physicalVolume struct {
+	Name string
+	Free uint64
+}
+
+// newLvm returns the Lvm for the backend options. Supported options: "vg-name", "lv-name", "stripes" and
+// "stripe-size-kb".
+func newLvm(options map[string]interface{}) (Lvm, error) {
+
+	var l Lvm
+	var err error
+	if l.VolumeGroup, err = stringOption(options, "vg-name", defaultVolumeGroup); err != nil {
+		return l, err
+	}
+	if l.LogicalVolume, err = stringOption(options, "lv-name", defaultLogicalVolume); err != nil {
+		return l, err
+	}
+	for _, name := range []string{l.VolumeGroup, l.LogicalVolume} {
+		if !lvmName.MatchString(name) {
+			return l, fmt.Errorf("newLvm: invalid volume group or logical volume name: %q", name)
+		}
+	}
+	if l.Stripes, err = intOption(options, "stripes", 1); err != nil {
+		return l, err
+	}
+	if l.Stripes < 1 {
+		return l, fmt.Errorf("newLvm: stripes must be at least 1: %d", l.Stripes)
+	}
+	if l.StripeSizeKb, err = intOption(options, "stripe-size-kb", 0); err != nil {
+		return l, err
+	}
+	// lvm requires a power of 2 of at least 4Kb
+	if l.StripeSizeKb != 0 && (l.StripeSizeKb < 4 || l.StripeSizeKb&(l.StripeSizeKb-1) != 0) {
+		return l, fmt.Errorf("newLvm: stripe-size-kb must be a power of 2 of at least 4: %d", l.StripeSizeKb)
+	}
+	return l, nil
+}
+
+// DevicePath the device of the logical volume the file system is created on
+func (l Lvm) DevicePath() string {
+	return "/dev/" + l.VolumeGroup + "/" + l.LogicalVolume
+}
+
+// create creates the volume group on the device and a logical volume filling it
+func (l Lvm) create(device string) error {
+
+	if err := RunCommand("pvcreate", "--yes", device); err != nil {
+		return err
+	}
+	if err := RunCommand("vgcreate", "--yes", l.VolumeGroup, device); err != nil {
+		return err
+	}
+	return RunCommand("lvcreate", "--yes", "--name", l.LogicalVolume, "--extents", "100%FREE", l.VolumeGroup)
+}
+
+// extend adds the device to the volume group and extends the logical volume across its space
+func (l Lvm) extend(device string) error {
+
+	if err := RunCommand("pvcreate", "--yes", device); err != nil {
+		return err
+	}
+	if err := RunCommand("vgextend", l.VolumeGroup, device); err != nil {
+		return err
+	}
+	return l.extendLogicalVolume()
+}
+
+// resize grows the physical volume of the device to the size of the device, and extends the logical volume across the
+// space added
+func (l Lvm) resize(device string) error {
+
+	if err := RunCommand("pvresize", device); err != nil {
+		return err
+	}
+	return l.extendLogicalVolume()
+}
+
+// extendLogicalVolume extends the logical volume across the free space of the volume group
+func (l Lvm) extendLogicalVolume() error {
+
+	pvs, err := l.physicalVolumes()
+	if err != nil {
+		return err
+	}
+	return RunCommand("lvextend", l.lvextendArgs(pvs)...)
+}
+
+// lvextendArgs the arguments extending the logical volume across the free space of the physical volumes. A segment can
+// only be striped across the devices with free space, so it is striped across as many of them as Stripes allows.
+// Stripes is always given when set, as lvextend otherwise repeats the striping of the last segment.
+func (l Lvm) lvextendArgs(pvs []physicalVolume) []string {
+
+	args := []string{"--extents", "+100%FREE"}
+	if l.Stripes > 1 {
+		withFree := 0
+		for _, pv := range pvs {
+			if pv.Free > 0 {
+				withFree++
+			}
+		}
+		stripes := max(1, min(l.Stripes, withFree))
+		args = append(args, "--stripes", strconv.Itoa(stripes))
+		if stripes > 1 && l.StripeSizeKb > 0 {
+			args = append(args, "--stripesize", strconv.Itoa(l.StripeSizeKb))
+		}
+	}
+	return append(args, l.DevicePath())
+}
+
+// hasPhysicalVolume reports whether the device is a physical volume of the volume group
+func (l Lvm) hasPhysicalVolume(device string) (bool, error) {
+
+	signature, err := deviceSignature(device)
+	if err != nil || signature != "LVM2_member" {
+		return false, err
+	}
+	out, err := RunCommandOutput("pvs", "--noheadings", "--options", "vg_name", device)
+	if err != nil {
+		return false, err
+	}
+	return out == l.VolumeGroup, nil
+}
+
+// activate activates the logical volume of the volume group, for it to be mounted
+func (l Lvm) activate() error {
+	return RunCommand("vgchange", "--activate", "y", l.VolumeGroup)
+}
+
+// deactivate deactivates the volume group, releasing its devices to be detached. A volume group that does not exist is
+// ignored.
+func (l Lvm) deactivate() error {
+
+	out, err := RunCommandOutput("vgs", "--noheadings", "--options", "vg_name")
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(strings.Fields(out), l.VolumeGroup) {
+		return nil
+	}
+	return RunCommand("vgchange", "--activate", "n", l.VolumeGroup)
+}
+
+// devices lists the devices of the volume group
+func (l Lvm) devices() ([]string, error) {
+
+	pvs, err := l.physicalVolumes()
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]string, 0, len(pvs))
+	for _, pv := range pvs {
+		devices = append(devices, pv.Name)
+	}
+	return devices, nil
+}
+
+// physicalVolumes lists the physical volumes of the volume group, as reported by pvs
+func (l Lvm) physicalVolumes() ([]physicalVolume, error) {
+
+	out, err := RunCommandOutput("pvs", "--noheadings", "--units", "b", "--nosuffix", "--options", "pv_name,pv_free",
+		"--select", "vg_name="+l.VolumeGroup)
+	if err != nil {
+		return nil, err
+	}
+	return parsePvs(out)
+}
+
+// parsePvs parses the pv_name,pv_free columns of pvs output in bytes. Missing devices, reported as [unknown], are
+// skipped.
+func parsePvs(out string) ([]physicalVolume, error) {
+
+	pvs := make([]physicalVolume, 0)
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] == "[unknown]" {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("parsePvs: unexpected line: %s", line)
+		}
+		free, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parsePvs: unexpected line: %s: %w", line, err)
+		}
+		pvs = append(pvs, physicalVolume{Name: fields[0], Free: free})
+	}
+	return pvs, nil
+}
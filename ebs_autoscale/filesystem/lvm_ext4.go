@@ -0,0 +1,115 @@
+package filesystem
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"strconv"
+)
+
+const (
+	// maxReservedBlocksPc the most of the blocks mkfs.ext4 and tune2fs reserve
+	maxReservedBlocksPc = 50
+	// minInodeRatio and maxInodeRatio the bytes per inode mkfs.ext4 accepts
+	minInodeRatio = 1024
+	maxInodeRatio = 64 << 20
+)
+
+func init() {
+	RegisterBackend("lvm-ext4", func(mountPoint string, options map[string]interface{}) (FileSystem, error) {
+		return NewLvmExt4FileSystem(mountPoint, options)
+	})
+}
+
+// LvmExt4FileSystem implements the FileSystem interface with an ext4 file system on an lvm logical volume spanning the
+// devices
+type LvmExt4FileSystem struct {
+	LvmFileSystem
+	// ReservedBlocksPc the percentage of the blocks reserved for root, mkfs.ext4's default of 5 when negative
+	ReservedBlocksPc float64
+	// InodeRatio the bytes per inode, mkfs.ext4's default when 0
+	InodeRatio int
+	// JournalOptions the journal options of mkfs.ext4, i.e. size=256, its default when empty
+	JournalOptions string
+}
+
+// NewLvmExt4FileSystem returns an LvmExt4FileSystem for the backend options. Supported options: "vg-name", "lv-name",
+// "stripes", "stripe-size-kb", "reserved-blocks-pc", "inode-ratio", "journal-options", "mkfs-options" and
+// "mount-options".
+func NewLvmExt4FileSystem(mountPoint string, options map[string]interface{}) (*LvmExt4FileSystem, error) {
+
+	lfs, err := newLvmFileSystem(mountPoint, "ext4", options)
+	if err != nil {
+		return nil, err
+	}
+	fs := &LvmExt4FileSystem{LvmFileSystem: lfs}
+
+	if fs.ReservedBlocksPc, err = floatOption(options, "reserved-blocks-pc", -1); err != nil {
+		return nil, err
+	}
+	if fs.ReservedBlocksPc > maxReservedBlocksPc {
+		return nil, fmt.Errorf("NewLvmExt4FileSystem: reserved-blocks-pc must be at most %d: %v", maxReservedBlocksPc, fs.ReservedBlocksPc)
+	}
+	if fs.InodeRatio, err = intOption(options, "inode-ratio", 0); err != nil {
+		return nil, err
+	}
+	if fs.InodeRatio != 0 && (fs.InodeRatio < minInodeRatio || fs.InodeRatio > maxInodeRatio) {
+		return nil, fmt.Errorf("NewLvmExt4FileSystem: inode-ratio must be between %d and %d: %d", minInodeRatio, maxInodeRatio, fs.InodeRatio)
+	}
+	if fs.JournalOptions, err = stringOption(options, "journal-options", ""); err != nil {
+		return nil, err
+	}
+	fs.mkfsCommand = append([]string{"mkfs.ext4"}, fs.mkfsArgs()...)
+	// resize2fs grows a mounted ext4 file system online through its device
+	fs.growCommand = []string{"resize2fs", fs.Lvm.DevicePath()}
+	return fs, nil
+}
+
+// mkfsArgs the arguments to mkfs.ext4 creating the file system on the logical volume
+func (fs LvmExt4FileSystem) mkfsArgs() []string {
+
+	args := []string{"-F"}
+	if fs.ReservedBlocksPc >= 0 {
+		args = append(args, "-m", strconv.FormatFloat(fs.ReservedBlocksPc, 'f', -1, 64))
+	}
+	if fs.InodeRatio > 0 {
+		args = append(args, "-i", strconv.Itoa(fs.InodeRatio))
+	}
+	if fs.JournalOptions != "" {
+		args = append(args, "-J", fs.JournalOptions)
+	}
+	args = append(args, fs.MkfsOptions...)
+	return append(args, fs.Lvm.DevicePath())
+}
+
+// MountFileSystem mounts the logical volume as the other lvm backends do, then applies the configured reserved blocks
+// with tune2fs so a change of the option takes effect on the existing file system
+func (fs LvmExt4FileSystem) MountFileSystem(device string) error {
+
+	if err := fs.LvmFileSystem.MountFileSystem(device); err != nil {
+		return err
+	}
+
+	if fs.ReservedBlocksPc >= 0 {
+		reserved := strconv.FormatFloat(fs.ReservedBlocksPc, 'f', -1, 64)
+		return RunCommand("tune2fs", "-m", reserved, fs.Lvm.DevicePath())
+	}
+	return nil
+}
+
+// Stat stats the mounted ext4 file system. Returns total_space, used_space, free_space in bytes. The blocks reserved
+// for root are counted as used, as the space is full to everyone else once only they remain.
+func (fs LvmExt4FileSystem) Stat() (uint64, uint64, uint64, error) {
+
+	var stat unix.Statfs_t
+	if err := unix.Statfs(fs.MountPoint, &stat); err != nil {
+		return 0, 0, 0, err
+	}
+	freeSpace := stat.Bavail * uint64(stat.Bsize)
+	totalSpace := stat.Blocks * uint64(stat.Bsize)
+	return totalSpace, totalSpace - freeSpace, freeSpace, nil
+}
+
+// Usage reports the space and inodes of the mounted ext4 file system, its own Stat counting the reserved blocks as used
+func (fs LvmExt4FileSystem) Usage() (*Usage, error) {
+	return statUsage(fs)
+}
@@ -0,0 +1,68 @@
+package filesystem
+
+import (
+	"gotest.tools/assert"
+	"testing"
+)
+
+type TestLvmExt4MkfsArgsInputs struct {
+	Name     string
+	Options  map[string]interface{}
+	Expected []string
+	Error    bool
+}
+
+func TestLvmExt4MkfsArgs(t *testing.T) {
+
+	tests := []TestLvmExt4MkfsArgsInputs{
+		{
+			Name:     "Defaults",
+			Options:  map[string]interface{}{},
+			Expected: []string{"-F", "/dev/ebs-autoscale/data"},
+		},
+		{
+			Name: "Reserved blocks, inode ratio and journal",
+			Options: map[string]interface{}{
+				"reserved-blocks-pc": 0.5,
+				"inode-ratio":        4096,
+				"journal-options":    "size=256",
+				"mkfs-options":       "-O ^has_journal",
+			},
+			Expected: []string{"-F", "-m", "0.5", "-i", "4096", "-J", "size=256", "-O", "^has_journal", "/dev/ebs-autoscale/data"},
+		},
+		{
+			Name:     "No reserved blocks",
+			Options:  map[string]interface{}{"reserved-blocks-pc": 0},
+			Expected: []string{"-F", "-m", "0", "/dev/ebs-autoscale/data"},
+		},
+		{
+			Name:    "Too many reserved blocks",
+			Options: map[string]interface{}{"reserved-blocks-pc": 60},
+			Error:   true,
+		},
+		{
+			Name:    "Inode ratio too small",
+			Options: map[string]interface{}{"inode-ratio": 512},
+			Error:   true,
+		},
+		{
+			Name:    "Journal options not a string",
+			Options: map[string]interface{}{"journal-options": 256},
+			Error:   true,
+		},
+	}
+
+	for _, i := range tests {
+
+		fs, err := NewLvmExt4FileSystem("/mnt/ebs-autoscale", i.Options)
+
+		if (err == nil) == i.Error {
+			t.Errorf("NewLvmExt4FileSystem(%s) Returned an unexpected error: %s", i.Name, err)
+		}
+		if !i.Error {
+			assert.DeepEqual(t, fs.mkfsArgs(), i.Expected)
+			assert.DeepEqual(t, fs.mkfsCommand, append([]string{"mkfs.ext4"}, i.Expected...))
+			assert.DeepEqual(t, fs.growCommand, []string{"resize2fs", "/dev/ebs-autoscale/data"})
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package filesystem
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// LvmFileSystem implements the FileSystem interface with a file system of FsType on an lvm logical volume spanning the
+// devices. The lvm backends embed it, differing only in the commands creating and growing the file system.
+type LvmFileSystem struct {
+	MountPoint string
+	Lvm        Lvm
+	// FsType the type of the file system, as given to mount and written to fstab
+	FsType string
+	// MkfsOptions the additional arguments to mkfs
+	MkfsOptions []string
+	// MountOptions the options of the mount and its fstab line
+	MountOptions string
+	// mkfsCommand the command, and its arguments, creating the file system on the logical volume
+	mkfsCommand []string
+	// growCommand the command, and its arguments, growing the mounted file system online to fill the logical volume
+	growCommand []string
+}
+
+// newLvmFileSystem returns the LvmFileSystem of the type for the backend options shared by the lvm backends: "vg-name",
+// "lv-name", "stripes", "stripe-size-kb", "mkfs-options" and "mount-options". The backend sets its commands.
+func newLvmFileSystem(mountPoint string, fsType string, options map[string]interface{}) (LvmFileSystem, error) {
+
+	fs := LvmFileSystem{MountPoint: mountPoint, FsType: fsType}
+	var err error
+	if fs.Lvm, err = newLvm(options); err != nil {
+		return fs, err
+	}
+	if fs.MkfsOptions, err = stringsOption(options, "mkfs-options"); err != nil {
+		return fs, err
+	}
+	if fs.MountOptions, err = stringOption(options, "mount-options", defaultMountOptions); err != nil {
+		return fs, err
+	}
+	return fs, nil
+}
+
+// GetMountPoint getter for the FileSystem interface
+func (fs LvmFileSystem) GetMountPoint() string {
+	return fs.MountPoint
+}
+
+// CreateFileSystem creates the volume group and logical volume on the given device, and the file system on it
+func (fs LvmFileSystem) CreateFileSystem(device string) error {
+
+	if err := fs.Lvm.create(device); err != nil {
+		return err
+	}
+
+	if err := RunCommand(fs.mkfsCommand[0], fs.mkfsCommand[1:]...); err != nil {
+		return err
+	}
+
+	if err := fs.mount(); err != nil {
+		return err
+	}
+
+	slog.Info("CreateFileSystem: writing to fstab")
+	return ensureFstabEntry(fstabPath, fs.Lvm.DevicePath(), fs.MountPoint, fs.FsType, fs.MountOptions)
+}
+
+// HasFileSystem reports whether the device is a physical volume of the volume group
+func (fs LvmFileSystem) HasFileSystem(device string) (bool, error) {
+	return fs.Lvm.hasPhysicalVolume(device)
+}
+
+// MountFileSystem activates the volume group and mounts its logical volume, unless the mount point is already mounted.
+// The fstab line is written if missing.
+func (fs LvmFileSystem) MountFileSystem(_ string) error {
+
+	mounted, err := hasMountEntry(mountsPath, fs.MountPoint)
+	if err != nil {
+		return err
+	}
+	if !mounted {
+		if err := fs.Lvm.activate(); err != nil {
+			return err
+		}
+		if err := fs.mount(); err != nil {
+			return err
+		}
+	}
+
+	return ensureFstabEntry(fstabPath, fs.Lvm.DevicePath(), fs.MountPoint, fs.FsType, fs.MountOptions)
+}
+
+func (fs LvmFileSystem) mount() error {
+	return RunCommand("mount", "-t", fs.FsType, "-o", fs.MountOptions, fs.Lvm.DevicePath(), fs.MountPoint)
+}
+
+// GrowFileSystem adds the device to the volume group, extends the logical volume across it and grows the file system
+// to fill it
+func (fs LvmFileSystem) GrowFileSystem(device string) error {
+
+	if err := fs.Lvm.extend(device); err != nil {
+		return err
+	}
+	return RunCommand(fs.growCommand[0], fs.growCommand[1:]...)
+}
+
+// ResizeDevice resizes the physical volume of the enlarged device, extends the logical volume and grows the file
+// system to fill it
+func (fs LvmFileSystem) ResizeDevice(device string) error {
+
+	if err := fs.Lvm.resize(device); err != nil {
+		return err
+	}
+	return RunCommand(fs.growCommand[0], fs.growCommand[1:]...)
+}
+
+// Stat stats the mounted file system. Returns total_space, used_space, free_space in bytes
+func (fs LvmFileSystem) Stat() (uint64, uint64, uint64, error) {
+	return statFileSystem(fs.MountPoint)
+}
+
+// Usage reports the space and inodes of the mounted file system
+func (fs LvmFileSystem) Usage() (*Usage, error) {
+	return statUsage(fs)
+}
+
+// GrowAddsInodes xfs allocates inodes on demand up to a percentage of its space and ext4 has a fixed number per block
+// group, so growing either adds inodes
+func (fs LvmFileSystem) GrowAddsInodes() bool {
+	return true
+}
+
+// Devices lists the devices of the volume group, as reported by pvs
+func (fs LvmFileSystem) Devices() ([]string, error) {
+	return fs.Lvm.devices()
+}
+
+// DestroyFileSystem unmounts the file system, deactivates the volume group for its devices to be detached and removes
+// the fstab line written by CreateFileSystem. When forced, a busy mount is lazily detached and the volume group left
+// active should it still be in use.
+func (fs LvmFileSystem) DestroyFileSystem(force bool) error {
+
+	if err := unmountFileSystem(fs.MountPoint, force); err != nil {
+		return err
+	}
+
+	// a lazily detached mount keeps the logical volume open until it is released
+	if err := fs.Lvm.deactivate(); err != nil {
+		if !force {
+			return err
+		}
+		slog.Warn(fmt.Sprintf("DestroyFileSystem: deactivating %s: %s", fs.Lvm.VolumeGroup, err))
+	}
+
+	slog.Info("DestroyFileSystem: removing from fstab")
+	return removeFstabEntry(fstabPath, fs.MountPoint, fs.FsType)
+}
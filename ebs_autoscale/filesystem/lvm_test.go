@@ -0,0 +1,125 @@
+package filesystem
+
+import (
+	"gotest.tools/assert"
+	"testing"
+)
+
+type TestNewLvmInputs struct {
+	Name     string
+	Options  map[string]interface{}
+	Expected Lvm
+	Error    bool
+}
+
+func TestNewLvm(t *testing.T) {
+
+	tests := []TestNewLvmInputs{
+		{
+			Name:     "Defaults",
+			Options:  map[string]interface{}{},
+			Expected: Lvm{VolumeGroup: "ebs-autoscale", LogicalVolume: "data", Stripes: 1},
+		},
+		{
+			Name: "Named and striped",
+			Options: map[string]interface{}{
+				"vg-name":        "scratch",
+				"lv-name":        "tmp",
+				"stripes":        4.0,
+				"stripe-size-kb": 64,
+			},
+			Expected: Lvm{VolumeGroup: "scratch", LogicalVolume: "tmp", Stripes: 4, StripeSizeKb: 64},
+		},
+		{
+			Name:    "Invalid name",
+			Options: map[string]interface{}{"vg-name": "-scratch"},
+			Error:   true,
+		},
+		{
+			Name:    "Name not a string",
+			Options: map[string]interface{}{"lv-name": 1},
+			Error:   true,
+		},
+		{
+			Name:    "Stripes not a whole number",
+			Options: map[string]interface{}{"stripes": 1.5},
+			Error:   true,
+		},
+		{
+			Name:    "No stripes",
+			Options: map[string]interface{}{"stripes": 0},
+			Error:   true,
+		},
+		{
+			Name:    "Stripe size not a power of 2",
+			Options: map[string]interface{}{"stripe-size-kb": 48},
+			Error:   true,
+		},
+	}
+
+	for _, i := range tests {
+
+		got, err := newLvm(i.Options)
+
+		if (err == nil) == i.Error {
+			t.Errorf("newLvm(%s) Returned an unexpected error: %s", i.Name, err)
+		}
+		if !i.Error {
+			assert.DeepEqual(t, got, i.Expected)
+		}
+	}
+}
+
+type TestLvextendArgsInputs struct {
+	Name     string
+	Lvm      Lvm
+	Pvs      []physicalVolume
+	Expected []string
+}
+
+func TestLvextendArgs(t *testing.T) {
+
+	striped := Lvm{VolumeGroup: "ebs-autoscale", LogicalVolume: "data", Stripes: 2, StripeSizeKb: 64}
+
+	tests := []TestLvextendArgsInputs{
+		{
+			Name:     "Linear",
+			Lvm:      Lvm{VolumeGroup: "ebs-autoscale", LogicalVolume: "data", Stripes: 1},
+			Pvs:      []physicalVolume{{Name: "/dev/nvme1n1", Free: 0}, {Name: "/dev/nvme2n1", Free: 1 << 30}},
+			Expected: []string{"--extents", "+100%FREE", "/dev/ebs-autoscale/data"},
+		},
+		{
+			Name:     "Striped across the devices with free space",
+			Lvm:      striped,
+			Pvs:      []physicalVolume{{Name: "/dev/nvme1n1", Free: 1 << 30}, {Name: "/dev/nvme2n1", Free: 1 << 30}},
+			Expected: []string{"--extents", "+100%FREE", "--stripes", "2", "--stripesize", "64", "/dev/ebs-autoscale/data"},
+		},
+		{
+			Name:     "A single device with free space is linear",
+			Lvm:      striped,
+			Pvs:      []physicalVolume{{Name: "/dev/nvme1n1", Free: 0}, {Name: "/dev/nvme2n1", Free: 1 << 30}},
+			Expected: []string{"--extents", "+100%FREE", "--stripes", "1", "/dev/ebs-autoscale/data"},
+		},
+	}
+
+	for _, i := range tests {
+		assert.DeepEqual(t, i.Lvm.lvextendArgs(i.Pvs), i.Expected)
+	}
+}
+
+func TestParsePvs(t *testing.T) {
+
+	out := `  /dev/nvme1n1  0
+  /dev/nvme2n1  53682896896
+  [unknown]     0
+`
+	got, err := parsePvs(out)
+	if err != nil {
+		t.Fatalf("parsePvs Returned an unexpected error: %s", err)
+	}
+	assert.DeepEqual(t, got, []physicalVolume{{Name: "/dev/nvme1n1", Free: 0}, {Name: "/dev/nvme2n1", Free: 53682896896}})
+
+	if _, err := parsePvs("  /dev/nvme1n1  lots\n"); err == nil {
+		t.Errorf("parsePvs Expected an error for a free space that is not a number")
+	}
+}
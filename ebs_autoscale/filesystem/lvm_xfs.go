@@ -0,0 +1,27 @@
+package filesystem
+
+func init() {
+	RegisterBackend("lvm-xfs", func(mountPoint string, options map[string]interface{}) (FileSystem, error) {
+		return NewLvmXfsFileSystem(mountPoint, options)
+	})
+}
+
+// LvmXfsFileSystem implements the FileSystem interface with an xfs file system on an lvm logical volume spanning the
+// devices
+type LvmXfsFileSystem struct {
+	LvmFileSystem
+}
+
+// NewLvmXfsFileSystem returns an LvmXfsFileSystem for the backend options. Supported options: "vg-name", "lv-name",
+// "stripes", "stripe-size-kb", "mkfs-options" and "mount-options".
+func NewLvmXfsFileSystem(mountPoint string, options map[string]interface{}) (*LvmXfsFileSystem, error) {
+
+	lfs, err := newLvmFileSystem(mountPoint, "xfs", options)
+	if err != nil {
+		return nil, err
+	}
+	lfs.mkfsCommand = append(append([]string{"mkfs.xfs", "-f"}, lfs.MkfsOptions...), lfs.Lvm.DevicePath())
+	// xfs grows through its mount point
+	lfs.growCommand = []string{"xfs_growfs", mountPoint}
+	return &LvmXfsFileSystem{LvmFileSystem: lfs}, nil
+}
@@ -0,0 +1,36 @@
+package filesystem
+
+import (
+	"gotest.tools/assert"
+	"testing"
+)
+
+func TestNewLvmXfsFileSystem(t *testing.T) {
+
+	fs, err := NewLvmXfsFileSystem("/mnt/ebs-autoscale", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("NewLvmXfsFileSystem Returned an unexpected error: %s", err)
+	}
+	assert.Equal(t, fs.MountOptions, "defaults")
+	assert.Equal(t, len(fs.MkfsOptions), 0)
+	assert.Equal(t, fs.Lvm.DevicePath(), "/dev/ebs-autoscale/data")
+	assert.DeepEqual(t, fs.mkfsCommand, []string{"mkfs.xfs", "-f", "/dev/ebs-autoscale/data"})
+	assert.DeepEqual(t, fs.growCommand, []string{"xfs_growfs", "/mnt/ebs-autoscale"})
+
+	// mkfs options may be a list or a string of arguments
+	for _, mkfsOptions := range []interface{}{[]interface{}{"-m", "reflink=1"}, "-m reflink=1"} {
+		fs, err = NewLvmXfsFileSystem("/mnt/ebs-autoscale", map[string]interface{}{
+			"mkfs-options":  mkfsOptions,
+			"mount-options": "noatime,nofail",
+		})
+		if err != nil {
+			t.Fatalf("NewLvmXfsFileSystem(%v) Returned an unexpected error: %s", mkfsOptions, err)
+		}
+		assert.DeepEqual(t, fs.MkfsOptions, []string{"-m", "reflink=1"})
+		assert.Equal(t, fs.MountOptions, "noatime,nofail")
+	}
+
+	if _, err := NewLvmXfsFileSystem("/mnt/ebs-autoscale", map[string]interface{}{"mkfs-options": []interface{}{1}}); err == nil {
+		t.Errorf("NewLvmXfsFileSystem Expected an error for mkfs-options that are not strings")
+	}
+}
@@ -0,0 +1,120 @@
+package filesystem
+
+import "golang.org/x/sys/unix"
+
+// InodeLimited is implemented by the file systems with a limited number of inodes
+type InodeLimited interface {
+	// GrowAddsInodes whether growing the file system adds inodes, when it does not running out can only be alerted on
+	GrowAddsInodes() bool
+}
+
+// SpaceUsage the space allocated to a block group type and the part of it used, in bytes
+type SpaceUsage struct {
+	Allocated uint64 `json:"allocated"`
+	Used      uint64 `json:"used"`
+}
+
+// Usage is a detailed report of the space of a file system, in bytes. A file system without block groups reports all
+// of its space as Data.
+type Usage struct {
+	// Size the combined size of the devices
+	Size     uint64     `json:"size"`
+	Data     SpaceUsage `json:"data"`
+	Metadata SpaceUsage `json:"metadata"`
+	System   SpaceUsage `json:"system"`
+	// Unallocated the space of each device not yet allocated to any block group, keyed by device
+	Unallocated map[string]uint64 `json:"unallocated,omitempty"`
+	// GlobalReserve the metadata space held back for operations that must not fail, Used is the part in use
+	GlobalReserve SpaceUsage `json:"global-reserve"`
+	// DataRatio and MetadataRatio the raw bytes allocated for every byte of data or metadata, i.e. 2 for DUP
+	DataRatio     float64 `json:"data-ratio"`
+	MetadataRatio float64 `json:"metadata-ratio"`
+	// Inodes and InodesFree the inodes of the file system, 0 when it allocates inodes on demand i.e. btrfs
+	Inodes     uint64 `json:"inodes"`
+	InodesFree uint64 `json:"inodes-free"`
+}
+
+// UnallocatedTotal the unallocated space across every device
+func (u Usage) UnallocatedTotal() uint64 {
+
+	total := uint64(0)
+	for _, unallocated := range u.Unallocated {
+		total += unallocated
+	}
+	return total
+}
+
+// DataPercent the used data as a percentage of the data allocated plus the data the unallocated space could hold
+func (u Usage) DataPercent() float32 {
+	return percentOf(float64(u.Data.Used), float64(u.Data.Allocated)+float64(u.UnallocatedTotal())/ratio(u.DataRatio))
+}
+
+// MetadataPercent the used metadata, counting the global reserve, as a percentage of the metadata allocated plus the
+// metadata the unallocated space could hold. Metadata is exhausted, and writes fail, as this reaches 100 even while
+// the data has room.
+func (u Usage) MetadataPercent() float32 {
+	used := float64(u.Metadata.Used) + float64(u.GlobalReserve.Allocated)
+	return percentOf(used, float64(u.Metadata.Allocated)+float64(u.UnallocatedTotal())/ratio(u.MetadataRatio))
+}
+
+// InodePercent the used inodes as a percentage of the inodes, 0 when the file system has no fixed number of inodes
+func (u Usage) InodePercent() float32 {
+	return percentOf(float64(u.Inodes-min(u.InodesFree, u.Inodes)), float64(u.Inodes))
+}
+
+// statFileSystem stats the file system mounted at the mount point. Returns total_space, used_space, free_space in bytes
+func statFileSystem(mountPoint string) (uint64, uint64, uint64, error) {
+
+	var stat unix.Statfs_t
+	if err := unix.Statfs(mountPoint, &stat); err != nil {
+		return 0, 0, 0, err
+	}
+	freeSpace := stat.Bfree * uint64(stat.Bsize)
+	totalSpace := stat.Blocks * uint64(stat.Bsize)
+	return totalSpace, totalSpace - freeSpace, freeSpace, nil
+}
+
+// statUsage reports the space of a file system without block groups as its Stat does, all of it as Data
+func statUsage(fs FileSystem) (*Usage, error) {
+
+	total, used, _, err := fs.Stat()
+	if err != nil {
+		return nil, err
+	}
+	inodes, inodesFree, err := statInodes(fs.GetMountPoint())
+	if err != nil {
+		return nil, err
+	}
+	return &Usage{
+		Size:          total,
+		Data:          SpaceUsage{Allocated: total, Used: used},
+		DataRatio:     1,
+		MetadataRatio: 1,
+		Inodes:        inodes,
+		InodesFree:    inodesFree,
+	}, nil
+}
+
+// statInodes returns the total and free inodes of the file system mounted at the mount point
+func statInodes(mountPoint string) (uint64, uint64, error) {
+
+	var stat unix.Statfs_t
+	if err := unix.Statfs(mountPoint, &stat); err != nil {
+		return 0, 0, err
+	}
+	return stat.Files, stat.Ffree, nil
+}
+
+func ratio(r float64) float64 {
+	if r <= 0 {
+		return 1
+	}
+	return r
+}
+
+func percentOf(used float64, capacity float64) float32 {
+	if capacity <= 0 {
+		return 0
+	}
+	return float32(used / capacity * 100)
+}
@@ -0,0 +1,163 @@
+package ebs_autoscale
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	defaultGcIntervalSec    = 3600
+	defaultGcGracePeriodSec = 86400
+
+	// gcAllowAny allows the volumes of any filesystem to be collected
+	gcAllowAny = "*"
+
+	// OrphanAvailable the volume is not attached to any host
+	OrphanAvailable = "available"
+	// OrphanSourceInstanceGone the volume is not attached and the host that created it no longer exists
+	OrphanSourceInstanceGone = "source-instance-gone"
+)
+
+// Orphan is a volume found by GarbageCollect
+type Orphan struct {
+	VolumeId       string    `json:"volume-id"`
+	AutoscaleId    string    `json:"autoscale-id"`
+	SourceInstance string    `json:"source-instance,omitempty"`
+	CreateTime     time.Time `json:"create-time"`
+	Reason         string    `json:"reason"`
+	// Deleted whether the volume was deleted, false in dry-run mode or when the delete failed
+	Deleted bool `json:"deleted"`
+}
+
+// GcResult records the orphans found by GarbageCollect
+type GcResult struct {
+	DryRun  bool     `json:"dry-run"`
+	Orphans []Orphan `json:"orphans"`
+}
+
+// GarbageCollect finds the volumes tagged with an ebs-autoscale-id that nobody will attach again, i.e. those left
+// behind when a best effort removeVolume failed, and deletes them unless cfg.DryRun is set. A volume is an orphan when
+// it is not attached, is older than the grace period and is either available or its source instance no longer exists.
+// Only the volumes of the filesystems in the allow-list are considered. Persistent volumes and the volume of an
+// operation in flight on this filesystem are never collected. Every orphan is attempted, the errors of those that
+// failed are returned together.
+func (v *Volume) GarbageCollect(ctx context.Context, cfg GcCfg, now func() time.Time) (*GcResult, error) {
+
+	if err := cfg.validateGracePeriod(); err != nil {
+		return nil, err
+	}
+	allowList := cfg.AllowList
+	if len(allowList) == 0 {
+		allowList = []string{v.Id}
+	}
+	gracePeriod := time.Duration(cfg.GracePeriod) * time.Second
+
+	taggedVolumes, err := v.Provider.ListVolumes(ctx, map[string]string{autoscaleIdTag: ""})
+	if err != nil {
+		return nil, err
+	}
+
+	inFlight := ""
+	entry, err := v.Journal.Load()
+	if err != nil {
+		return nil, err
+	}
+	if entry != nil {
+		inFlight = entry.VolumeId
+	}
+
+	result := GcResult{DryRun: cfg.DryRun, Orphans: []Orphan{}}
+	exists := map[string]bool{}
+	var errList []error
+	for _, mv := range taggedVolumes {
+
+		// the volumes of a persistent filesystem are detached between hosts by design
+		autoscaleId, _ := mv.Tag(autoscaleIdTag)
+		_, persistent := mv.Tag(filesystemNameTag)
+		if !gcAllowed(allowList, autoscaleId) || mv.AttachedTo != "" || mv.VolumeId == inFlight || persistent {
+			continue
+		}
+		if now().Sub(mv.CreateTime) < gracePeriod {
+			continue
+		}
+
+		orphan := Orphan{
+			VolumeId:    mv.VolumeId,
+			AutoscaleId: autoscaleId,
+			CreateTime:  mv.CreateTime,
+			Reason:      OrphanAvailable,
+		}
+		orphan.SourceInstance, _ = mv.Tag(sourceInstanceTag)
+
+		// The source instance is looked up once per instance, where the provider is able to
+		if checker, ok := v.Provider.(InstanceChecker); ok && orphan.SourceInstance != "" {
+			found, checked := exists[orphan.SourceInstance]
+			if !checked {
+				found, err = checker.InstanceExists(ctx, orphan.SourceInstance)
+				if err != nil {
+					return nil, err
+				}
+				exists[orphan.SourceInstance] = found
+			}
+			if !found {
+				orphan.Reason = OrphanSourceInstanceGone
+			}
+		}
+		// a volume still being created or deleted is only an orphan once its host is gone
+		if orphan.Reason == OrphanAvailable && mv.State != "available" {
+			continue
+		}
+
+		if cfg.DryRun {
+			slog.Info(fmt.Sprintf("GarbageCollect: dry-run: would delete orphaned volume %s (%s)", mv.VolumeId, orphan.Reason))
+		} else {
+			slog.Info(fmt.Sprintf("GarbageCollect: deleting orphaned volume %s (%s)", mv.VolumeId, orphan.Reason))
+			err = v.Provider.DeleteVolume(ctx, mv.VolumeId)
+			if err != nil {
+				errList = append(errList, fmt.Errorf("GarbageCollect: %s: %w", mv.VolumeId, err))
+			}
+			orphan.Deleted = err == nil
+		}
+		result.Orphans = append(result.Orphans, orphan)
+	}
+
+	return &result, errors.Join(errList...)
+}
+
+// Validate rejects an interval that is not positive and a grace period GarbageCollect would refuse
+func (c GcCfg) Validate() error {
+
+	if c.Interval <= 0 {
+		return fmt.Errorf("Validate: gc.interval must be greater than 0: %d", c.Interval)
+	}
+	return c.validateGracePeriod()
+}
+
+// validateGracePeriod rejects a negative grace period, and when any filesystem may be collected one shorter than a
+// volume of another host may be left detached while it is created and attached
+func (c GcCfg) validateGracePeriod() error {
+
+	if c.GracePeriod < 0 {
+		return fmt.Errorf("GarbageCollect: the grace period must not be negative: %d", c.GracePeriod)
+	}
+	minGracePeriod := volumeAvailableTimeout + deviceAvailableTimeout
+	for _, a := range c.AllowList {
+		if a == gcAllowAny && time.Duration(c.GracePeriod)*time.Second < minGracePeriod {
+			return fmt.Errorf("GarbageCollect: the grace period must be at least %s when the allow-list is %q: %ds",
+				minGracePeriod, gcAllowAny, c.GracePeriod)
+		}
+	}
+	return nil
+}
+
+func gcAllowed(allowList []string, autoscaleId string) bool {
+	for _, a := range allowList {
+		if a == gcAllowAny || a == autoscaleId {
+			return true
+		}
+	}
+	return false
+}
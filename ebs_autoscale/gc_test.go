@@ -0,0 +1,286 @@
+package ebs_autoscale
+
+import (
+	"context"
+	"fmt"
+	"github.com/BobTheTerrible/ebs-autoscale/ebs_autoscale/awsfake"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"gotest.tools/assert"
+	"testing"
+	"time"
+)
+
+// putTaggedVolume adds a volume tagged for the filesystem and source instance, created at the given time
+func putTaggedVolume(fake *awsfake.Ec2, volumeId string, autoscaleId string, sourceInstance string, created time.Time, state types.VolumeState) {
+	fake.PutVolume(types.Volume{
+		VolumeId:   aws.String(volumeId),
+		State:      state,
+		CreateTime: aws.Time(created),
+		Tags: []types.Tag{
+			{Key: aws.String(autoscaleIdTag), Value: aws.String(autoscaleId)},
+			{Key: aws.String(sourceInstanceTag), Value: aws.String(sourceInstance)},
+		},
+	})
+}
+
+type TestGarbageCollectInputs struct {
+	Name string
+	Cfg  GcCfg
+	// Setup prepares the fake and volume before the call
+	Setup           func(fake *awsfake.Ec2, volume *Volume)
+	ExpectedOrphans []Orphan
+	// ExpectedVolumes the ids of the volumes remaining in ec2
+	ExpectedVolumes []string
+	Error           bool
+}
+
+func TestGarbageCollect(t *testing.T) {
+
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-48 * time.Hour)
+	grace := GcCfg{GracePeriod: 3600}
+
+	tests := []TestGarbageCollectInputs{
+		{
+			Name: "Available volume past the grace period",
+			Cfg:  grace,
+			Setup: func(fake *awsfake.Ec2, volume *Volume) {
+				fake.PutInstance("i-source", nil)
+				putTaggedVolume(fake, "vol-1", volume.Id, "i-source", old, types.VolumeStateAvailable)
+			},
+			ExpectedOrphans: []Orphan{{VolumeId: "vol-1", Reason: OrphanAvailable, SourceInstance: "i-source", Deleted: true}},
+			ExpectedVolumes: nil,
+			Error:           false,
+		},
+		{
+			Name: "Available volume within the grace period",
+			Cfg:  grace,
+			Setup: func(fake *awsfake.Ec2, volume *Volume) {
+				fake.PutInstance("i-source", nil)
+				putTaggedVolume(fake, "vol-1", volume.Id, "i-source", now.Add(-time.Minute), types.VolumeStateAvailable)
+			},
+			ExpectedOrphans: nil,
+			ExpectedVolumes: []string{"vol-1"},
+			Error:           false,
+		},
+		{
+			Name: "Attached volumes are kept",
+			Cfg:  grace,
+			Setup: func(fake *awsfake.Ec2, volume *Volume) {
+				putManagedVolume(fake, "vol-1", volume.Id, volume.Host.InstanceId, "/dev/xvdba")
+			},
+			ExpectedOrphans: nil,
+			ExpectedVolumes: []string{"vol-1"},
+			Error:           false,
+		},
+		{
+			Name: "Source instance terminated",
+			Cfg:  grace,
+			Setup: func(fake *awsfake.Ec2, volume *Volume) {
+				fake.PutInstance("i-source", nil)
+				fake.TerminateInstance("i-source")
+				putTaggedVolume(fake, "vol-1", volume.Id, "i-source", old, types.VolumeStateError)
+				putTaggedVolume(fake, "vol-2", volume.Id, "i-unknown", old, types.VolumeStateAvailable)
+			},
+			ExpectedOrphans: []Orphan{
+				{VolumeId: "vol-1", Reason: OrphanSourceInstanceGone, SourceInstance: "i-source", Deleted: true},
+				{VolumeId: "vol-2", Reason: OrphanSourceInstanceGone, SourceInstance: "i-unknown", Deleted: true},
+			},
+			ExpectedVolumes: nil,
+			Error:           false,
+		},
+		{
+			Name: "Volume in error state of a running instance is kept",
+			Cfg:  grace,
+			Setup: func(fake *awsfake.Ec2, volume *Volume) {
+				fake.PutInstance("i-source", nil)
+				putTaggedVolume(fake, "vol-1", volume.Id, "i-source", old, types.VolumeStateError)
+			},
+			ExpectedOrphans: nil,
+			ExpectedVolumes: []string{"vol-1"},
+			Error:           false,
+		},
+		{
+			Name: "Persistent volumes are kept",
+			Cfg:  grace,
+			Setup: func(fake *awsfake.Ec2, volume *Volume) {
+				putPersistentVolume(fake, "vol-1", volume, volume.Host.AvailabilityZone)
+			},
+			ExpectedOrphans: nil,
+			ExpectedVolumes: []string{"vol-1"},
+			Error:           false,
+		},
+		{
+			Name: "Dry run only reports",
+			Cfg:  GcCfg{GracePeriod: 3600, DryRun: true},
+			Setup: func(fake *awsfake.Ec2, volume *Volume) {
+				fake.PutInstance("i-source", nil)
+				putTaggedVolume(fake, "vol-1", volume.Id, "i-source", old, types.VolumeStateAvailable)
+			},
+			ExpectedOrphans: []Orphan{{VolumeId: "vol-1", Reason: OrphanAvailable, SourceInstance: "i-source", Deleted: false}},
+			ExpectedVolumes: []string{"vol-1"},
+			Error:           false,
+		},
+		{
+			Name: "Other filesystems are not in the default allow-list",
+			Cfg:  grace,
+			Setup: func(fake *awsfake.Ec2, volume *Volume) {
+				fake.PutInstance("i-source", nil)
+				putTaggedVolume(fake, "vol-1", "other_id", "i-source", old, types.VolumeStateAvailable)
+			},
+			ExpectedOrphans: nil,
+			ExpectedVolumes: []string{"vol-1"},
+			Error:           false,
+		},
+		{
+			Name: "Allow-list admits any filesystem",
+			Cfg:  GcCfg{GracePeriod: 3600, AllowList: []string{gcAllowAny}},
+			Setup: func(fake *awsfake.Ec2, volume *Volume) {
+				fake.PutInstance("i-source", nil)
+				putTaggedVolume(fake, "vol-1", "other_id", "i-source", old, types.VolumeStateAvailable)
+			},
+			ExpectedOrphans: []Orphan{{VolumeId: "vol-1", AutoscaleId: "other_id", Reason: OrphanAvailable, SourceInstance: "i-source", Deleted: true}},
+			ExpectedVolumes: nil,
+			Error:           false,
+		},
+		{
+			Name: "Failed delete is reported",
+			Cfg:  grace,
+			Setup: func(fake *awsfake.Ec2, volume *Volume) {
+				fake.PutInstance("i-source", nil)
+				putTaggedVolume(fake, "vol-1", volume.Id, "i-source", old, types.VolumeStateAvailable)
+				fake.FailNext("DeleteVolume", fmt.Errorf("mock error"))
+			},
+			ExpectedOrphans: []Orphan{{VolumeId: "vol-1", Reason: OrphanAvailable, SourceInstance: "i-source", Deleted: false}},
+			ExpectedVolumes: []string{"vol-1"},
+			Error:           true,
+		},
+	}
+
+	for _, i := range tests {
+
+		fake := awsfake.NewEc2()
+		volume := newFakeVolume(t, fake)
+		i.Setup(fake, &volume)
+
+		result, err := volume.GarbageCollect(context.Background(), i.Cfg, func() time.Time { return now })
+
+		if (err == nil) == i.Error {
+			t.Errorf("GarbageCollect(%s) Returned an unexpected error: %s", i.Name, err)
+		}
+		assert.DeepEqual(t, result.Orphans, i.ExpectedOrphans, cmpopts.EquateEmpty(), cmpopts.IgnoreFields(Orphan{}, "AutoscaleId", "CreateTime"))
+		assert.Equal(t, result.DryRun, i.Cfg.DryRun)
+		remaining := make([]string, 0)
+		for _, v := range fake.Volumes() {
+			remaining = append(remaining, *v.VolumeId)
+		}
+		assert.DeepEqual(t, remaining, i.ExpectedVolumes, cmpopts.EquateEmpty())
+	}
+}
+
+type TestGcCfgValidateInputs struct {
+	Name  string
+	Cfg   GcCfg
+	Error bool
+}
+
+func TestGcCfgValidate(t *testing.T) {
+
+	tests := []TestGcCfgValidateInputs{
+		{
+			Name:  "Defaults",
+			Cfg:   GcCfg{Interval: defaultGcIntervalSec, GracePeriod: defaultGcGracePeriodSec},
+			Error: false,
+		},
+		{
+			Name:  "No grace period for this filesystem",
+			Cfg:   GcCfg{Interval: defaultGcIntervalSec, GracePeriod: 0},
+			Error: false,
+		},
+		{
+			Name:  "No grace period for any filesystem",
+			Cfg:   GcCfg{Interval: defaultGcIntervalSec, GracePeriod: 0, AllowList: []string{"fs_id", gcAllowAny}},
+			Error: true,
+		},
+		{
+			Name:  "Negative grace period",
+			Cfg:   GcCfg{Interval: defaultGcIntervalSec, GracePeriod: -1},
+			Error: true,
+		},
+		{
+			Name:  "Zero interval",
+			Cfg:   GcCfg{Interval: 0, GracePeriod: defaultGcGracePeriodSec},
+			Error: true,
+		},
+	}
+
+	for _, i := range tests {
+
+		err := i.Cfg.Validate()
+		if (err == nil) == i.Error {
+			t.Errorf("Validate(%s) Returned an unexpected error: %s", i.Name, err)
+		}
+	}
+}
+
+func TestGarbageCollectRejectsGracePeriod(t *testing.T) {
+
+	fake := awsfake.NewEc2()
+	volume := newFakeVolume(t, fake)
+	fake.PutInstance("i-source", nil)
+	putTaggedVolume(fake, "vol-1", "other_id", "i-source", time.Time{}, types.VolumeStateAvailable)
+
+	_, err := volume.GarbageCollect(context.Background(), GcCfg{GracePeriod: 0, AllowList: []string{gcAllowAny}}, time.Now)
+	if err == nil {
+		t.Errorf("GarbageCollect collected without a grace period for any filesystem")
+	}
+	assert.Equal(t, len(fake.Volumes()), 1)
+}
+
+func TestMonitorCollectGarbage(t *testing.T) {
+
+	monitor := newTestMonitor(t, 100)
+	monitor.Gc = &GcCfg{Interval: 3600, GracePeriod: 3600}
+	monitor.fake.PutInstance("i-source", nil)
+	putTaggedVolume(monitor.fake, "vol-1", monitor.Volume.Id, "i-source", monitor.start, types.VolumeStateAvailable)
+
+	// the grace period is measured on the clock of the monitor
+	monitor.at(30 * time.Minute)
+	monitor.collectGarbage(context.Background(), monitor.now())
+	assert.Equal(t, len(monitor.fake.Volumes()), 1)
+
+	monitor.at(75 * time.Minute)
+	monitor.collectGarbage(context.Background(), monitor.now())
+	assert.Equal(t, len(monitor.fake.Volumes()), 1, "collected before the interval passed")
+
+	monitor.at(90 * time.Minute)
+	monitor.collectGarbage(context.Background(), monitor.now())
+	assert.Equal(t, len(monitor.fake.Volumes()), 0)
+}
+
+func TestGarbageCollectSkipsInFlightVolume(t *testing.T) {
+
+	fake := awsfake.NewEc2()
+	volume := newFakeVolume(t, fake)
+	fake.PutInstance(volume.Host.InstanceId, nil)
+	putTaggedVolume(fake, "vol-1", volume.Id, volume.Host.InstanceId, time.Time{}, types.VolumeStateAvailable)
+
+	journal, err := NewJournal(t.TempDir(), volume.Id)
+	if err != nil {
+		t.Fatalf("NewJournal Returned an unexpected error: %s", err)
+	}
+	volume.Journal = journal
+	journal.entry = &JournalEntry{Operation: operationGrow, Step: StepCreated, VolumeId: "vol-1"}
+	if err := journal.save(); err != nil {
+		t.Fatalf("Journal.save Returned an unexpected error: %s", err)
+	}
+
+	result, err := volume.GarbageCollect(context.Background(), GcCfg{}, time.Now)
+	if err != nil {
+		t.Fatalf("GarbageCollect Returned an unexpected error: %s", err)
+	}
+	assert.Equal(t, len(result.Orphans), 0)
+	assert.Equal(t, len(fake.Volumes()), 1)
+}
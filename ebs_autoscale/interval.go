@@ -0,0 +1,78 @@
+package ebs_autoscale
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	defaultPollInterval    = 3 * time.Second
+	defaultMinPollInterval = 250 * time.Millisecond
+
+	// adaptivePollsToThreshold the polls the adaptive interval allows before the usage can reach the next threshold at
+	// the estimated fill rate
+	adaptivePollsToThreshold = 10
+	// adaptiveCloseBandPc how close in percentage points to the next threshold the usage is before the adaptive
+	// interval shortens towards MinPollInterval
+	adaptiveCloseBandPc = 10
+)
+
+// pollInterval returns the interval before the next assessment: the interval of the tier the usage was last within
+// when it sets one, otherwise the adaptive interval when adapting, otherwise PollInterval
+func (m *MonitorVolume) pollInterval() time.Duration {
+
+	tiers := m.tiers()
+	if m.tier >= 0 && m.tier < len(tiers) && tiers[m.tier].PollInterval > 0 {
+		return tiers[m.tier].PollInterval
+	}
+	if m.MaxPollInterval > 0 && m.adaptive > 0 {
+		return m.adaptive
+	}
+	return m.PollInterval
+}
+
+// adaptInterval sets the adaptive poll interval from the headroom left before the next threshold, or before the
+// filesystem is full once every threshold has been reached. The interval shortens from MaxPollInterval towards
+// MinPollInterval as the usage comes within adaptiveCloseBandPc of the threshold, and is short enough for
+// adaptivePollsToThreshold polls before the estimated fill rate uses up the headroom. Nothing is adapted unless
+// MaxPollInterval is set.
+func (m *MonitorVolume) adaptInterval(usage float32, total uint64, free uint64) {
+
+	if m.MaxPollInterval <= 0 {
+		return
+	}
+	lo, hi := m.MinPollInterval, m.MaxPollInterval
+	if lo <= 0 {
+		lo = defaultMinPollInterval
+	}
+	lo = min(lo, hi)
+
+	next := float32(100)
+	for _, tier := range m.tiers() {
+		if tier.ThresholdPc > usage {
+			next = tier.ThresholdPc
+			break
+		}
+	}
+	headroomPc := max(next-usage, 0)
+	headroomBytes := float64(headroomPc) / 100 * float64(total)
+	if minFree := uint64(m.MinFreeGb) << 30; m.MinFreeGb > 0 && free > minFree {
+		headroomBytes = min(headroomBytes, float64(free-minFree))
+	}
+
+	interval := hi
+	if headroomPc < adaptiveCloseBandPc {
+		interval = lo + time.Duration(float64(hi-lo)*float64(headroomPc)/adaptiveCloseBandPc)
+	}
+	if rate, ok := m.fillRate.BytesPerSecond(); ok && rate > 0 {
+		untilThreshold := time.Duration(headroomBytes / rate / adaptivePollsToThreshold * float64(time.Second))
+		interval = min(interval, untilThreshold)
+	}
+	interval = max(interval, lo)
+
+	if interval != m.adaptive {
+		slog.Debug(fmt.Sprintf("adaptInterval: polling every %s at %f usage: %s", interval, usage, m.Volume.Fs.GetMountPoint()))
+	}
+	m.adaptive = interval
+}
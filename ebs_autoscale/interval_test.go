@@ -0,0 +1,134 @@
+package ebs_autoscale
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"gopkg.in/yaml.v3"
+	"gotest.tools/assert"
+	"testing"
+	"time"
+)
+
+type TestAdaptIntervalInputs struct {
+	Name     string
+	MaxPoll  time.Duration
+	UsagePc  float32
+	MinFree  int32
+	FreeGb   uint64
+	RateGbPs float64
+	Expected time.Duration
+}
+
+func TestAdaptInterval(t *testing.T) {
+
+	gb := uint64(1 << 30)
+
+	// the threshold is 80% of a 100Gb filesystem, polling between 250ms and a minute
+	tests := []TestAdaptIntervalInputs{
+		{
+			Name:     "Not adapting",
+			UsagePc:  20,
+			Expected: 3 * time.Second,
+		},
+		{
+			Name:     "Low and stable polls slowly",
+			MaxPoll:  time.Minute,
+			UsagePc:  20,
+			Expected: time.Minute,
+		},
+		{
+			Name:     "Close to the threshold",
+			MaxPoll:  time.Minute,
+			UsagePc:  75,
+			Expected: 250*time.Millisecond + (time.Minute-250*time.Millisecond)/2,
+		},
+		{
+			Name:     "Over every threshold is measured against full",
+			MaxPoll:  time.Minute,
+			UsagePc:  85,
+			Expected: time.Minute,
+		},
+		{
+			// 30Gb to the threshold at 1Gb/s, polled 10 times
+			Name:     "Fast fill",
+			MaxPoll:  time.Minute,
+			UsagePc:  50,
+			RateGbPs: 1,
+			Expected: 3 * time.Second,
+		},
+		{
+			Name:     "Min free space is closer than the threshold",
+			MaxPoll:  time.Minute,
+			UsagePc:  50,
+			MinFree:  40,
+			RateGbPs: 1,
+			Expected: time.Second,
+		},
+		{
+			Name:     "Bounded by the min interval",
+			MaxPoll:  time.Minute,
+			UsagePc:  50,
+			RateGbPs: 100,
+			Expected: 250 * time.Millisecond,
+		},
+	}
+
+	for _, i := range tests {
+
+		volume := defaultVolume
+		volume.Fs = mockFS{MountPoint: aws.String("/mnt/mock")}
+		monitor := NewMonitor(&volume, 3*time.Second, 80)
+		monitor.MinPollInterval = 250 * time.Millisecond
+		monitor.MaxPollInterval = i.MaxPoll
+		monitor.MinFreeGb = i.MinFree
+		monitor.tier = -1
+
+		start := time.Now()
+		monitor.fillRate = NewFillRateEstimator(time.Minute)
+		monitor.fillRate.Add(start, 0)
+		monitor.fillRate.Add(start.Add(time.Second), uint64(i.RateGbPs*float64(gb)))
+
+		used := uint64(float64(i.UsagePc) / 100 * float64(100*gb))
+		monitor.adaptInterval(i.UsagePc, 100*gb, 100*gb-used)
+
+		assert.Equal(t, monitor.pollInterval(), i.Expected, i.Name)
+	}
+}
+
+func TestDurationUnmarshal(t *testing.T) {
+
+	for value, expected := range map[string]time.Duration{
+		"3":      3 * time.Second,
+		"0.5":    500 * time.Millisecond,
+		"\"5m\"": 5 * time.Minute,
+		"750ms":  750 * time.Millisecond,
+	} {
+		var d Duration
+		if err := yaml.Unmarshal([]byte(value), &d); err != nil {
+			t.Fatalf("Duration.UnmarshalYAML(%s) Returned an unexpected error: %s", value, err)
+		}
+		assert.Equal(t, time.Duration(d), expected, value)
+	}
+
+	var d Duration
+	if err := yaml.Unmarshal([]byte("soon"), &d); err == nil {
+		t.Errorf("Duration.UnmarshalYAML Expected an error for: soon")
+	}
+}
+
+func TestMonitorRunRejectsInterval(t *testing.T) {
+
+	for name, set := range map[string]func(m *testMonitor){
+		"Zero interval":         func(m *testMonitor) { m.PollInterval = 0 },
+		"Negative interval":     func(m *testMonitor) { m.PollInterval = -time.Second },
+		"Negative max interval": func(m *testMonitor) { m.MaxPollInterval = -time.Second },
+	} {
+		monitor := newTestMonitor(t, 100)
+		set(monitor)
+
+		// the ticker panics on an interval that is not positive, so Run refuses it before starting
+		if err := monitor.Run(context.Background()); err == nil {
+			t.Errorf("Run(%s) Expected an error", name)
+		}
+	}
+}
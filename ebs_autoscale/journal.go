@@ -0,0 +1,367 @@
+package ebs_autoscale
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	defaultStateDir = "/var/lib/ebs-autoscale"
+
+	operationInit   = "init"
+	operationGrow   = "grow"
+	operationResize = "resize"
+
+	// journalClockSkew allows for the provider's clock being behind ours when matching volumes to a journal entry
+	journalClockSkew = time.Minute
+)
+
+// errJournalLocked is returned while another process holds the journal lock, i.e. the monitor is mid-grow
+var errJournalLocked = errors.New("journal is locked by another process")
+
+// JournalStep is the last completed step of a journaled operation
+type JournalStep string
+
+const (
+	// StepStarted the operation has begun, a volume may have been created
+	StepStarted JournalStep = "started"
+	// StepCreated the volume has been created, it may have been attached
+	StepCreated JournalStep = "created"
+	// StepAttached the volume is attached and set to be deleted on termination
+	StepAttached JournalStep = "attached"
+	// StepResolved the device is available on the host, the filesystem may have been created or grown
+	StepResolved JournalStep = "resolved"
+	// StepModifying the volume of a resize may have been enlarged, the filesystem may have been resized
+	StepModifying JournalStep = "modifying"
+)
+
+// JournalEntry records the progress of an in-flight init, grow or resize operation
+type JournalEntry struct {
+	Operation   string      `json:"operation"`
+	Step        JournalStep `json:"step"`
+	SizeGb      int32       `json:"size-gb"`
+	StartTime   time.Time   `json:"start-time"`
+	VolumeId    string      `json:"volume-id,omitempty"`
+	Device      string      `json:"device,omitempty"`
+	LocalDevice string      `json:"local-device,omitempty"`
+}
+
+// Journal persists the in-flight operation of a filesystem, so an operation interrupted by the process dying can be
+// finished or rolled back by Volume.Replay. An exclusive lock is held from Begin to Complete, so no other process
+// replays, or begins, an operation in flight. The lock is released by the kernel should the process die. Methods on a
+// nil Journal do nothing.
+type Journal struct {
+	Path  string
+	entry *JournalEntry
+	lock  *os.File
+}
+
+// NewJournal returns the journal of the filesystem with the given autoscale id, kept under stateDir
+func NewJournal(stateDir string, autoscaleId string) (*Journal, error) {
+
+	if stateDir == "" {
+		stateDir = defaultStateDir
+	}
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return nil, err
+	}
+	return &Journal{Path: filepath.Join(stateDir, autoscaleId+".journal.json")}, nil
+}
+
+// Load returns the persisted entry, nil when no operation is in flight
+func (j *Journal) Load() (*JournalEntry, error) {
+
+	if j == nil {
+		return nil, nil
+	}
+	b, err := os.ReadFile(j.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entry JournalEntry
+	if err := json.Unmarshal(b, &entry); err != nil {
+		return nil, fmt.Errorf("Journal.Load: %s: %w", j.Path, err)
+	}
+	j.entry = &entry
+	return &entry, nil
+}
+
+// Lock takes the exclusive lock of the journal, unless already held. errJournalLocked is returned while another
+// process holds it.
+func (j *Journal) Lock() error {
+
+	if j == nil || j.lock != nil {
+		return nil
+	}
+	// the journal itself is replaced on every save, so the lock is taken on a file of its own
+	f, err := os.OpenFile(j.Path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		_ = f.Close()
+		if errors.Is(err, unix.EWOULDBLOCK) {
+			return fmt.Errorf("Journal.Lock: %s: %w", j.Path, errJournalLocked)
+		}
+		return fmt.Errorf("Journal.Lock: %s: %w", j.Path, err)
+	}
+	j.lock = f
+	return nil
+}
+
+// Unlock releases the lock of the journal, if held
+func (j *Journal) Unlock() {
+
+	if j == nil || j.lock == nil {
+		return
+	}
+	if err := j.lock.Close(); err != nil {
+		slog.Warn(fmt.Sprintf("Journal.Unlock: failed to release %s: %s", j.Path, err))
+	}
+	j.lock = nil
+}
+
+// Begin records the start of an operation, taking the lock of the journal. It must succeed before any volume is
+// created.
+func (j *Journal) Begin(operation string, sizeGb int32) error {
+
+	if j == nil {
+		return nil
+	}
+	if err := j.Lock(); err != nil {
+		return err
+	}
+	j.entry = &JournalEntry{
+		Operation: operation,
+		Step:      StepStarted,
+		SizeGb:    sizeGb,
+		StartTime: time.Now(),
+	}
+	return j.save()
+}
+
+// Step records the completion of a step of the in-flight operation. A failure to record is logged rather than
+// returned, so the operation itself is not abandoned part way.
+func (j *Journal) Step(step JournalStep, update func(e *JournalEntry)) {
+
+	if j == nil || j.entry == nil {
+		return
+	}
+	j.entry.Step = step
+	update(j.entry)
+	if err := j.save(); err != nil {
+		slog.Warn(fmt.Sprintf("Journal.Step: failed to record %s: %s", step, err))
+	}
+}
+
+// Complete removes the journal once the operation has finished or been rolled back, and releases its lock. The journal
+// of an operation another process holds the lock of is left alone.
+func (j *Journal) Complete() {
+
+	if j == nil {
+		return
+	}
+	if err := j.Lock(); err != nil {
+		slog.Warn(fmt.Sprintf("Journal.Complete: leaving %s: %s", j.Path, err))
+		return
+	}
+	defer j.Unlock()
+	j.entry = nil
+	if err := os.Remove(j.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Warn(fmt.Sprintf("Journal.Complete: failed to remove %s: %s", j.Path, err))
+	}
+}
+
+// save atomically replaces the persisted entry
+func (j *Journal) save() error {
+	b, err := json.MarshalIndent(j.entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := j.Path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, j.Path)
+}
+
+// Replay finishes or rolls back an operation left in flight by a previous process. Operations that stopped before the
+// device was available are rolled back, later ones are finished. If finishing fails the operation is rolled back
+// instead. A resize cannot be rolled back, once its volume may have been modified the filesystem resize is retried. The
+// journal is kept when the roll back also fails, so the next start can try again. Replay refuses, returning
+// errJournalLocked, while another process holds the lock of the journal, as its operation is not interrupted.
+func (v *Volume) Replay(ctx context.Context) error {
+
+	if err := v.Journal.Lock(); err != nil {
+		return fmt.Errorf("Replay: an operation is in progress: %w", err)
+	}
+	entry, err := v.Journal.Load()
+	if err != nil || entry == nil {
+		v.Journal.Unlock()
+		return err
+	}
+	slog.Warn(fmt.Sprintf("Replay: found interrupted %s at step %s, volume: %s", entry.Operation, entry.Step, entry.VolumeId))
+
+	switch entry.Step {
+	case StepStarted:
+		// a resize creates no volume
+		if entry.Operation != operationResize {
+			err = v.rollbackStarted(ctx, *entry)
+		}
+	case StepModifying:
+		err = v.rollForwardResize(ctx, *entry)
+	case StepCreated:
+		err = v.rollbackVolume(ctx, entry.VolumeId)
+	case StepAttached, StepResolved:
+		var localDevice string
+		localDevice, err = v.rollForward(ctx, *entry)
+		if err == nil {
+			break
+		}
+		// a device that joined the filesystem cannot be removed, i.e. when a grow failed balancing after the add
+		if devices, err2 := v.Fs.Devices(); err2 == nil && localDevice != "" && containsDevice(devices, localDevice) {
+			slog.Warn(fmt.Sprintf("Replay: %s is part of the filesystem, treating %s as finished: %s", localDevice, entry.Operation, err))
+			err = nil
+			break
+		}
+		slog.Warn(fmt.Sprintf("Replay: could not finish %s, rolling back: %s", entry.Operation, err))
+		if err2 := v.rollbackVolume(ctx, entry.VolumeId); err2 != nil {
+			err = errors.Join(err, err2)
+		} else {
+			err = nil
+		}
+	default:
+		err = fmt.Errorf("Replay: unknown journal step: %s", entry.Step)
+	}
+	if err != nil {
+		v.Journal.Unlock()
+		return err
+	}
+
+	v.Journal.Complete()
+	return nil
+}
+
+// rollbackStarted removes any volume created for the operation before its id was recorded. Such a volume carries the
+// autoscale id, is not attached, was created after the operation started and, unless persistent, by this host. Other
+// hosts using the same mount point share the autoscale id, their volumes may be between create and attach.
+func (v *Volume) rollbackStarted(ctx context.Context, entry JournalEntry) error {
+
+	taggedVolumes, err := v.Provider.ListVolumes(ctx, map[string]string{autoscaleIdTag: v.Id})
+	if err != nil {
+		return err
+	}
+	for _, mv := range taggedVolumes {
+		if mv.AttachedTo != "" || mv.CreateTime.Before(entry.StartTime.Add(-journalClockSkew)) {
+			continue
+		}
+		if source, _ := mv.Tag(sourceInstanceTag); !v.Persistent && source != v.Host.InstanceId {
+			continue
+		}
+		slog.Info(fmt.Sprintf("Replay: removing volume created by the interrupted %s: %s", entry.Operation, mv.VolumeId))
+		if err := v.Provider.DeleteVolume(ctx, mv.VolumeId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollbackVolume detaches the volume if required and deletes it
+func (v *Volume) rollbackVolume(ctx context.Context, volumeId string) error {
+
+	taggedVolumes, err := v.Provider.ListVolumes(ctx, map[string]string{autoscaleIdTag: v.Id})
+	if err != nil {
+		return err
+	}
+	for _, mv := range taggedVolumes {
+		if mv.VolumeId != volumeId {
+			continue
+		}
+		slog.Info(fmt.Sprintf("Replay: rolling back volume: %s", volumeId))
+		err := v.removeVolume(ctx, volumeId, mv.AttachedTo != "")
+		if err != nil {
+			return err
+		}
+		v.ManagedVolumes = removeVolumeId(v.ManagedVolumes, volumeId)
+	}
+	return nil
+}
+
+// rollForward waits for the attached device and creates or grows the filesystem across it, unless the filesystem
+// already spans it. The local device is returned once resolved.
+func (v *Volume) rollForward(ctx context.Context, entry JournalEntry) (string, error) {
+
+	managed, err := listManagedVolumes(ctx, v.Provider, v.Host, v.Id)
+	if err != nil {
+		return "", err
+	}
+	var mv *ManagedVolume
+	for i := range managed {
+		if managed[i].VolumeId == entry.VolumeId {
+			mv = &managed[i]
+		}
+	}
+	if mv == nil {
+		return "", fmt.Errorf("Replay: volume %s is no longer attached", entry.VolumeId)
+	}
+
+	localDevice, err := v.Provider.ResolveDevice(ctx, *mv)
+	if err != nil {
+		return "", err
+	}
+
+	devices, err := v.Fs.Devices()
+	if err == nil && containsDevice(devices, localDevice) {
+		return localDevice, nil
+	}
+	// the filesystem is not mounted until it has been created, or after a reboot, so for init an error is only taken to
+	// mean it still needs creating when the device carries no filesystem, which is never reformatted
+	if entry.Operation == operationInit {
+		found, err := v.Fs.HasFileSystem(localDevice)
+		if err != nil {
+			return localDevice, err
+		}
+		if found {
+			slog.Info(fmt.Sprintf("Replay: mounting the existing filesystem from: %s", localDevice))
+			return localDevice, v.Fs.MountFileSystem(localDevice)
+		}
+		slog.Info(fmt.Sprintf("Replay: creating the filesystem on: %s", localDevice))
+		return localDevice, v.Fs.CreateFileSystem(localDevice)
+	}
+	if err != nil {
+		return localDevice, err
+	}
+	slog.Info(fmt.Sprintf("Replay: growing the filesystem across: %s", localDevice))
+	return localDevice, v.Fs.GrowFileSystem(localDevice)
+}
+
+// containsDevice reports whether device, once links are resolved, is one of devices
+func containsDevice(devices []string, device string) bool {
+	resolved := resolveDevicePath(device)
+	for _, d := range devices {
+		if resolveDevicePath(d) == resolved {
+			return true
+		}
+	}
+	return false
+}
+
+func removeVolumeId(vols []ManagedVolume, volumeId string) []ManagedVolume {
+	remaining := make([]ManagedVolume, 0, len(vols))
+	for _, v := range vols {
+		if v.VolumeId != volumeId {
+			remaining = append(remaining, v)
+		}
+	}
+	return remaining
+}
@@ -0,0 +1,355 @@
+package ebs_autoscale
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/BobTheTerrible/ebs-autoscale/ebs_autoscale/awsfake"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"gotest.tools/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+// recordingFS is a mockFS that records the create, grow, mount, destroy and resize calls made to it
+type recordingFS struct {
+	mockFS
+	DevicesErr error
+	calls      *[]string
+}
+
+func (r recordingFS) CreateFileSystem(device string) error {
+	*r.calls = append(*r.calls, "CreateFileSystem")
+	return r.Err
+}
+
+func (r recordingFS) GrowFileSystem(device string) error {
+	*r.calls = append(*r.calls, "GrowFileSystem")
+	return r.Err
+}
+
+func (r recordingFS) MountFileSystem(device string) error {
+	*r.calls = append(*r.calls, "MountFileSystem")
+	return r.Err
+}
+
+func (r recordingFS) DestroyFileSystem(force bool) error {
+	*r.calls = append(*r.calls, fmt.Sprintf("DestroyFileSystem:%t", force))
+	return r.Err
+}
+
+func (r recordingFS) ResizeDevice(device string) error {
+	*r.calls = append(*r.calls, "ResizeDevice")
+	return r.Err
+}
+
+func (r recordingFS) Devices() ([]string, error) {
+	return r.DeviceList, r.DevicesErr
+}
+
+type TestReplayInputs struct {
+	Name string
+	// Entry the journal left by the interrupted process, nil for none
+	Entry *JournalEntry
+	// Setup prepares the fake and filesystem before the call
+	Setup           func(fake *awsfake.Ec2, volume *Volume, fs *recordingFS)
+	ExpectedFsCalls []string
+	// ExpectedVolumes the ids of the volumes remaining in ec2
+	ExpectedVolumes []string
+	Error           bool
+}
+
+func TestReplay(t *testing.T) {
+
+	volumeAvailableTimeout = time.Second
+	deviceAvailableTimeout = 200 * time.Millisecond
+
+	started := time.Now()
+	mockErr := fmt.Errorf("mock error")
+
+	// attachVolume adds a volume tagged for the filesystem attached at a device that exists
+	attachVolume := func(fake *awsfake.Ec2, volume *Volume, volumeId string) string {
+		device := volume.devicePrefix + "a"
+		putManagedVolume(fake, volumeId, volume.Id, volume.Host.InstanceId, device)
+		_ = os.WriteFile(device, []byte{}, 0600)
+		return device
+	}
+
+	tests := []TestReplayInputs{
+		{
+			Name:            "No journal",
+			Entry:           nil,
+			Setup:           func(fake *awsfake.Ec2, volume *Volume, fs *recordingFS) {},
+			ExpectedFsCalls: nil,
+			ExpectedVolumes: nil,
+			Error:           false,
+		},
+		{
+			Name:  "Interrupted before the volume id was recorded",
+			Entry: &JournalEntry{Operation: operationGrow, Step: StepStarted, StartTime: started},
+			Setup: func(fake *awsfake.Ec2, volume *Volume, fs *recordingFS) {
+				// only the unattached volume created after the operation started is removed
+				putTaggedVolume(fake, "vol-old", volume.Id, volume.Host.InstanceId, started.Add(-time.Hour), types.VolumeStateAvailable)
+				putTaggedVolume(fake, "vol-new", volume.Id, volume.Host.InstanceId, started.Add(time.Second), types.VolumeStateAvailable)
+			},
+			ExpectedFsCalls: nil,
+			ExpectedVolumes: []string{"vol-old"},
+			Error:           false,
+		},
+		{
+			Name:  "Another instance's volume being attached is kept",
+			Entry: &JournalEntry{Operation: operationGrow, Step: StepStarted, StartTime: started},
+			Setup: func(fake *awsfake.Ec2, volume *Volume, fs *recordingFS) {
+				// hosts with the same mount point share the autoscale id
+				putTaggedVolume(fake, "vol-neighbour", volume.Id, "i-neighbour", started.Add(time.Second), types.VolumeStateAvailable)
+			},
+			ExpectedFsCalls: nil,
+			ExpectedVolumes: []string{"vol-neighbour"},
+			Error:           false,
+		},
+		{
+			Name:  "Interrupted after create",
+			Entry: &JournalEntry{Operation: operationGrow, Step: StepCreated, StartTime: started, VolumeId: "vol-1"},
+			Setup: func(fake *awsfake.Ec2, volume *Volume, fs *recordingFS) {
+				attachVolume(fake, volume, "vol-1")
+			},
+			ExpectedFsCalls: nil,
+			ExpectedVolumes: nil,
+			Error:           false,
+		},
+		{
+			Name:  "Interrupted after attach is grown",
+			Entry: &JournalEntry{Operation: operationGrow, Step: StepAttached, StartTime: started, VolumeId: "vol-1"},
+			Setup: func(fake *awsfake.Ec2, volume *Volume, fs *recordingFS) {
+				attachVolume(fake, volume, "vol-1")
+			},
+			ExpectedFsCalls: []string{"GrowFileSystem"},
+			ExpectedVolumes: []string{"vol-1"},
+			Error:           false,
+		},
+		{
+			Name:  "Interrupted after the grow finished",
+			Entry: &JournalEntry{Operation: operationGrow, Step: StepResolved, StartTime: started, VolumeId: "vol-1"},
+			Setup: func(fake *awsfake.Ec2, volume *Volume, fs *recordingFS) {
+				fs.DeviceList = []string{attachVolume(fake, volume, "vol-1")}
+			},
+			ExpectedFsCalls: nil,
+			ExpectedVolumes: []string{"vol-1"},
+			Error:           false,
+		},
+		{
+			Name:  "Grow fails and is rolled back",
+			Entry: &JournalEntry{Operation: operationGrow, Step: StepResolved, StartTime: started, VolumeId: "vol-1"},
+			Setup: func(fake *awsfake.Ec2, volume *Volume, fs *recordingFS) {
+				attachVolume(fake, volume, "vol-1")
+				fs.Err = mockErr
+			},
+			ExpectedFsCalls: []string{"GrowFileSystem"},
+			ExpectedVolumes: nil,
+			Error:           false,
+		},
+		{
+			Name:  "Roll back fails keeping the journal",
+			Entry: &JournalEntry{Operation: operationGrow, Step: StepCreated, StartTime: started, VolumeId: "vol-1"},
+			Setup: func(fake *awsfake.Ec2, volume *Volume, fs *recordingFS) {
+				attachVolume(fake, volume, "vol-1")
+				fake.FailNext("DeleteVolume", mockErr)
+			},
+			ExpectedFsCalls: nil,
+			ExpectedVolumes: []string{"vol-1"},
+			Error:           true,
+		},
+		{
+			Name:  "Init creates the filesystem when not mounted",
+			Entry: &JournalEntry{Operation: operationInit, Step: StepResolved, StartTime: started, VolumeId: "vol-1"},
+			Setup: func(fake *awsfake.Ec2, volume *Volume, fs *recordingFS) {
+				attachVolume(fake, volume, "vol-1")
+				fs.DevicesErr = mockErr
+			},
+			ExpectedFsCalls: []string{"CreateFileSystem"},
+			ExpectedVolumes: []string{"vol-1"},
+			Error:           false,
+		},
+		{
+			Name:  "Init mounts rather than reformats an existing filesystem",
+			Entry: &JournalEntry{Operation: operationInit, Step: StepResolved, StartTime: started, VolumeId: "vol-1"},
+			Setup: func(fake *awsfake.Ec2, volume *Volume, fs *recordingFS) {
+				// i.e. rebooted after the filesystem was created but before the journal was completed
+				attachVolume(fake, volume, "vol-1")
+				fs.DevicesErr = mockErr
+				fs.HasFs = true
+			},
+			ExpectedFsCalls: []string{"MountFileSystem"},
+			ExpectedVolumes: []string{"vol-1"},
+			Error:           false,
+		},
+		{
+			Name:  "Resize started removes nothing",
+			Entry: &JournalEntry{Operation: operationResize, Step: StepStarted, StartTime: started},
+			Setup: func(fake *awsfake.Ec2, volume *Volume, fs *recordingFS) {
+				putTaggedVolume(fake, "vol-new", volume.Id, volume.Host.InstanceId, started.Add(time.Second), types.VolumeStateAvailable)
+			},
+			ExpectedFsCalls: nil,
+			ExpectedVolumes: []string{"vol-new"},
+			Error:           false,
+		},
+		{
+			Name:  "Interrupted resize is rolled forward",
+			Entry: &JournalEntry{Operation: operationResize, Step: StepModifying, StartTime: started, VolumeId: "vol-1"},
+			Setup: func(fake *awsfake.Ec2, volume *Volume, fs *recordingFS) {
+				attachVolume(fake, volume, "vol-1")
+			},
+			ExpectedFsCalls: []string{"ResizeDevice"},
+			ExpectedVolumes: []string{"vol-1"},
+			Error:           false,
+		},
+		{
+			Name:  "Failed resize keeps the journal",
+			Entry: &JournalEntry{Operation: operationResize, Step: StepModifying, StartTime: started, VolumeId: "vol-1"},
+			Setup: func(fake *awsfake.Ec2, volume *Volume, fs *recordingFS) {
+				attachVolume(fake, volume, "vol-1")
+				fs.Err = mockErr
+			},
+			ExpectedFsCalls: []string{"ResizeDevice"},
+			ExpectedVolumes: []string{"vol-1"},
+			Error:           true,
+		},
+	}
+
+	for _, i := range tests {
+
+		fake := awsfake.NewEc2()
+		volume := newFakeVolume(t, fake)
+		var calls []string
+		fs := recordingFS{mockFS: mockFS{MountPoint: aws.String("/mnt/mock")}, calls: &calls}
+		i.Setup(fake, &volume, &fs)
+		volume.Fs = fs
+
+		journal, err := NewJournal(t.TempDir(), volume.Id)
+		if err != nil {
+			t.Fatalf("NewJournal(%s) Returned an unexpected error: %s", i.Name, err)
+		}
+		volume.Journal = journal
+		if i.Entry != nil {
+			journal.entry = i.Entry
+			if err := journal.save(); err != nil {
+				t.Fatalf("Journal.save(%s) Returned an unexpected error: %s", i.Name, err)
+			}
+		}
+
+		err = volume.Replay(context.Background())
+
+		if (err == nil) == i.Error {
+			t.Errorf("Replay(%s) Returned an unexpected error: %s", i.Name, err)
+		}
+		assert.DeepEqual(t, calls, i.ExpectedFsCalls, cmpopts.EquateEmpty())
+		remaining := make([]string, 0)
+		for _, v := range fake.Volumes() {
+			remaining = append(remaining, *v.VolumeId)
+		}
+		assert.DeepEqual(t, remaining, i.ExpectedVolumes, cmpopts.EquateEmpty())
+
+		// The journal is only kept when the operation could be neither finished nor rolled back
+		_, statErr := os.Stat(journal.Path)
+		if i.Error != (statErr == nil) {
+			t.Errorf("Replay(%s) Expected journal kept: %t Got: %s", i.Name, i.Error, statErr)
+		}
+	}
+}
+
+func TestCreateAndAttachEbsVolumeJournal(t *testing.T) {
+
+	volumeAvailableTimeout = time.Second
+	deviceAvailableTimeout = 200 * time.Millisecond
+
+	fake := awsfake.NewEc2()
+	volume := newFakeVolume(t, fake)
+	journal, err := NewJournal(t.TempDir(), volume.Id)
+	if err != nil {
+		t.Fatalf("NewJournal Returned an unexpected error: %s", err)
+	}
+	volume.Journal = journal
+
+	// The journal records each step until the filesystem has been grown
+	device, err := volume.createAndAttachEbsVolume(context.Background(), operationGrow, 25)
+	if err != nil {
+		t.Fatalf("createAndAttachEbsVolume Returned an unexpected error: %s", err)
+	}
+	entry, err := journal.Load()
+	if err != nil {
+		t.Fatalf("Journal.Load Returned an unexpected error: %s", err)
+	}
+	assert.Equal(t, entry.Operation, operationGrow)
+	assert.Equal(t, entry.Step, StepResolved)
+	assert.Equal(t, entry.VolumeId, volume.ManagedVolumes[0].VolumeId)
+	assert.Equal(t, entry.LocalDevice, *device)
+
+	journal.Complete()
+
+	// A failed clean up leaves the journal for replay
+	fake.FailNext("AttachVolume", fmt.Errorf("mock error"))
+	fake.FailNext("DeleteVolume", fmt.Errorf("mock error"))
+	if _, err := volume.createAndAttachEbsVolume(context.Background(), operationGrow, 25); err == nil {
+		t.Fatalf("createAndAttachEbsVolume Expected an error")
+	}
+	entry, err = journal.Load()
+	if err != nil {
+		t.Fatalf("Journal.Load Returned an unexpected error: %s", err)
+	}
+	assert.Equal(t, entry.Step, StepCreated)
+
+	// A clean up that succeeds removes the journal
+	journal.Complete()
+	fake.FailNext("AttachVolume", fmt.Errorf("mock error"))
+	if _, err := volume.createAndAttachEbsVolume(context.Background(), operationGrow, 25); err == nil {
+		t.Fatalf("createAndAttachEbsVolume Expected an error")
+	}
+	entry, err = journal.Load()
+	if err != nil {
+		t.Fatalf("Journal.Load Returned an unexpected error: %s", err)
+	}
+	assert.Assert(t, entry == nil)
+}
+
+func TestJournalLock(t *testing.T) {
+
+	stateDir := t.TempDir()
+	owner, err := NewJournal(stateDir, "test")
+	if err != nil {
+		t.Fatalf("NewJournal Returned an unexpected error: %s", err)
+	}
+	other, err := NewJournal(stateDir, "test")
+	if err != nil {
+		t.Fatalf("NewJournal Returned an unexpected error: %s", err)
+	}
+
+	// The operation in flight holds the lock, another process can neither replay it nor begin its own
+	if err := owner.Begin(operationGrow, 25); err != nil {
+		t.Fatalf("Journal.Begin Returned an unexpected error: %s", err)
+	}
+	fake := awsfake.NewEc2()
+	volume := newFakeVolume(t, fake)
+	volume.Journal = other
+	if err := volume.Replay(context.Background()); !errors.Is(err, errJournalLocked) {
+		t.Errorf("Replay Expected: %s Got: %s", errJournalLocked, err)
+	}
+	if err := other.Begin(operationGrow, 25); !errors.Is(err, errJournalLocked) {
+		t.Errorf("Journal.Begin Expected: %s Got: %s", errJournalLocked, err)
+	}
+
+	// Completing by another process leaves the journal in place
+	other.Complete()
+	if _, err := os.Stat(owner.Path); err != nil {
+		t.Errorf("Journal.Complete Expected the journal kept Got: %s", err)
+	}
+
+	// Once completed the lock is released
+	owner.Complete()
+	if err := other.Lock(); err != nil {
+		t.Errorf("Journal.Lock Returned an unexpected error: %s", err)
+	}
+	other.Unlock()
+}
@@ -0,0 +1,133 @@
+package ebs_autoscale
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"log/slog"
+	"time"
+)
+
+const (
+	defaultImdsMaxAttempts = 5
+	defaultImdsTimeout     = 2 * time.Second
+	// imdsMaxRetryDelay caps the exponential backoff between metadata attempts
+	imdsMaxRetryDelay = 10 * time.Second
+)
+
+// imdsRetryDelay is the delay before the first metadata retry, doubling on each subsequent attempt
+var imdsRetryDelay = 500 * time.Millisecond
+
+// MetadataSource supplies the identity of the instance this process is running on
+type MetadataSource interface {
+	// InstanceId returns the id of the instance
+	InstanceId(ctx context.Context) (string, error)
+	// AvailabilityZone returns the availability zone of the instance
+	AvailabilityZone(ctx context.Context) (string, error)
+}
+
+// NewMetadataSource returns a static source when the config supplies the instance identity, otherwise the instance
+// metadata service is used.
+func NewMetadataSource(ctx context.Context, cfg HostCfg) (MetadataSource, error) {
+
+	if cfg.InstanceId != "" && cfg.AvailabilityZone != "" {
+		slog.Debug(fmt.Sprintf("NewMetadataSource: using static host: %s", cfg.InstanceId))
+		return StaticMetadata{
+			Id:   cfg.InstanceId,
+			Zone: cfg.AvailabilityZone,
+		}, nil
+	}
+	if cfg.InstanceId != "" || cfg.AvailabilityZone != "" {
+		return nil, fmt.Errorf("NewMetadataSource: a static host requires both instance-id and availability-zone")
+	}
+
+	return NewImdsMetadata(ctx, cfg.Imds)
+}
+
+// StaticMetadata is a MetadataSource with a fixed identity, allowing the tool to run off EC2
+type StaticMetadata struct {
+	Id   string
+	Zone string
+}
+
+func (s StaticMetadata) InstanceId(_ context.Context) (string, error) {
+	return s.Id, nil
+}
+
+func (s StaticMetadata) AvailabilityZone(_ context.Context) (string, error) {
+	return s.Zone, nil
+}
+
+// ImdsMetadata is a MetadataSource reading from the instance metadata service. Each lookup is retried with exponential
+// backoff, protecting against the metadata service not yet being available at boot.
+type ImdsMetadata struct {
+	client      *imds.Client
+	maxAttempts uint32
+	timeout     time.Duration
+}
+
+// NewImdsMetadata returns a metadata source for the instance metadata service
+func NewImdsMetadata(ctx context.Context, cfg ImdsCfg) (*ImdsMetadata, error) {
+
+	// We do not need to know the Region for imds calls
+	imdsCfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := imds.NewFromConfig(imdsCfg, func(o *imds.Options) {
+		// Retries are handled by get, so each attempt is bounded by the configured timeout
+		o.Retryer = aws.NopRetryer{}
+		if cfg.Endpoint != "" {
+			o.Endpoint = cfg.Endpoint
+		}
+	})
+
+	m := ImdsMetadata{
+		client:      client,
+		maxAttempts: cfg.MaxAttempts,
+		timeout:     time.Duration(cfg.TimeoutSecs) * time.Second,
+	}
+	if m.maxAttempts == 0 {
+		m.maxAttempts = defaultImdsMaxAttempts
+	}
+	if m.timeout == 0 {
+		m.timeout = defaultImdsTimeout
+	}
+	return &m, nil
+}
+
+func (m ImdsMetadata) InstanceId(ctx context.Context) (string, error) {
+	return m.get(ctx, "instance-id")
+}
+
+func (m ImdsMetadata) AvailabilityZone(ctx context.Context) (string, error) {
+	return m.get(ctx, "placement/availability-zone")
+}
+
+// get fetches the metadata path, retrying failed attempts until maxAttempts is reached
+func (m ImdsMetadata) get(ctx context.Context, path string) (string, error) {
+
+	delay := imdsRetryDelay
+	for attempt := uint32(1); ; attempt++ {
+
+		attemptCtx, cancel := context.WithTimeout(ctx, m.timeout)
+		value, err := GetAWSEc2Metadata(attemptCtx, path, *m.client)
+		cancel()
+		if err == nil {
+			return value, nil
+		}
+		if attempt >= m.maxAttempts {
+			return "", fmt.Errorf("ImdsMetadata.get: %s: giving up after %d attempts: %w", path, attempt, err)
+		}
+
+		slog.Warn(fmt.Sprintf("ImdsMetadata.get: %s: attempt %d failed, retrying in %s: %s", path, attempt, delay, err))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+		delay = min(delay*2, imdsMaxRetryDelay)
+	}
+}
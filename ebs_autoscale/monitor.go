@@ -2,43 +2,110 @@ package ebs_autoscale
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/aws/smithy-go"
 	"log/slog"
+	"strings"
 	"time"
 )
 
 type MonitorVolume struct {
-	Volume          Volume
-	PollIntervalSec int32
+	Volume       *Volume
+	PollInterval time.Duration
+	// MaxPollInterval when set, the poll interval adapts between MinPollInterval and MaxPollInterval, see adaptInterval
+	MinPollInterval time.Duration
+	MaxPollInterval time.Duration
+	adaptive        time.Duration
 	PercentageFull  float32
+	// Tiers when set replace PercentageFull, the highest tier the usage has reached triggers the grow
+	Tiers []Tier
+	tier  int
+	// Gc when set, orphaned volumes are garbage collected every Gc.Interval seconds
+	Gc     *GcCfg
+	lastGc time.Time
+	// MinFreeGb and MinHoursToFull when set also trigger a grow, once the free space or the time projected until the
+	// filesystem is full fall under them. TriggerMode combines them with the usage threshold, any or all.
+	MinFreeGb      int32
+	MinHoursToFull float32
+	TriggerMode    string
+	// MetadataThresholdPc when set also triggers a grow once the metadata usage reaches it, see filesystem.Usage
+	MetadataThresholdPc float32
+	// InodeThresholdPc when set also triggers a grow once the inode usage reaches it, or an alert when growing does not
+	// add inodes
+	InodeThresholdPc float32
+	inodeAlerted     bool
+	// Predictive when set, the filesystem is also grown when projected to fill before a grow could complete
+	Predictive *PredictiveCfg
+	// FillRateWindowSec the seconds of usage samples the fill rate is estimated from
+	FillRateWindowSec int32
+	fillRate          *FillRateEstimator
+	// CooldownSec the seconds after a grow before another is allowed, 0 for no cooldown
+	CooldownSec int32
+	// RearmPc when set, the tiers up to the one last grown at only trigger again once usage has fallen below RearmPc
+	RearmPc       float32
+	disarmedBelow int
+	// MaxGrowsPerHour and MaxGrowsPerDay limit the grows made in any hour or day, 0 for no limit
+	MaxGrowsPerHour int32
+	MaxGrowsPerDay  int32
+	grows           []time.Time
+	// MaxBackoffSec the longest delay between assessments while they fail with retryable errors
+	MaxBackoffSec int32
+	failures      int
+	// BreakerFailures the consecutive failed grows that stop grows for BreakerResetSec, 0 to never stop them
+	BreakerFailures int32
+	BreakerResetSec int32
+	growFailures    int32
+	breakerOpened   time.Time
+	// now returns the time usage samples and grows are taken at
+	now func() time.Time
 }
 
-func NewMonitor(volume Volume, pollIntervalSec int32, percentageFull float32) *MonitorVolume {
+func NewMonitor(volume *Volume, pollInterval time.Duration, percentageFull float32) *MonitorVolume {
 	return &MonitorVolume{
-		Volume:          volume,
-		PollIntervalSec: pollIntervalSec,
-		PercentageFull:  percentageFull,
+		Volume:         volume,
+		PollInterval:   pollInterval,
+		PercentageFull: percentageFull,
+		now:            time.Now,
 	}
 }
 
 // Run assesses the file system usage. If the usage exceeds the configured amount, an attempt is made to grow the
-// file system
-func (m MonitorVolume) Run(ctx context.Context) error {
+// file system. Managed volumes left outside the file system are repaired before monitoring starts, a failed repair is
+// retried on every tick until it succeeds. Failed assessments are logged and retried, Run only returns once the context
+// is done.
+func (m *MonitorVolume) Run(ctx context.Context) error {
 
 	slog.Info(fmt.Sprintf("Run: starting monitoring of: %s", m.Volume.Fs.GetMountPoint()))
 
-	ticker := time.NewTicker(time.Duration(m.PollIntervalSec) * time.Second)
+	if m.TriggerMode != "" && m.TriggerMode != TriggerAny && m.TriggerMode != TriggerAll {
+		return fmt.Errorf("Run: unknown trigger mode: %s", m.TriggerMode)
+	}
+	if m.PollInterval <= 0 {
+		return fmt.Errorf("Run: the poll interval must be greater than 0: %s", m.PollInterval)
+	}
+	if m.MinPollInterval < 0 || m.MaxPollInterval < 0 {
+		return fmt.Errorf("Run: the poll intervals must not be negative: %s, %s", m.MinPollInterval, m.MaxPollInterval)
+	}
+	if lowest := m.tiers()[0].ThresholdPc; m.RearmPc > 0 && m.RearmPc >= lowest {
+		return fmt.Errorf("Run: the rearm threshold (%f) must be below the usage threshold (%f)", m.RearmPc, lowest)
+	}
+
+	repaired := m.repair(ctx)
+
+	ticker := time.NewTicker(m.PollInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			err := m.assessAndGrow(ctx)
-			if err != nil {
-				return err
+			if !repaired {
+				repaired = m.repair(ctx)
 			}
+			err := m.assessAndGrow(ctx)
+			m.collectGarbage(ctx, m.now())
 			// TODO do I need to do this?? Best I can tell is that it restarts the ticker after work is done otherwise it simply keeps ticking in the background
-			ticker.Reset(time.Duration(m.PollIntervalSec) * time.Second)
+			ticker.Reset(m.nextPoll(err))
 		case <-ctx.Done():
 			slog.Info(fmt.Sprintf("Run: Aborting Monitoring of %s...\n", m.Volume.Fs.GetMountPoint()))
 			return nil
@@ -46,21 +113,140 @@ func (m MonitorVolume) Run(ctx context.Context) error {
 	}
 }
 
-// assessAndGrow checks the filesystem usage and grows the underlying volume if required
-func (m MonitorVolume) assessAndGrow(ctx context.Context) error {
+// repair repairs the managed volumes left outside the file system, returning whether it succeeded. A failure, i.e.
+// throttling or a volume that failed to adopt, is logged rather than stopping the monitor, as is another process
+// holding the journal lock.
+func (m *MonitorVolume) repair(ctx context.Context) bool {
 
-	usage, err := m.Volume.TotalUsagePercent()
+	_, err := m.Volume.Repair(ctx)
+	if errors.Is(err, errJournalLocked) {
+		slog.Info(fmt.Sprintf("repair: %s, repairing on the next tick", err))
+		return false
+	}
 	if err != nil {
-		return err
+		slog.Error(fmt.Sprintf("repair: repairing the managed volumes failed, retrying on the next tick: %s", err))
+		return false
 	}
+	return true
+}
 
-	if usage >= m.PercentageFull {
-		slog.Info(fmt.Sprintf("assessAndGrow: usage threshold (%f) exceeded (%f), growing: %s", m.PercentageFull, usage, m.Volume.Fs.GetMountPoint()))
+// assessAndGrow checks the filesystem usage and free space and grows the underlying volume when the triggers fire,
+// sized by the highest tier the usage has reached. The managed volumes are reconciled first, so the volume limits are
+// checked against the current state rather than that seen at startup.
+// When predictive, the volume is also grown if it is projected to fill before a grow could complete, and every grow
+// makes room for the writes projected over the horizon. Grows are suppressed during the cooldown, once the grow limits
+// are reached, and for the usage threshold until it is re-armed.
+func (m *MonitorVolume) assessAndGrow(ctx context.Context) error {
 
-		err = m.Volume.GrowVolume(ctx)
-		if err != nil {
+	// an api error is classified by its code, i.e. throttling is retried while a missing permission is fatal. Anything
+	// else failed listing the devices of the filesystem, which is retried like a failed Stat.
+	if _, err := m.Volume.Reconcile(ctx); err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) {
 			return err
 		}
+		return retryable(err)
+	}
+
+	total, used, free, err := m.Volume.Fs.Stat()
+	if err != nil {
+		return retryable(err)
+	}
+	usage := usagePercent(total, used)
+	detail, err := m.detailedUsage()
+	if err != nil {
+		return retryable(err)
+	}
+	m.alertInodes(detail)
+	m.sample(used)
+	projectedGb, fillsSoon := m.predict(free)
+
+	m.rearm(usage)
+	m.tier = m.matchTier(usage)
+	m.adaptInterval(usage, total, free)
+
+	var sizing SizingPolicy
+	if m.tier >= 0 {
+		sizing = m.tiers()[m.tier].Sizing
+	}
+
+	fired, triggered := m.evaluateTriggers(usage, free, detail)
+	var trigger string
+	switch {
+	case triggered:
+		trigger = strings.Join(fired, ", ")
+	case fillsSoon:
+		trigger = fmt.Sprintf("projected to fill before a grow completes (%f)", usage)
+	case m.tier >= 0 && m.tier < m.disarmedBelow:
+		slog.Info(fmt.Sprintf("assessAndGrow: usage threshold (%f) exceeded (%f), grow suppressed, usage has not fallen below the rearm threshold (%f) since the last grow: %s", m.tiers()[m.tier].ThresholdPc, usage, m.RearmPc, m.Volume.Fs.GetMountPoint()))
+		return nil
+	default:
+		return nil
+	}
+
+	now := m.now()
+	if reason := m.suppressed(now); reason != "" {
+		slog.Info(fmt.Sprintf("assessAndGrow: %s, grow suppressed, %s: %s", trigger, reason, m.Volume.Fs.GetMountPoint()))
+		return nil
 	}
+	slog.Info(fmt.Sprintf("assessAndGrow: %s, growing: %s", trigger, m.Volume.Fs.GetMountPoint()))
+
+	// a failed grow counts as well, so a grow that keeps failing is not retried on every tick
+	m.recordGrow(now)
+	if err := m.Volume.GrowVolumeWith(ctx, sizing, projectedGb); err != nil {
+		// only the failures of the provider or filesystem count towards the circuit breaker
+		if errors.Is(err, errNothingToAdd) {
+			slog.Warn(fmt.Sprintf("assessAndGrow: %s, not grown: %s", trigger, err))
+			return nil
+		}
+		m.growFailed(now, err)
+		return err
+	}
+	m.growSucceeded()
 	return nil
 }
+
+// sample adds the used bytes to the fill rate estimate
+func (m *MonitorVolume) sample(used uint64) {
+
+	if m.fillRate == nil {
+		window := m.FillRateWindowSec
+		if window <= 0 {
+			window = defaultPredictiveWindowSec
+		}
+		m.fillRate = NewFillRateEstimator(time.Duration(window) * time.Second)
+	}
+	m.fillRate.Add(m.now(), used)
+}
+
+// predict returns the Gb projected to be written over the horizon, and whether the free space is projected to run
+// out within the provisioning latency and safety margin. Nothing is predicted unless Predictive is set.
+func (m *MonitorVolume) predict(free uint64) (int32, bool) {
+
+	if m.Predictive == nil {
+		return 0, false
+	}
+
+	projectedGb := m.fillRate.ProjectedGb(time.Duration(m.Predictive.Horizon) * time.Second)
+	timeToFull, ok := m.fillRate.TimeToFull(free)
+	if !ok {
+		return projectedGb, false
+	}
+	leadTime := time.Duration(m.Predictive.ProvisioningLatency+m.Predictive.SafetyMargin) * time.Second
+	slog.Debug(fmt.Sprintf("predict: projected to fill in %s, a grow needs %s", timeToFull.Round(time.Second), leadTime))
+	return projectedGb, timeToFull < leadTime
+}
+
+// collectGarbage garbage collects orphaned volumes when enabled and the interval has passed since the last collection.
+// Failures are logged rather than returned, as they do not affect the monitored filesystem.
+func (m *MonitorVolume) collectGarbage(ctx context.Context, now time.Time) {
+
+	if m.Gc == nil || now.Sub(m.lastGc) < time.Duration(m.Gc.Interval)*time.Second {
+		return
+	}
+	m.lastGc = now
+
+	if _, err := m.Volume.GarbageCollect(ctx, *m.Gc, m.now); err != nil {
+		slog.Warn(fmt.Sprintf("collectGarbage: %s", err))
+	}
+}
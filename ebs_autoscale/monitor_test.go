@@ -0,0 +1,74 @@
+package ebs_autoscale
+
+import (
+	"context"
+	"github.com/BobTheTerrible/ebs-autoscale/ebs_autoscale/awsfake"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"testing"
+	"time"
+)
+
+// testMonitor a monitor of a volume on the fake ec2, with a mockFS of total bytes reporting the bytes set by setUsed,
+// and a clock moved by at
+type testMonitor struct {
+	*MonitorVolume
+	fake  *awsfake.Ec2
+	fs    mockFS
+	total uint64
+	used  uint64
+	free  uint64
+	start time.Time
+	clock time.Time
+}
+
+// newTestMonitor returns a testMonitor of an empty filesystem of total bytes, polling every second and growing at 50%
+func newTestMonitor(t *testing.T, total uint64) *testMonitor {
+
+	volumeAvailableTimeout = time.Second
+	deviceAvailableTimeout = 200 * time.Millisecond
+
+	m := &testMonitor{fake: awsfake.NewEc2(), total: total, free: total, start: time.Now()}
+	m.clock = m.start
+	m.fs = mockFS{
+		Size:       &m.total,
+		Used:       &m.used,
+		Free:       &m.free,
+		MountPoint: aws.String("/mnt/mock"),
+		DeviceList: []string{},
+	}
+
+	volume := newFakeVolume(t, m.fake)
+	volume.MaxLogicalSizeGb = 1000
+	volume.MaxCreatedVolumes = 10
+	volume.Fs = m.fs
+	m.MonitorVolume = NewMonitor(&volume, time.Second, 50)
+	m.now = func() time.Time { return m.clock }
+	return m
+}
+
+// setUsed sets the used bytes the filesystem reports, the rest of it free
+func (m *testMonitor) setUsed(used uint64) {
+	m.used, m.free = used, m.total-used
+}
+
+// at moves the clock to d after the start
+func (m *testMonitor) at(d time.Duration) {
+	m.clock = m.start.Add(d)
+}
+
+// tick assesses the usage once, failing the test on an error
+func (m *testMonitor) tick(t *testing.T, name string) {
+	if err := m.assessAndGrow(context.Background()); err != nil {
+		t.Fatalf("assessAndGrow(%s) Returned an unexpected error: %s", name, err)
+	}
+}
+
+// sizes the sizes of the volumes created
+func (m *testMonitor) sizes() []int32 {
+
+	sizes := []int32{}
+	for _, v := range m.fake.Volumes() {
+		sizes = append(sizes, *v.Size)
+	}
+	return sizes
+}
@@ -0,0 +1,66 @@
+package ebs_autoscale
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// attachPersistentSet attaches the detached members of a persistent filesystem's volume set to the host and waits for
+// their devices, so the filesystem can be mounted whole. The set must be entirely detached or attached to this host, in
+// this host's availability zone. A set still attached to another host, i.e. one being replaced, is an error so init can
+// be retried once it has been released.
+func (v *Volume) attachPersistentSet(ctx context.Context) error {
+
+	taggedVolumes, err := v.Provider.ListVolumes(ctx, map[string]string{autoscaleIdTag: v.Id})
+	if err != nil {
+		return err
+	}
+
+	detached := make([]ManagedVolume, 0, len(taggedVolumes))
+	for _, mv := range taggedVolumes {
+		switch {
+		case mv.AttachedTo == v.Host.InstanceId:
+			continue
+		case mv.AttachedTo != "":
+			return fmt.Errorf("attachPersistentSet: volume %s of %s is attached to %s", mv.VolumeId, v.FilesystemName, mv.AttachedTo)
+		case mv.AvailabilityZone != "" && mv.AvailabilityZone != v.Host.AvailabilityZone:
+			return fmt.Errorf("attachPersistentSet: volume %s of %s is in %s, not %s", mv.VolumeId, v.FilesystemName, mv.AvailabilityZone, v.Host.AvailabilityZone)
+		}
+		detached = append(detached, mv)
+	}
+	if len(detached) == 0 {
+		return nil
+	}
+
+	c, totalVolumes, err := v.instanceHasCapacity(ctx)
+	if err != nil {
+		return err
+	}
+	if !c || int32(totalVolumes+len(detached)) > v.MaxAttachedVolumes {
+		return fmt.Errorf("attachPersistentSet: MaxAttachedVolumes exceeded: max:%d observed:%d required:%d", v.MaxAttachedVolumes, totalVolumes, len(detached))
+	}
+
+	slog.Info(fmt.Sprintf("attachPersistentSet: attaching %d volume(s) of %s", len(detached), v.FilesystemName))
+	for _, mv := range detached {
+
+		device, err := v.getNextLogicalDevice()
+		if err != nil {
+			return err
+		}
+		err = v.Provider.AttachVolume(ctx, mv.VolumeId, *device, false)
+		if err != nil {
+			return err
+		}
+		mv.AttachedTo = v.Host.InstanceId
+		mv.Device = *device
+		mv.DeleteOnTermination = false
+
+		// Wait for the device, so the next one is not handed the same name
+		if _, err := v.Provider.ResolveDevice(ctx, mv); err != nil {
+			return err
+		}
+		v.ManagedVolumes = append(v.ManagedVolumes, mv)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package ebs_autoscale
+
+import (
+	"context"
+	"github.com/BobTheTerrible/ebs-autoscale/ebs_autoscale/awsfake"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"gotest.tools/assert"
+	"testing"
+	"time"
+)
+
+// putPersistentVolume adds a detached volume of a persistent filesystem in the given availability zone
+func putPersistentVolume(fake *awsfake.Ec2, volumeId string, volume *Volume, availabilityZone string) {
+	fake.PutVolume(types.Volume{
+		VolumeId:         aws.String(volumeId),
+		Size:             aws.Int32(50),
+		AvailabilityZone: aws.String(availabilityZone),
+		State:            types.VolumeStateAvailable,
+		Tags: []types.Tag{
+			{Key: aws.String(autoscaleIdTag), Value: aws.String(volume.Id)},
+			{Key: aws.String(filesystemNameTag), Value: aws.String(volume.FilesystemName)},
+		},
+	})
+}
+
+type TestCreateVolumePersistentInputs struct {
+	Name string
+	// Setup prepares the fake before the call
+	Setup           func(fake *awsfake.Ec2, volume *Volume)
+	ExpectedFsCalls []string
+	// ExpectedAttached the ids of the volumes attached to the host afterwards
+	ExpectedAttached []string
+	Error            bool
+}
+
+func TestCreateVolumePersistent(t *testing.T) {
+
+	volumeAvailableTimeout = time.Second
+	deviceAvailableTimeout = 200 * time.Millisecond
+
+	tests := []TestCreateVolumePersistentInputs{
+		{
+			Name:             "No volume set creates the filesystem",
+			Setup:            func(fake *awsfake.Ec2, volume *Volume) {},
+			ExpectedFsCalls:  []string{"CreateFileSystem"},
+			ExpectedAttached: []string{"vol-00000000000000001"},
+			Error:            false,
+		},
+		{
+			Name: "Detached volume set is attached and mounted",
+			Setup: func(fake *awsfake.Ec2, volume *Volume) {
+				putPersistentVolume(fake, "vol-1", volume, volume.Host.AvailabilityZone)
+				putPersistentVolume(fake, "vol-2", volume, volume.Host.AvailabilityZone)
+			},
+			ExpectedFsCalls:  []string{"MountFileSystem"},
+			ExpectedAttached: []string{"vol-1", "vol-2"},
+			Error:            false,
+		},
+		{
+			Name: "Volume set still attached elsewhere",
+			Setup: func(fake *awsfake.Ec2, volume *Volume) {
+				putPersistentVolume(fake, "vol-1", volume, volume.Host.AvailabilityZone)
+				putManagedVolume(fake, "vol-2", volume.Id, "i-old", "/dev/xvdbb")
+			},
+			ExpectedFsCalls:  nil,
+			ExpectedAttached: nil,
+			Error:            true,
+		},
+		{
+			Name: "Volume set in another availability zone",
+			Setup: func(fake *awsfake.Ec2, volume *Volume) {
+				putPersistentVolume(fake, "vol-1", volume, "ap-southeast-2b")
+			},
+			ExpectedFsCalls:  nil,
+			ExpectedAttached: nil,
+			Error:            true,
+		},
+	}
+
+	for _, i := range tests {
+
+		fake := awsfake.NewEc2()
+		volume := newFakeVolume(t, fake)
+		volume.Persistent = true
+		volume.FilesystemName = "batch-scratch"
+		volume.Id = Md5String(volume.FilesystemName)
+		var calls []string
+		volume.Fs = recordingFS{mockFS: mockFS{MountPoint: aws.String("/mnt/mock"), HasFs: true}, calls: &calls}
+		i.Setup(fake, &volume)
+
+		err := volume.CreateVolume(context.Background(), false)
+
+		if (err == nil) == i.Error {
+			t.Errorf("CreateVolume(%s) Returned an unexpected error: %s", i.Name, err)
+		}
+		assert.DeepEqual(t, calls, i.ExpectedFsCalls, cmpopts.EquateEmpty())
+		attached, err := listManagedVolumes(context.Background(), volume.Provider, volume.Host, volume.Id)
+		if err != nil {
+			t.Fatalf("listManagedVolumes(%s) Returned an unexpected error: %s", i.Name, err)
+		}
+		assert.DeepEqual(t, volumeIds(attached), i.ExpectedAttached, cmpopts.EquateEmpty())
+
+		// Persistent volumes are never set to be deleted on termination
+		assert.Assert(t, !containsCall(fake.Calls(), "ModifyInstanceAttribute"), i.Name)
+		for _, mv := range attached {
+			assert.Assert(t, !mv.DeleteOnTermination, i.Name)
+			_, tagged := mv.Tag(sourceInstanceTag)
+			assert.Assert(t, !tagged, i.Name)
+		}
+	}
+}
+
+func containsCall(calls []string, operation string) bool {
+	for _, c := range calls {
+		if c == operation {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package ebs_autoscale
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	defaultPredictiveWindowSec    = 60
+	defaultProvisioningLatencySec = 70
+	defaultSafetyMarginSec        = 30
+	defaultPredictiveHorizonSec   = 600
+)
+
+// usageSample the used bytes of the filesystem at a point in time
+type usageSample struct {
+	At   time.Time
+	Used uint64
+}
+
+// FillRateEstimator estimates the rate the filesystem is being written from the usage samples within Window. The rate
+// is the least squares slope of used bytes over time, so a single burst or delete does not dominate it.
+type FillRateEstimator struct {
+	Window  time.Duration
+	samples []usageSample
+}
+
+// NewFillRateEstimator returns an estimator keeping the samples of the given window
+func NewFillRateEstimator(window time.Duration) *FillRateEstimator {
+	return &FillRateEstimator{Window: window}
+}
+
+// Add records a sample, dropping those that have fallen out of the window
+func (f *FillRateEstimator) Add(at time.Time, used uint64) {
+
+	f.samples = append(f.samples, usageSample{At: at, Used: used})
+	keep := 0
+	for keep < len(f.samples) && at.Sub(f.samples[keep].At) > f.Window {
+		keep++
+	}
+	f.samples = f.samples[keep:]
+}
+
+// BytesPerSecond returns the estimated fill rate, false until at least two samples at different times are held
+func (f *FillRateEstimator) BytesPerSecond() (float64, bool) {
+
+	if len(f.samples) < 2 {
+		return 0, false
+	}
+
+	// times are taken relative to the first sample to keep the sums small
+	origin := f.samples[0].At
+	n := float64(len(f.samples))
+	var sumX, sumY, sumXY, sumXX float64
+	for _, s := range f.samples {
+		x := s.At.Sub(origin).Seconds()
+		y := float64(s.Used)
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0, false
+	}
+	return (n*sumXY - sumX*sumY) / denominator, true
+}
+
+// TimeToFull projects how long the free bytes last at the estimated fill rate. false is returned when there is no
+// estimate or the filesystem is not filling.
+func (f *FillRateEstimator) TimeToFull(free uint64) (time.Duration, bool) {
+
+	rate, ok := f.BytesPerSecond()
+	if !ok || rate <= 0 {
+		return 0, false
+	}
+	seconds := float64(free) / rate
+	if seconds > math.MaxInt64/float64(time.Second) {
+		return time.Duration(math.MaxInt64), true
+	}
+	return time.Duration(seconds * float64(time.Second)), true
+}
+
+// ProjectedGb returns the Gb written over the horizon at the estimated fill rate, rounded up. 0 when there is no
+// estimate or the filesystem is not filling.
+func (f *FillRateEstimator) ProjectedGb(horizon time.Duration) int32 {
+
+	rate, ok := f.BytesPerSecond()
+	if !ok || rate <= 0 {
+		return 0
+	}
+	return int32(math.Min(math.Ceil(rate*horizon.Seconds()/(1<<30)), math.MaxInt32))
+}
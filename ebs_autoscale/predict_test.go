@@ -0,0 +1,121 @@
+package ebs_autoscale
+
+import (
+	"gotest.tools/assert"
+	"testing"
+	"time"
+)
+
+type TestFillRateEstimatorInputs struct {
+	Name string
+	// Used the used bytes sampled every 10 seconds
+	Used                []uint64
+	Window              time.Duration
+	ExpectedRate        float64
+	ExpectedTimeToFull  time.Duration
+	ExpectedProjectedGb int32
+	// Estimated whether a fill rate is expected
+	Estimated bool
+}
+
+func TestFillRateEstimator(t *testing.T) {
+
+	gb := uint64(1 << 30)
+	start := time.Now()
+
+	tests := []TestFillRateEstimatorInputs{
+		{
+			Name:      "Single sample",
+			Used:      []uint64{10 * gb},
+			Window:    time.Minute,
+			Estimated: false,
+		},
+		{
+			Name:                "Steady fill",
+			Used:                []uint64{10 * gb, 11 * gb, 12 * gb},
+			Window:              time.Minute,
+			ExpectedRate:        float64(gb) / 10,
+			ExpectedTimeToFull:  100 * time.Second,
+			ExpectedProjectedGb: 60,
+			Estimated:           true,
+		},
+		{
+			Name:                "Samples outside the window are dropped",
+			Used:                []uint64{0, 50 * gb, 51 * gb, 52 * gb},
+			Window:              20 * time.Second,
+			ExpectedRate:        float64(gb) / 10,
+			ExpectedTimeToFull:  100 * time.Second,
+			ExpectedProjectedGb: 60,
+			Estimated:           true,
+		},
+		{
+			Name:         "Shrinking",
+			Used:         []uint64{12 * gb, 11 * gb, 10 * gb},
+			Window:       time.Minute,
+			ExpectedRate: -float64(gb) / 10,
+			Estimated:    true,
+		},
+	}
+
+	for _, i := range tests {
+
+		estimator := NewFillRateEstimator(i.Window)
+		for n, used := range i.Used {
+			estimator.Add(start.Add(time.Duration(n)*10*time.Second), used)
+		}
+
+		rate, ok := estimator.BytesPerSecond()
+		assert.Equal(t, ok, i.Estimated, i.Name)
+		assert.Equal(t, rate, i.ExpectedRate, i.Name)
+
+		// the filesystem has 10Gb free and is projected over 10 minutes
+		timeToFull, _ := estimator.TimeToFull(10 * gb)
+		assert.Equal(t, timeToFull, i.ExpectedTimeToFull, i.Name)
+		assert.Equal(t, estimator.ProjectedGb(10*time.Minute), i.ExpectedProjectedGb, i.Name)
+	}
+}
+
+type TestMonitorPredictiveInputs struct {
+	Name string
+	// FillGb the Gb written between the two ticks, 10 seconds apart
+	FillGb uint64
+	// ExpectedSizes the sizes of the volumes created
+	ExpectedSizes []int32
+}
+
+func TestMonitorPredictive(t *testing.T) {
+
+	gb := uint64(1 << 30)
+
+	tests := []TestMonitorPredictiveInputs{
+		{
+			Name:          "Slow fill does not grow",
+			FillGb:        1,
+			ExpectedSizes: []int32{},
+		},
+		{
+			// 50Gb free at 1Gb/s fills within the 100 second lead time, 100 seconds of writes need 100Gb
+			Name:          "Fast fill grows by the projected writes",
+			FillGb:        10,
+			ExpectedSizes: []int32{100},
+		},
+	}
+
+	for _, i := range tests {
+
+		monitor := newTestMonitor(t, 100*gb)
+		// the max size keeps the sizing policy's own grow under the projected writes
+		monitor.Volume.MaxLogicalSizeGb = 200
+		monitor.setUsed(40 * gb)
+		monitor.Predictive = &PredictiveCfg{Enabled: true, Window: 60, ProvisioningLatency: 70, SafetyMargin: 30, Horizon: 100}
+
+		// The first tick has no fill rate to predict from
+		monitor.tick(t, i.Name)
+		assert.Equal(t, len(monitor.fake.Volumes()), 0, i.Name)
+
+		monitor.at(10 * time.Second)
+		monitor.setUsed((40 + i.FillGb) * gb)
+		monitor.tick(t, i.Name)
+		assert.DeepEqual(t, monitor.sizes(), i.ExpectedSizes)
+	}
+}
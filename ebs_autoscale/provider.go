@@ -0,0 +1,118 @@
+package ebs_autoscale
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// BlockProvider creates the block devices a filesystem is spread across and attaches them to the host. Volumes are
+// described by provider-neutral ManagedVolume records.
+type BlockProvider interface {
+	// CreateVolume creates a volume and waits until it is available to attach
+	CreateVolume(ctx context.Context, spec VolumeSpec) (*ManagedVolume, error)
+	// AttachVolume attaches the volume to the host under the given device name. When deleteOnTermination is set the
+	// volume is removed along with the host, where the provider supports it. On error the volume is left detached.
+	AttachVolume(ctx context.Context, volumeId string, device string, deleteOnTermination bool) error
+	// DetachVolume detaches the volume from the host and waits until it is available
+	DetachVolume(ctx context.Context, volumeId string) error
+	// DeleteVolume deletes an available volume
+	DeleteVolume(ctx context.Context, volumeId string) error
+	// ListVolumes returns every volume carrying all the given tags, attached or not. An empty tag value matches any
+	// value of the key.
+	ListVolumes(ctx context.Context, tags map[string]string) ([]ManagedVolume, error)
+	// AttachedVolumeCount returns the number of volumes of any origin attached to the host
+	AttachedVolumeCount(ctx context.Context) (int, error)
+	// TagVolume adds or replaces tags on the volume
+	TagVolume(ctx context.Context, volumeId string, tags []Tag) error
+	// ResolveDevice waits for an attached volume to appear on the host and returns its local device path
+	ResolveDevice(ctx context.Context, volume ManagedVolume) (string, error)
+}
+
+// InstanceChecker is implemented by providers able to tell whether a host, identified as in the source-instance tag,
+// still exists
+type InstanceChecker interface {
+	// InstanceExists reports whether the host exists and has not been terminated
+	InstanceExists(ctx context.Context, instanceId string) (bool, error)
+}
+
+// VolumeResizer is implemented by providers able to enlarge an attached volume in place
+type VolumeResizer interface {
+	// ResizeVolume enlarges the volume to sizeGb and waits until the host sees the new size
+	ResizeVolume(ctx context.Context, volumeId string, sizeGb int32) error
+	// LastModified returns when the most recent modification of the volume started, the zero time if it never was
+	LastModified(ctx context.Context, volumeId string) (time.Time, error)
+	// MaxVolumeSizeGb returns the largest size a volume of the given type can be resized to, 0 when unlimited
+	MaxVolumeSizeGb(volumeType string) int32
+}
+
+// Tag is a key value pair attached to a volume
+type Tag struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// VolumeSpec describes a volume to be created
+type VolumeSpec struct {
+	SizeGb           int32
+	Type             string
+	Iops             *int32
+	Throughput       *int32
+	AvailabilityZone string
+	Tags             []Tag
+}
+
+// ManagedVolume is the provider-neutral record of a volume
+type ManagedVolume struct {
+	VolumeId         string    `json:"volume-id"`
+	SizeGb           int32     `json:"size-gb"`
+	Type             string    `json:"type"`
+	Iops             *int32    `json:"iops,omitempty"`
+	Throughput       *int32    `json:"throughput,omitempty"`
+	AvailabilityZone string    `json:"availability-zone"`
+	State            string    `json:"state"`
+	CreateTime       time.Time `json:"create-time"`
+	Tags             []Tag     `json:"tags"`
+	// AttachedTo is the id of the host the volume is attached to, empty when detached
+	AttachedTo          string `json:"attached-to,omitempty"`
+	Device              string `json:"device,omitempty"`
+	AttachmentState     string `json:"attachment-state,omitempty"`
+	DeleteOnTermination bool   `json:"delete-on-termination"`
+}
+
+// Tag returns the value of the given tag and whether it was found
+func (m ManagedVolume) Tag(key string) (string, bool) {
+	for _, t := range m.Tags {
+		if t.Key == key {
+			return t.Value, true
+		}
+	}
+	return "", false
+}
+
+// hasTags reports whether the volume carries all the given tags, an empty value matches any value of the key
+func (m ManagedVolume) hasTags(tags map[string]string) bool {
+	for k, v := range tags {
+		if got, ok := m.Tag(k); !ok || (v != "" && got != v) {
+			return false
+		}
+	}
+	return true
+}
+
+type providerConstructor func(ctx context.Context, host Ec2Host, options map[string]interface{}, awsCfg AwsCfg) (BlockProvider, error)
+
+var providers = map[string]providerConstructor{}
+
+// RegisterProvider allows adding a new block provider type to the registry
+func RegisterProvider(name string, constructor providerConstructor) {
+	providers[name] = constructor
+}
+
+// GetProvider returns the configured block provider
+func GetProvider(ctx context.Context, providerType string, host Ec2Host, options map[string]interface{}, awsCfg AwsCfg) (BlockProvider, error) {
+	if constructor, exists := providers[providerType]; exists {
+		return constructor(ctx, host, options, awsCfg)
+	}
+	return nil, fmt.Errorf("unsupported provider type: %s", providerType)
+}
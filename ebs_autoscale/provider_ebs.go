@@ -0,0 +1,373 @@
+package ebs_autoscale
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+	"time"
+)
+
+var (
+	volumeTypes map[string]types.VolumeType
+	// volumeSizeCapsGb the largest size of each volume type
+	volumeSizeCapsGb map[string]int32
+)
+
+func init() {
+	volumeTypes = map[string]types.VolumeType{
+		"io1": types.VolumeTypeIo1,
+		"io2": types.VolumeTypeIo2,
+		"gp3": types.VolumeTypeGp3,
+	}
+	volumeSizeCapsGb = map[string]int32{
+		"io1": 16384,
+		"io2": 65536,
+		"gp3": 16384,
+	}
+
+	RegisterProvider("ebs", func(ctx context.Context, host Ec2Host, options map[string]interface{}, awsCfg AwsCfg) (BlockProvider, error) {
+
+		// Get the region from the Host instance. Use this for subsequent aws calls
+		awsConfig, err := config.LoadDefaultConfig(ctx, config.WithDefaultRegion(host.Region))
+		if err != nil {
+			return nil, err
+		}
+		return NewEbsProvider(ec2.NewFromConfig(awsConfig, ec2Endpoint(awsCfg)), host), nil
+	})
+}
+
+// EbsProvider implements BlockProvider with ebs volumes attached to the host instance
+type EbsProvider struct {
+	Host   Ec2Host
+	client Ec2Api
+}
+
+// NewEbsProvider returns an EbsProvider using the given Ec2Api
+func NewEbsProvider(client Ec2Api, host Ec2Host) *EbsProvider {
+	return &EbsProvider{
+		Host:   host,
+		client: client,
+	}
+}
+
+// CreateVolume creates an ebs volume and waits until it is available
+func (e EbsProvider) CreateVolume(ctx context.Context, spec VolumeSpec) (*ManagedVolume, error) {
+
+	volumeType, ok := volumeTypes[spec.Type]
+	if !ok {
+		return nil, fmt.Errorf("EbsProvider.CreateVolume: unsupported ebs volume type: %s", spec.Type)
+	}
+
+	vol, err := e.client.CreateVolume(ctx, &ec2.CreateVolumeInput{
+		AvailabilityZone: aws.String(spec.AvailabilityZone),
+		VolumeType:       volumeType,
+		Size:             aws.Int32(spec.SizeGb),
+		Iops:             spec.Iops,
+		Throughput:       spec.Throughput,
+		Encrypted:        nil,
+		TagSpecifications: []types.TagSpecification{
+			{
+				ResourceType: types.ResourceTypeVolume,
+				Tags:         toEc2Tags(spec.Tags),
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// wait till volume is available....
+	volWaiter := ec2.NewVolumeAvailableWaiter(e.client)
+	out, err := volWaiter.WaitForOutput(ctx, &ec2.DescribeVolumesInput{
+		VolumeIds: []string{*vol.VolumeId},
+	}, volumeAvailableTimeout)
+	if err != nil {
+		// return the id so the caller can clean up the volume
+		return &ManagedVolume{VolumeId: *vol.VolumeId}, err
+	}
+
+	mv := fromEc2Volume(out.Volumes[0])
+	return &mv, nil
+}
+
+// AttachVolume attaches the volume to the host instance. When requested, the volume is then set to be deleted on
+// termination of the instance, if that fails the volume is detached again.
+func (e EbsProvider) AttachVolume(ctx context.Context, volumeId string, device string, deleteOnTermination bool) error {
+
+	_, err := e.client.AttachVolume(ctx, &ec2.AttachVolumeInput{
+		Device:     aws.String(device),
+		InstanceId: aws.String(e.Host.InstanceId),
+		VolumeId:   aws.String(volumeId),
+	})
+	if err != nil {
+		return err
+	}
+
+	if !deleteOnTermination {
+		return nil
+	}
+
+	// Set the volume to be deleted on termination
+	_, err = e.client.ModifyInstanceAttribute(ctx, &ec2.ModifyInstanceAttributeInput{
+		InstanceId: aws.String(e.Host.InstanceId),
+		BlockDeviceMappings: []types.InstanceBlockDeviceMappingSpecification{
+			{
+				DeviceName: aws.String(device),
+				Ebs: &types.EbsInstanceBlockDeviceSpecification{
+					DeleteOnTermination: aws.Bool(true),
+					VolumeId:            aws.String(volumeId),
+				},
+			},
+		},
+	})
+	if err != nil {
+		err2 := e.DetachVolume(ctx, volumeId)
+		return errors.Join(err, err2)
+	}
+	return nil
+}
+
+// DetachVolume detaches the volume and waits until it is available
+func (e EbsProvider) DetachVolume(ctx context.Context, volumeId string) error {
+
+	_, err := e.client.DetachVolume(ctx, &ec2.DetachVolumeInput{
+		VolumeId: aws.String(volumeId),
+	})
+	if err != nil {
+		return err
+	}
+
+	return e.waitAvailable(ctx, volumeId)
+}
+
+// DeleteVolume deletes an available volume
+func (e EbsProvider) DeleteVolume(ctx context.Context, volumeId string) error {
+
+	_, err := e.client.DeleteVolume(ctx, &ec2.DeleteVolumeInput{
+		VolumeId: aws.String(volumeId),
+	})
+	return err
+}
+
+// ListVolumes returns the ebs volumes carrying all the given tags
+func (e EbsProvider) ListVolumes(ctx context.Context, tags map[string]string) ([]ManagedVolume, error) {
+
+	filters := make([]types.Filter, 0, len(tags))
+	for k, v := range tags {
+		if v == "" {
+			filters = append(filters, types.Filter{
+				Name:   aws.String("tag-key"),
+				Values: []string{k},
+			})
+			continue
+		}
+		filters = append(filters, types.Filter{
+			Name:   aws.String("tag:" + k),
+			Values: []string{v},
+		})
+	}
+
+	out, err := e.client.DescribeVolumes(ctx, &ec2.DescribeVolumesInput{
+		Filters: filters,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	vols := make([]ManagedVolume, 0, len(out.Volumes))
+	for _, v := range out.Volumes {
+		vols = append(vols, fromEc2Volume(v))
+	}
+	return vols, nil
+}
+
+// AttachedVolumeCount returns the number of ebs volumes attached to the host instance
+func (e EbsProvider) AttachedVolumeCount(ctx context.Context) (int, error) {
+
+	attachedVolumesOutput, err := e.client.DescribeVolumes(ctx, &ec2.DescribeVolumesInput{
+		Filters: []types.Filter{
+			{
+				Name: aws.String("attachment.instance-id"),
+				Values: []string{
+					e.Host.InstanceId,
+				},
+			},
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(attachedVolumesOutput.Volumes), nil
+}
+
+// TagVolume adds or replaces tags on the volume
+func (e EbsProvider) TagVolume(ctx context.Context, volumeId string, tags []Tag) error {
+
+	_, err := e.client.CreateTags(ctx, &ec2.CreateTagsInput{
+		Resources: []string{volumeId},
+		Tags:      toEc2Tags(tags),
+	})
+	return err
+}
+
+// InstanceExists reports whether the instance exists and has not been terminated
+func (e EbsProvider) InstanceExists(ctx context.Context, instanceId string) (bool, error) {
+
+	out, err := e.client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceId},
+	})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidInstanceID.NotFound" {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	for _, r := range out.Reservations {
+		for _, i := range r.Instances {
+			if aws.ToString(i.InstanceId) == instanceId && i.State != nil && i.State.Name != types.InstanceStateNameTerminated {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// ResizeVolume modifies the size of the volume and waits for the modification to reach the optimizing state, from
+// which the new size is usable by the instance
+func (e EbsProvider) ResizeVolume(ctx context.Context, volumeId string, sizeGb int32) error {
+
+	_, err := e.client.ModifyVolume(ctx, &ec2.ModifyVolumeInput{
+		VolumeId: aws.String(volumeId),
+		Size:     aws.Int32(sizeGb),
+	})
+	if err != nil {
+		return err
+	}
+
+	ctxTimeout, timeoutCancel := context.WithTimeout(ctx, volumeModifiedTimeout)
+	ticker := time.NewTicker(volumeModifiedPollInterval)
+	defer func() {
+		ticker.Stop()
+		timeoutCancel()
+	}()
+
+	for {
+		m, err := e.latestModification(ctxTimeout, volumeId)
+		if err != nil {
+			return err
+		}
+		if m != nil {
+			switch m.ModificationState {
+			case types.VolumeModificationStateOptimizing, types.VolumeModificationStateCompleted:
+				return nil
+			case types.VolumeModificationStateFailed:
+				return fmt.Errorf("EbsProvider.ResizeVolume: modification of %s failed: %s", volumeId, aws.ToString(m.StatusMessage))
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctxTimeout.Done():
+			return fmt.Errorf("EbsProvider.ResizeVolume: waiting for volume: %s to be modified appears to have %w", volumeId, errTimedOut)
+		}
+	}
+}
+
+// LastModified returns the start time of the most recent modification of the volume
+func (e EbsProvider) LastModified(ctx context.Context, volumeId string) (time.Time, error) {
+
+	m, err := e.latestModification(ctx, volumeId)
+	if err != nil || m == nil {
+		return time.Time{}, err
+	}
+	return aws.ToTime(m.StartTime), nil
+}
+
+// MaxVolumeSizeGb returns the size limit of the ebs volume type
+func (e EbsProvider) MaxVolumeSizeGb(volumeType string) int32 {
+	return volumeSizeCapsGb[volumeType]
+}
+
+// latestModification returns the most recent modification of the volume, nil if it has never been modified
+func (e EbsProvider) latestModification(ctx context.Context, volumeId string) (*types.VolumeModification, error) {
+
+	out, err := e.client.DescribeVolumesModifications(ctx, &ec2.DescribeVolumesModificationsInput{
+		VolumeIds: []string{volumeId},
+	})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidVolumeModification.NotFound" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *types.VolumeModification
+	for i, m := range out.VolumesModifications {
+		if latest == nil || aws.ToTime(m.StartTime).After(aws.ToTime(latest.StartTime)) {
+			latest = &out.VolumesModifications[i]
+		}
+	}
+	return latest, nil
+}
+
+// ResolveDevice waits until the attached device appears under /dev
+func (e EbsProvider) ResolveDevice(ctx context.Context, volume ManagedVolume) (string, error) {
+
+	err := localVolAvailabilityWaiter(ctx, volume.Device, deviceAvailableTimeout)
+	if err != nil {
+		return "", err
+	}
+	return volume.Device, nil
+}
+
+// waitAvailable waits until the volume reaches the available state
+func (e EbsProvider) waitAvailable(ctx context.Context, volumeId string) error {
+
+	volWaiter := ec2.NewVolumeAvailableWaiter(e.client)
+	return volWaiter.Wait(ctx, &ec2.DescribeVolumesInput{
+		VolumeIds: []string{volumeId},
+	}, volumeAvailableTimeout)
+}
+
+// fromEc2Volume converts an ec2 volume to a ManagedVolume
+func fromEc2Volume(v types.Volume) ManagedVolume {
+
+	mv := ManagedVolume{
+		VolumeId:         aws.ToString(v.VolumeId),
+		SizeGb:           aws.ToInt32(v.Size),
+		Type:             string(v.VolumeType),
+		Iops:             v.Iops,
+		Throughput:       v.Throughput,
+		AvailabilityZone: aws.ToString(v.AvailabilityZone),
+		State:            string(v.State),
+		CreateTime:       aws.ToTime(v.CreateTime),
+		Tags:             make([]Tag, 0, len(v.Tags)),
+	}
+	for _, t := range v.Tags {
+		mv.Tags = append(mv.Tags, Tag{Key: aws.ToString(t.Key), Value: aws.ToString(t.Value)})
+	}
+	for _, a := range v.Attachments {
+		mv.AttachedTo = aws.ToString(a.InstanceId)
+		mv.Device = aws.ToString(a.Device)
+		mv.AttachmentState = string(a.State)
+		mv.DeleteOnTermination = aws.ToBool(a.DeleteOnTermination)
+	}
+	return mv
+}
+
+// toEc2Tags converts tags to their ec2 form
+func toEc2Tags(tags []Tag) []types.Tag {
+
+	ec2Tags := make([]types.Tag, 0, len(tags))
+	for _, t := range tags {
+		ec2Tags = append(ec2Tags, types.Tag{Key: aws.String(t.Key), Value: aws.String(t.Value)})
+	}
+	return ec2Tags
+}
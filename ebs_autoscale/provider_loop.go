@@ -0,0 +1,381 @@
+package ebs_autoscale
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/BobTheTerrible/ebs-autoscale/ebs_autoscale/filesystem"
+	"github.com/google/uuid"
+	"golang.org/x/sys/unix"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLoopStateDir = "/var/lib/ebs-autoscale/loop"
+	loopStateFile       = "volumes.json"
+)
+
+func init() {
+	RegisterProvider("loop", func(_ context.Context, host Ec2Host, options map[string]interface{}, _ AwsCfg) (BlockProvider, error) {
+		return NewLoopProvider(host, options)
+	})
+}
+
+// LoopProvider implements BlockProvider by backing each volume with a sparse file attached through losetup. The
+// requested device name is symlinked to the loop device. State is persisted to StateDir so init, grow and monitor
+// processes share it, each change to it is made holding an exclusive lock on a file next to it.
+type LoopProvider struct {
+	mu       sync.Mutex
+	Host     Ec2Host
+	StateDir string
+}
+
+// loopVolume is the persisted record of a loop backed volume
+type loopVolume struct {
+	ManagedVolume
+	LoopDevice string `json:"loop-device,omitempty"`
+}
+
+// NewLoopProvider returns a LoopProvider for the given provider options. Supported options: "state-dir".
+func NewLoopProvider(host Ec2Host, options map[string]interface{}) (*LoopProvider, error) {
+
+	stateDir := defaultLoopStateDir
+	if s, ok := options["state-dir"]; ok {
+		str, ok := s.(string)
+		if !ok {
+			return nil, fmt.Errorf("NewLoopProvider: state-dir must be a string: %v", s)
+		}
+		stateDir = str
+	}
+
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return nil, err
+	}
+	return &LoopProvider{Host: host, StateDir: stateDir}, nil
+}
+
+// CreateVolume creates a sparse backing file sized to the volume. Loop volumes are available immediately.
+func (l *LoopProvider) CreateVolume(_ context.Context, spec VolumeSpec) (*ManagedVolume, error) {
+	unlock, err := l.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	vols, err := l.load()
+	if err != nil {
+		return nil, err
+	}
+
+	v := loopVolume{
+		ManagedVolume: ManagedVolume{
+			VolumeId:         "loop-" + strings.ReplaceAll(uuid.NewString(), "-", "")[:17],
+			SizeGb:           spec.SizeGb,
+			Type:             spec.Type,
+			AvailabilityZone: spec.AvailabilityZone,
+			State:            "available",
+			CreateTime:       time.Now(),
+			Tags:             append([]Tag{}, spec.Tags...),
+		},
+	}
+
+	// The backing file is sparse, so only the blocks written to consume disk space
+	f, err := os.OpenFile(l.backingFile(v.VolumeId), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() //nolint:errcheck
+	if err := f.Truncate(int64(v.SizeGb) << 30); err != nil {
+		return nil, errors.Join(err, os.Remove(f.Name()))
+	}
+
+	if err := l.save(append(vols, v)); err != nil {
+		return nil, errors.Join(err, os.Remove(f.Name()))
+	}
+	return &v.ManagedVolume, nil
+}
+
+// AttachVolume sets up a loop device over the backing file and links the device name to it. Loop volumes outlive the
+// process, deleteOnTermination is recorded for parity with ebs.
+func (l *LoopProvider) AttachVolume(_ context.Context, volumeId string, device string, deleteOnTermination bool) error {
+	unlock, err := l.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	vols, v, err := l.find(volumeId)
+	if err != nil {
+		return err
+	}
+	if v.LoopDevice != "" {
+		return fmt.Errorf("LoopProvider.AttachVolume: volume %s is already attached to %s", v.VolumeId, v.Device)
+	}
+
+	loopDevice, err := filesystem.RunCommandOutput("losetup", "--find", "--show", l.backingFile(v.VolumeId))
+	if err != nil {
+		return err
+	}
+	if err := os.Symlink(loopDevice, device); err != nil {
+		err2 := filesystem.RunCommand("losetup", "--detach", loopDevice)
+		return errors.Join(err, err2)
+	}
+	slog.Debug(fmt.Sprintf("LoopProvider.AttachVolume: attached %s as %s -> %s", v.VolumeId, device, loopDevice))
+
+	v.State = "in-use"
+	v.AttachedTo = l.Host.InstanceId
+	v.Device = device
+	v.AttachmentState = "attached"
+	v.DeleteOnTermination = deleteOnTermination
+	v.LoopDevice = loopDevice
+	return l.save(vols)
+}
+
+// DetachVolume removes the device link and detaches the loop device
+func (l *LoopProvider) DetachVolume(_ context.Context, volumeId string) error {
+	unlock, err := l.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	vols, v, err := l.find(volumeId)
+	if err != nil {
+		return err
+	}
+	if v.LoopDevice == "" {
+		return fmt.Errorf("LoopProvider.DetachVolume: volume %s is not attached", v.VolumeId)
+	}
+
+	if err := os.Remove(v.Device); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := filesystem.RunCommand("losetup", "--detach", v.LoopDevice); err != nil {
+		return err
+	}
+
+	v.State = "available"
+	v.AttachedTo, v.Device, v.AttachmentState, v.LoopDevice = "", "", "", ""
+	v.DeleteOnTermination = false
+	return l.save(vols)
+}
+
+// DeleteVolume removes the backing file of a detached volume
+func (l *LoopProvider) DeleteVolume(_ context.Context, volumeId string) error {
+	unlock, err := l.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	vols, v, err := l.find(volumeId)
+	if err != nil {
+		return err
+	}
+	if v.LoopDevice != "" {
+		return fmt.Errorf("LoopProvider.DeleteVolume: volume %s is attached to %s", v.VolumeId, v.Device)
+	}
+
+	if err := os.Remove(l.backingFile(v.VolumeId)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	remaining := make([]loopVolume, 0, len(vols))
+	for _, o := range vols {
+		if o.VolumeId != v.VolumeId {
+			remaining = append(remaining, o)
+		}
+	}
+	return l.save(remaining)
+}
+
+// ListVolumes returns the loop volumes carrying all the given tags
+func (l *LoopProvider) ListVolumes(_ context.Context, tags map[string]string) ([]ManagedVolume, error) {
+	unlock, err := l.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	vols, err := l.load()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]ManagedVolume, 0, len(vols))
+	for _, v := range vols {
+		if v.hasTags(tags) {
+			out = append(out, v.ManagedVolume)
+		}
+	}
+	return out, nil
+}
+
+// AttachedVolumeCount returns the number of loop volumes attached to the host. Loop devices have no practical
+// attachment limit, so devices set up outside the provider are not counted.
+func (l *LoopProvider) AttachedVolumeCount(_ context.Context) (int, error) {
+	unlock, err := l.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	vols, err := l.load()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, v := range vols {
+		if v.AttachedTo == l.Host.InstanceId {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// TagVolume adds or replaces tags on the volume
+func (l *LoopProvider) TagVolume(_ context.Context, volumeId string, tags []Tag) error {
+	unlock, err := l.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	vols, v, err := l.find(volumeId)
+	if err != nil {
+		return err
+	}
+	for _, t := range tags {
+		replaced := false
+		for i := range v.Tags {
+			if v.Tags[i].Key == t.Key {
+				v.Tags[i].Value = t.Value
+				replaced = true
+			}
+		}
+		if !replaced {
+			v.Tags = append(v.Tags, t)
+		}
+	}
+	return l.save(vols)
+}
+
+// ResolveDevice returns the device link, which exists as soon as the volume is attached
+func (l *LoopProvider) ResolveDevice(_ context.Context, volume ManagedVolume) (string, error) {
+	if _, err := os.Stat(volume.Device); err != nil {
+		return "", fmt.Errorf("LoopProvider.ResolveDevice: %w", err)
+	}
+	return volume.Device, nil
+}
+
+// ResizeVolume extends the backing file and, when attached, has the loop device pick up the new capacity
+func (l *LoopProvider) ResizeVolume(_ context.Context, volumeId string, sizeGb int32) error {
+	unlock, err := l.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	vols, v, err := l.find(volumeId)
+	if err != nil {
+		return err
+	}
+	if sizeGb < v.SizeGb {
+		return fmt.Errorf("LoopProvider.ResizeVolume: volume %s cannot shrink from %dGb to %dGb", v.VolumeId, v.SizeGb, sizeGb)
+	}
+
+	if err := os.Truncate(l.backingFile(v.VolumeId), int64(sizeGb)<<30); err != nil {
+		return err
+	}
+	if v.LoopDevice != "" {
+		if err := filesystem.RunCommand("losetup", "--set-capacity", v.LoopDevice); err != nil {
+			return err
+		}
+	}
+
+	v.SizeGb = sizeGb
+	return l.save(vols)
+}
+
+// LastModified returns the zero time, loop volumes can be resized without waiting
+func (l *LoopProvider) LastModified(_ context.Context, _ string) (time.Time, error) {
+	return time.Time{}, nil
+}
+
+// MaxVolumeSizeGb returns 0, loop volumes are only limited by the space backing them
+func (l *LoopProvider) MaxVolumeSizeGb(_ string) int32 {
+	return 0
+}
+
+func (l *LoopProvider) backingFile(volumeId string) string {
+	return filepath.Join(l.StateDir, volumeId+".img")
+}
+
+// lock takes the lock of the state, returning the func releasing it. The mutex serialises the calls of this process,
+// the flock those of the other processes sharing StateDir, i.e. a grow run alongside the monitor.
+func (l *LoopProvider) lock() (func(), error) {
+
+	l.mu.Lock()
+	f, err := os.OpenFile(filepath.Join(l.StateDir, loopStateFile+".lock"), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		l.mu.Unlock()
+		return nil, err
+	}
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX); err != nil {
+		_ = f.Close()
+		l.mu.Unlock()
+		return nil, fmt.Errorf("LoopProvider.lock: %s: %w", l.StateDir, err)
+	}
+	return func() {
+		// closing the file releases the flock
+		_ = f.Close()
+		l.mu.Unlock()
+	}, nil
+}
+
+// find loads the state and returns it with a pointer to the given volume within it. The caller must hold the lock.
+func (l *LoopProvider) find(volumeId string) ([]loopVolume, *loopVolume, error) {
+	vols, err := l.load()
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range vols {
+		if vols[i].VolumeId == volumeId {
+			return vols, &vols[i], nil
+		}
+	}
+	return nil, nil, fmt.Errorf("LoopProvider: volume %s does not exist", volumeId)
+}
+
+// load reads the persisted volumes. The caller must hold the lock.
+func (l *LoopProvider) load() ([]loopVolume, error) {
+	b, err := os.ReadFile(filepath.Join(l.StateDir, loopStateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return []loopVolume{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var vols []loopVolume
+	if err := json.Unmarshal(b, &vols); err != nil {
+		return nil, fmt.Errorf("LoopProvider.load: %s: %w", loopStateFile, err)
+	}
+	return vols, nil
+}
+
+// save atomically replaces the persisted volumes. The caller must hold the lock.
+func (l *LoopProvider) save(vols []loopVolume) error {
+	b, err := json.MarshalIndent(vols, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(l.StateDir, loopStateFile+".tmp")
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(l.StateDir, loopStateFile))
+}
@@ -0,0 +1,204 @@
+package ebs_autoscale
+
+import (
+	"context"
+	"gotest.tools/assert"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestLoopProviderLifecycle(t *testing.T) {
+
+	ctx := context.Background()
+	stateDir := t.TempDir()
+	host := Ec2Host{InstanceId: "local", AvailabilityZone: "local-1a"}
+
+	loop, err := NewLoopProvider(host, map[string]interface{}{"state-dir": stateDir})
+	if err != nil {
+		t.Fatalf("NewLoopProvider Returned an unexpected error: %s", err)
+	}
+
+	created, err := loop.CreateVolume(ctx, VolumeSpec{
+		SizeGb:           2,
+		Type:             "gp3",
+		AvailabilityZone: "local-1a",
+		Tags:             []Tag{{Key: autoscaleIdTag, Value: "vol_id"}},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume Returned an unexpected error: %s", err)
+	}
+
+	// The backing file is sparse and sized to the volume
+	info, err := os.Stat(filepath.Join(stateDir, created.VolumeId+".img"))
+	if err != nil {
+		t.Fatalf("CreateVolume did not create a backing file: %s", err)
+	}
+	assert.Equal(t, info.Size(), int64(2)<<30)
+
+	// State is shared with other processes through the state dir
+	other, err := NewLoopProvider(host, map[string]interface{}{"state-dir": stateDir})
+	if err != nil {
+		t.Fatalf("NewLoopProvider Returned an unexpected error: %s", err)
+	}
+	listed, err := other.ListVolumes(ctx, map[string]string{autoscaleIdTag: "vol_id"})
+	if err != nil {
+		t.Fatalf("ListVolumes Returned an unexpected error: %s", err)
+	}
+	assert.Equal(t, len(listed), 1)
+	assert.Equal(t, listed[0].State, "available")
+	assert.Equal(t, listed[0].SizeGb, int32(2))
+
+	listed, err = other.ListVolumes(ctx, map[string]string{autoscaleIdTag: "other_id"})
+	if err != nil {
+		t.Fatalf("ListVolumes Returned an unexpected error: %s", err)
+	}
+	assert.Equal(t, len(listed), 0)
+
+	if err := other.TagVolume(ctx, created.VolumeId, []Tag{{Key: autoscaleIdTag, Value: "other_id"}}); err != nil {
+		t.Fatalf("TagVolume Returned an unexpected error: %s", err)
+	}
+	listed, err = loop.ListVolumes(ctx, map[string]string{autoscaleIdTag: "other_id"})
+	if err != nil {
+		t.Fatalf("ListVolumes Returned an unexpected error: %s", err)
+	}
+	assert.Equal(t, len(listed), 1)
+	assert.Equal(t, len(listed[0].Tags), 1)
+
+	if err := other.DetachVolume(ctx, created.VolumeId); err == nil {
+		t.Errorf("DetachVolume Expected an error detaching an unattached volume")
+	}
+
+	// A detached volume is resized by extending its backing file
+	if err := other.ResizeVolume(ctx, created.VolumeId, 3); err != nil {
+		t.Fatalf("ResizeVolume Returned an unexpected error: %s", err)
+	}
+	info, _ = os.Stat(filepath.Join(stateDir, created.VolumeId+".img"))
+	assert.Equal(t, info.Size(), int64(3)<<30)
+	if err := other.ResizeVolume(ctx, created.VolumeId, 1); err == nil {
+		t.Errorf("ResizeVolume Expected an error shrinking a volume")
+	}
+
+	if err := other.DeleteVolume(ctx, created.VolumeId); err != nil {
+		t.Fatalf("DeleteVolume Returned an unexpected error: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(stateDir, created.VolumeId+".img")); !os.IsNotExist(err) {
+		t.Errorf("DeleteVolume did not remove the backing file: %s", err)
+	}
+	listed, err = loop.ListVolumes(ctx, map[string]string{})
+	if err != nil {
+		t.Fatalf("ListVolumes Returned an unexpected error: %s", err)
+	}
+	assert.Equal(t, len(listed), 0)
+}
+
+func TestLoopProviderConcurrentProcesses(t *testing.T) {
+
+	ctx := context.Background()
+	stateDir := t.TempDir()
+	host := Ec2Host{InstanceId: "local", AvailabilityZone: "local-1a"}
+
+	// Each provider stands in for a process sharing the state dir, i.e. a grow alongside the monitor
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for p := 0; p < 4; p++ {
+		loop, err := NewLoopProvider(host, map[string]interface{}{"state-dir": stateDir})
+		if err != nil {
+			t.Fatalf("NewLoopProvider Returned an unexpected error: %s", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 5; n++ {
+				_, err := loop.CreateVolume(ctx, VolumeSpec{SizeGb: 1, Tags: []Tag{{Key: autoscaleIdTag, Value: "vol_id"}}})
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("CreateVolume Returned an unexpected error: %s", err)
+		}
+	}
+
+	// No update is lost
+	loop, _ := NewLoopProvider(host, map[string]interface{}{"state-dir": stateDir})
+	listed, err := loop.ListVolumes(ctx, map[string]string{})
+	if err != nil {
+		t.Fatalf("ListVolumes Returned an unexpected error: %s", err)
+	}
+	assert.Equal(t, len(listed), 20)
+}
+
+func TestLoopProviderCreateVolumeFails(t *testing.T) {
+
+	stateDir := t.TempDir()
+	loop, err := NewLoopProvider(Ec2Host{InstanceId: "local"}, map[string]interface{}{"state-dir": stateDir})
+	if err != nil {
+		t.Fatalf("NewLoopProvider Returned an unexpected error: %s", err)
+	}
+
+	// A negative size fails sizing the backing file, which is removed again
+	if _, err := loop.CreateVolume(context.Background(), VolumeSpec{SizeGb: -1}); err == nil {
+		t.Fatalf("CreateVolume Expected an error for a negative size")
+	}
+	images, _ := filepath.Glob(filepath.Join(stateDir, "*.img"))
+	assert.Equal(t, len(images), 0)
+}
+
+// TestLoopProviderAttach drives a real loop device and so needs root and losetup
+func TestLoopProviderAttach(t *testing.T) {
+
+	if os.Geteuid() != 0 {
+		t.Skip("attaching loop devices requires root")
+	}
+	if _, err := exec.LookPath("losetup"); err != nil {
+		t.Skip("losetup is not installed")
+	}
+
+	ctx := context.Background()
+	volume := defaultVolume
+	volume.Host = Ec2Host{InstanceId: "local", AvailabilityZone: "local-1a"}
+	volume.EbsType = "gp3"
+	volume.MaxLogicalSizeGb = 10
+	volume.MaxAttachedVolumes = 16
+	volume.MaxCreatedVolumes = 2
+	volume.ManagedVolumes = []ManagedVolume{}
+	volume.devicePrefix = filepath.Join(t.TempDir(), "xvdb")
+
+	loop, err := NewLoopProvider(volume.Host, map[string]interface{}{"state-dir": t.TempDir()})
+	if err != nil {
+		t.Fatalf("NewLoopProvider Returned an unexpected error: %s", err)
+	}
+	volume.Provider = loop
+
+	device, err := volume.createAndAttachEbsVolume(ctx, operationInit, 1)
+	if err != nil {
+		t.Skipf("loop devices are not usable here: %s", err)
+	}
+
+	target, err := os.Readlink(*device)
+	if err != nil {
+		t.Fatalf("createAndAttachEbsVolume Expected %s to link to a loop device: %s", *device, err)
+	}
+	assert.Assert(t, filepath.Dir(target) == "/dev")
+	assert.Equal(t, volume.ManagedVolumes[0].AttachedTo, "local")
+
+	count, err := loop.AttachedVolumeCount(ctx)
+	if err != nil {
+		t.Fatalf("AttachedVolumeCount Returned an unexpected error: %s", err)
+	}
+	assert.Equal(t, count, 1)
+
+	err = volume.removeVolume(ctx, volume.ManagedVolumes[0].VolumeId, true)
+	if err != nil {
+		t.Fatalf("removeVolume Returned an unexpected error: %s", err)
+	}
+	if _, err := os.Lstat(*device); !os.IsNotExist(err) {
+		t.Errorf("removeVolume did not remove the device link %s", *device)
+	}
+}
@@ -0,0 +1,130 @@
+package ebs_autoscale
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+)
+
+// Drift describes the differences found between the provider, the filesystem and the previously recorded managed
+// volumes
+type Drift struct {
+	// Added the volumes found at the provider that were not previously recorded
+	Added []string `json:"added"`
+	// Removed the previously recorded volumes no longer attached to the host
+	Removed []string `json:"removed"`
+	// NotInFilesystem the managed volumes whose device is not part of the filesystem
+	NotInFilesystem []ManagedVolume `json:"not-in-filesystem"`
+	// UnmanagedDevices the filesystem devices not backed by a managed volume
+	UnmanagedDevices []string `json:"unmanaged-devices"`
+}
+
+// HasDrift reports whether any difference was found
+func (d Drift) HasDrift() bool {
+	return len(d.Added) > 0 || len(d.Removed) > 0 || len(d.NotInFilesystem) > 0 || len(d.UnmanagedDevices) > 0
+}
+
+// Reconcile rebuilds ManagedVolumes from the provider, the volumes tagged for this filesystem and attached to the
+// host, and compares them against the devices reported by the filesystem. Any drift is logged and recorded in
+// LastDrift so the volume limits are enforced against the actual state.
+func (v *Volume) Reconcile(ctx context.Context) (*Drift, error) {
+
+	managed, err := listManagedVolumes(ctx, v.Provider, v.Host, v.Id)
+	if err != nil {
+		return nil, err
+	}
+	devices, err := v.Fs.Devices()
+	if err != nil {
+		return nil, err
+	}
+
+	drift := Drift{
+		Added:            []string{},
+		Removed:          []string{},
+		NotInFilesystem:  []ManagedVolume{},
+		UnmanagedDevices: []string{},
+	}
+
+	for _, mv := range managed {
+		if !containsVolumeId(v.ManagedVolumes, mv.VolumeId) {
+			drift.Added = append(drift.Added, mv.VolumeId)
+		}
+	}
+	for _, mv := range v.ManagedVolumes {
+		if !containsVolumeId(managed, mv.VolumeId) {
+			drift.Removed = append(drift.Removed, mv.VolumeId)
+		}
+	}
+
+	// Devices are compared by their resolved path, as attached devices are commonly links to nvme devices
+	fsDevices := make(map[string]string, len(devices))
+	for _, d := range devices {
+		fsDevices[resolveDevicePath(d)] = d
+	}
+	for _, mv := range managed {
+		resolved := resolveDevicePath(mv.Device)
+		if _, ok := fsDevices[resolved]; ok {
+			delete(fsDevices, resolved)
+			continue
+		}
+		drift.NotInFilesystem = append(drift.NotInFilesystem, mv)
+	}
+	for _, d := range devices {
+		if _, ok := fsDevices[resolveDevicePath(d)]; ok {
+			drift.UnmanagedDevices = append(drift.UnmanagedDevices, d)
+		}
+	}
+
+	if drift.HasDrift() {
+		slog.Warn(fmt.Sprintf("Reconcile: %s: drift detected: added:%v removed:%v not-in-filesystem:%v unmanaged-devices:%v",
+			v.Fs.GetMountPoint(), drift.Added, drift.Removed, volumeIds(drift.NotInFilesystem), drift.UnmanagedDevices))
+	}
+
+	v.ManagedVolumes = managed
+	v.LastDrift = &drift
+	return &drift, nil
+}
+
+// listManagedVolumes returns the volumes tagged with the autoscale id that are attached to the host
+func listManagedVolumes(ctx context.Context, provider BlockProvider, host Ec2Host, autoscaleId string) ([]ManagedVolume, error) {
+
+	taggedVolumes, err := provider.ListVolumes(ctx, map[string]string{autoscaleIdTag: autoscaleId})
+	if err != nil {
+		return nil, err
+	}
+
+	managedVolumes := make([]ManagedVolume, 0)
+	for _, mv := range taggedVolumes {
+		if mv.AttachedTo == host.InstanceId {
+			managedVolumes = append(managedVolumes, mv)
+		}
+	}
+	return managedVolumes, nil
+}
+
+// resolveDevicePath follows any links to the underlying device, falling back to the path as given
+func resolveDevicePath(device string) string {
+	resolved, err := filepath.EvalSymlinks(device)
+	if err != nil {
+		return device
+	}
+	return resolved
+}
+
+func containsVolumeId(vols []ManagedVolume, volumeId string) bool {
+	for _, v := range vols {
+		if v.VolumeId == volumeId {
+			return true
+		}
+	}
+	return false
+}
+
+func volumeIds(vols []ManagedVolume) []string {
+	ids := make([]string, 0, len(vols))
+	for _, v := range vols {
+		ids = append(ids, v.VolumeId)
+	}
+	return ids
+}
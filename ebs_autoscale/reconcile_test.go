@@ -0,0 +1,151 @@
+package ebs_autoscale
+
+import (
+	"context"
+	"github.com/BobTheTerrible/ebs-autoscale/ebs_autoscale/awsfake"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"gotest.tools/assert"
+	"testing"
+	"time"
+)
+
+// putManagedVolume adds a volume tagged for the filesystem to the fake, attached to instanceId when given
+func putManagedVolume(fake *awsfake.Ec2, volumeId string, autoscaleId string, instanceId string, device string) {
+
+	vol := types.Volume{
+		VolumeId: aws.String(volumeId),
+		Size:     aws.Int32(50),
+		State:    types.VolumeStateAvailable,
+		Tags:     []types.Tag{{Key: aws.String(autoscaleIdTag), Value: aws.String(autoscaleId)}},
+	}
+	if instanceId != "" {
+		vol.State = types.VolumeStateInUse
+		vol.Attachments = []types.VolumeAttachment{
+			{InstanceId: aws.String(instanceId), Device: aws.String(device), State: types.VolumeAttachmentStateAttached},
+		}
+	}
+	fake.PutVolume(vol)
+}
+
+type TestReconcileInputs struct {
+	Name string
+	// Setup prepares the fake and volume before the call
+	Setup func(fake *awsfake.Ec2, volume *Volume)
+	// Devices the devices reported by the filesystem
+	Devices         []string
+	ExpectedManaged []string
+	ExpectedDrift   Drift
+}
+
+func TestReconcile(t *testing.T) {
+
+	tests := []TestReconcileInputs{
+		{
+			Name: "In sync",
+			Setup: func(fake *awsfake.Ec2, volume *Volume) {
+				putManagedVolume(fake, "vol-1", volume.Id, volume.Host.InstanceId, "/dev/xvdba")
+				volume.ManagedVolumes = []ManagedVolume{{VolumeId: "vol-1"}}
+			},
+			Devices:         []string{"/dev/xvdba"},
+			ExpectedManaged: []string{"vol-1"},
+			ExpectedDrift:   Drift{},
+		},
+		{
+			Name: "Volume added since startup",
+			Setup: func(fake *awsfake.Ec2, volume *Volume) {
+				putManagedVolume(fake, "vol-1", volume.Id, volume.Host.InstanceId, "/dev/xvdba")
+				putManagedVolume(fake, "vol-2", volume.Id, volume.Host.InstanceId, "/dev/xvdbb")
+				volume.ManagedVolumes = []ManagedVolume{{VolumeId: "vol-1"}}
+			},
+			Devices:         []string{"/dev/xvdba", "/dev/xvdbb"},
+			ExpectedManaged: []string{"vol-1", "vol-2"},
+			ExpectedDrift:   Drift{Added: []string{"vol-2"}},
+		},
+		{
+			Name: "Volume detached elsewhere",
+			Setup: func(fake *awsfake.Ec2, volume *Volume) {
+				putManagedVolume(fake, "vol-1", volume.Id, volume.Host.InstanceId, "/dev/xvdba")
+				putManagedVolume(fake, "vol-2", volume.Id, "", "")
+				volume.ManagedVolumes = []ManagedVolume{{VolumeId: "vol-1"}, {VolumeId: "vol-2"}}
+			},
+			Devices:         []string{"/dev/xvdba"},
+			ExpectedManaged: []string{"vol-1"},
+			ExpectedDrift:   Drift{Removed: []string{"vol-2"}},
+		},
+		{
+			Name: "Attached volume outside the filesystem",
+			Setup: func(fake *awsfake.Ec2, volume *Volume) {
+				putManagedVolume(fake, "vol-1", volume.Id, volume.Host.InstanceId, "/dev/xvdba")
+				putManagedVolume(fake, "vol-2", volume.Id, volume.Host.InstanceId, "/dev/xvdbb")
+				volume.ManagedVolumes = []ManagedVolume{{VolumeId: "vol-1"}, {VolumeId: "vol-2"}}
+			},
+			Devices:         []string{"/dev/xvdba"},
+			ExpectedManaged: []string{"vol-1", "vol-2"},
+			ExpectedDrift: Drift{NotInFilesystem: []ManagedVolume{
+				{VolumeId: "vol-2", Device: "/dev/xvdbb"},
+			}},
+		},
+		{
+			Name: "Filesystem device without a managed volume",
+			Setup: func(fake *awsfake.Ec2, volume *Volume) {
+				putManagedVolume(fake, "vol-1", volume.Id, volume.Host.InstanceId, "/dev/xvdba")
+				putManagedVolume(fake, "vol-other", "other_id", volume.Host.InstanceId, "/dev/xvdbb")
+				volume.ManagedVolumes = []ManagedVolume{{VolumeId: "vol-1"}}
+			},
+			Devices:         []string{"/dev/xvdba", "/dev/xvdbb"},
+			ExpectedManaged: []string{"vol-1"},
+			ExpectedDrift:   Drift{UnmanagedDevices: []string{"/dev/xvdbb"}},
+		},
+	}
+
+	for _, i := range tests {
+
+		fake := awsfake.NewEc2()
+		volume := newFakeVolume(t, fake)
+		volume.Fs = mockFS{MountPoint: aws.String("/mnt/mock"), DeviceList: i.Devices}
+		i.Setup(fake, &volume)
+
+		drift, err := volume.Reconcile(context.Background())
+		if err != nil {
+			t.Fatalf("Reconcile(%s) Returned an unexpected error: %s", i.Name, err)
+		}
+
+		assert.DeepEqual(t, volumeIds(volume.ManagedVolumes), i.ExpectedManaged)
+		assert.DeepEqual(t, *drift, i.ExpectedDrift, cmpopts.EquateEmpty(),
+			cmpopts.IgnoreFields(ManagedVolume{}, "SizeGb", "State", "Tags", "AttachedTo", "AttachmentState"))
+		assert.Equal(t, drift.HasDrift(), i.Name != "In sync")
+		assert.Equal(t, volume.LastDrift, drift)
+	}
+}
+
+func TestMonitorEnforcesLimitsAcrossTicks(t *testing.T) {
+
+	fake := awsfake.NewEc2()
+	volume := newFakeVolume(t, fake)
+	volume.MaxCreatedVolumes = 2
+	volume.Fs = mockFS{
+		Size:       aws.Uint64(200),
+		Used:       aws.Uint64(200),
+		Free:       aws.Uint64(0),
+		MountPoint: aws.String("/mnt/mock"),
+		DeviceList: []string{},
+	}
+	monitor := NewMonitor(&volume, time.Second, 50)
+
+	// The first tick grows the filesystem, the managed volumes are then picked up from the provider
+	if err := monitor.assessAndGrow(context.Background()); err != nil {
+		t.Fatalf("assessAndGrow Returned an unexpected error: %s", err)
+	}
+	if err := monitor.assessAndGrow(context.Background()); err != nil {
+		t.Fatalf("assessAndGrow Returned an unexpected error: %s", err)
+	}
+	assert.Equal(t, len(volume.ManagedVolumes), 2)
+
+	// The limit is now reached, so a further tick must not create another volume. Having nothing to add is not an error.
+	if err := monitor.assessAndGrow(context.Background()); err != nil {
+		t.Errorf("assessAndGrow Returned an unexpected error once MaxCreatedVolumes is reached: %s", err)
+	}
+	assert.Equal(t, len(fake.Volumes()), 2)
+}
@@ -0,0 +1,112 @@
+package ebs_autoscale
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+const (
+	// RepairPolicyAdopt grows the filesystem across managed devices it does not span
+	RepairPolicyAdopt = "adopt"
+	// RepairPolicyRemove detaches and deletes managed volumes the filesystem does not span
+	RepairPolicyRemove = "remove"
+	// RepairPolicyReport only logs managed volumes the filesystem does not span
+	RepairPolicyReport = "report"
+
+	defaultRepairPolicy = RepairPolicyAdopt
+)
+
+// RepairResult records the managed volumes acted on by Repair
+type RepairResult struct {
+	// Adopted the volumes the filesystem was grown across
+	Adopted []string `json:"adopted"`
+	// Removed the volumes detached and deleted
+	Removed []string `json:"removed"`
+	// Ignored the volumes left as they were, under the report policy
+	Ignored []string `json:"ignored"`
+}
+
+// Repair finds the managed volumes attached to the host whose devices the filesystem does not span, i.e. when growing
+// the filesystem failed after the volume was attached, and handles them according to RepairPolicy. Such volumes would
+// otherwise never be used while still counting against MaxCreatedVolumes. Every volume is attempted, the errors of
+// those that failed are returned together. The lock of the journal is held throughout, so a volume another process is
+// growing onto is not mistaken for one left behind. Repair refuses, returning errJournalLocked, while another process
+// holds the lock, and leaves the volume of an operation in flight to Replay.
+func (v *Volume) Repair(ctx context.Context) (*RepairResult, error) {
+
+	policy := v.RepairPolicy
+	if policy == "" {
+		policy = defaultRepairPolicy
+	}
+	if policy != RepairPolicyAdopt && policy != RepairPolicyRemove && policy != RepairPolicyReport {
+		return nil, fmt.Errorf("Repair: unknown repair policy: %s", policy)
+	}
+
+	if err := v.Journal.Lock(); err != nil {
+		return nil, fmt.Errorf("Repair: an operation is in progress: %w", err)
+	}
+	defer v.Journal.Unlock()
+
+	inFlight := ""
+	entry, err := v.Journal.Load()
+	if err != nil {
+		return nil, err
+	}
+	if entry != nil {
+		inFlight = entry.VolumeId
+	}
+
+	drift, err := v.Reconcile(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := RepairResult{
+		Adopted: []string{},
+		Removed: []string{},
+		Ignored: []string{},
+	}
+
+	var errList []error
+	for _, mv := range drift.NotInFilesystem {
+		if mv.VolumeId == inFlight {
+			slog.Info(fmt.Sprintf("Repair: leaving volume %s of the interrupted %s to replay", mv.VolumeId, entry.Operation))
+			continue
+		}
+		switch policy {
+		case RepairPolicyAdopt:
+			err = v.adoptVolume(ctx, mv)
+			if err == nil {
+				result.Adopted = append(result.Adopted, mv.VolumeId)
+			}
+		case RepairPolicyRemove:
+			slog.Info(fmt.Sprintf("Repair: removing volume outside the filesystem: %s", mv.VolumeId))
+			err = v.removeVolume(ctx, mv.VolumeId, true)
+			if err == nil {
+				v.ManagedVolumes = removeVolumeId(v.ManagedVolumes, mv.VolumeId)
+				result.Removed = append(result.Removed, mv.VolumeId)
+			}
+		default:
+			slog.Warn(fmt.Sprintf("Repair: volume %s at %s is not part of the filesystem", mv.VolumeId, mv.Device))
+			result.Ignored = append(result.Ignored, mv.VolumeId)
+		}
+		if err != nil {
+			errList = append(errList, fmt.Errorf("Repair: %s: %w", mv.VolumeId, err))
+		}
+	}
+
+	return &result, errors.Join(errList...)
+}
+
+// adoptVolume waits for the volume's device to be available and grows the filesystem across it
+func (v *Volume) adoptVolume(ctx context.Context, mv ManagedVolume) error {
+
+	localDevice, err := v.Provider.ResolveDevice(ctx, mv)
+	if err != nil {
+		return err
+	}
+	slog.Info(fmt.Sprintf("Repair: growing the filesystem across %s of volume: %s", localDevice, mv.VolumeId))
+	return v.Fs.GrowFileSystem(localDevice)
+}
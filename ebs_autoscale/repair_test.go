@@ -0,0 +1,195 @@
+package ebs_autoscale
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/BobTheTerrible/ebs-autoscale/ebs_autoscale/awsfake"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"gotest.tools/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+type TestRepairInputs struct {
+	Name   string
+	Policy string
+	// Setup prepares the fake and filesystem before the call
+	Setup           func(fake *awsfake.Ec2, volume *Volume, fs *recordingFS)
+	ExpectedFsCalls []string
+	ExpectedResult  RepairResult
+	// ExpectedVolumes the ids of the volumes remaining in ec2
+	ExpectedVolumes []string
+	Error           bool
+}
+
+func TestRepair(t *testing.T) {
+
+	volumeAvailableTimeout = time.Second
+	deviceAvailableTimeout = 200 * time.Millisecond
+
+	mockErr := fmt.Errorf("mock error")
+
+	// attachVolume adds a volume tagged for the filesystem attached at a device that exists
+	attachVolume := func(fake *awsfake.Ec2, volume *Volume, volumeId string, suffix string) string {
+		device := volume.devicePrefix + suffix
+		putManagedVolume(fake, volumeId, volume.Id, volume.Host.InstanceId, device)
+		_ = os.WriteFile(device, []byte{}, 0600)
+		return device
+	}
+
+	tests := []TestRepairInputs{
+		{
+			Name:   "Nothing to repair",
+			Policy: RepairPolicyAdopt,
+			Setup: func(fake *awsfake.Ec2, volume *Volume, fs *recordingFS) {
+				fs.DeviceList = []string{attachVolume(fake, volume, "vol-1", "a")}
+			},
+			ExpectedFsCalls: nil,
+			ExpectedResult:  RepairResult{},
+			ExpectedVolumes: []string{"vol-1"},
+			Error:           false,
+		},
+		{
+			Name:   "Default policy adopts the device",
+			Policy: "",
+			Setup: func(fake *awsfake.Ec2, volume *Volume, fs *recordingFS) {
+				fs.DeviceList = []string{attachVolume(fake, volume, "vol-1", "a")}
+				attachVolume(fake, volume, "vol-2", "b")
+			},
+			ExpectedFsCalls: []string{"GrowFileSystem"},
+			ExpectedResult:  RepairResult{Adopted: []string{"vol-2"}},
+			ExpectedVolumes: []string{"vol-1", "vol-2"},
+			Error:           false,
+		},
+		{
+			Name:   "The volume of an interrupted operation is left to replay",
+			Policy: RepairPolicyRemove,
+			Setup: func(fake *awsfake.Ec2, volume *Volume, fs *recordingFS) {
+				fs.DeviceList = []string{attachVolume(fake, volume, "vol-1", "a")}
+				attachVolume(fake, volume, "vol-2", "b")
+				journal, err := NewJournal(t.TempDir(), volume.Id)
+				if err != nil {
+					t.Fatalf("NewJournal Returned an unexpected error: %s", err)
+				}
+				if err := journal.Begin(operationGrow, 25); err != nil {
+					t.Fatalf("Journal.Begin Returned an unexpected error: %s", err)
+				}
+				journal.Step(StepAttached, func(e *JournalEntry) { e.VolumeId = "vol-2" })
+				journal.Unlock()
+				volume.Journal = journal
+			},
+			ExpectedFsCalls: nil,
+			ExpectedResult:  RepairResult{},
+			ExpectedVolumes: []string{"vol-1", "vol-2"},
+			Error:           false,
+		},
+		{
+			Name:   "Adopt fails",
+			Policy: RepairPolicyAdopt,
+			Setup: func(fake *awsfake.Ec2, volume *Volume, fs *recordingFS) {
+				fs.DeviceList = []string{attachVolume(fake, volume, "vol-1", "a")}
+				attachVolume(fake, volume, "vol-2", "b")
+				fs.Err = mockErr
+			},
+			ExpectedFsCalls: []string{"GrowFileSystem"},
+			ExpectedResult:  RepairResult{},
+			ExpectedVolumes: []string{"vol-1", "vol-2"},
+			Error:           true,
+		},
+		{
+			Name:   "Remove policy deletes the volume",
+			Policy: RepairPolicyRemove,
+			Setup: func(fake *awsfake.Ec2, volume *Volume, fs *recordingFS) {
+				fs.DeviceList = []string{attachVolume(fake, volume, "vol-1", "a")}
+				attachVolume(fake, volume, "vol-2", "b")
+			},
+			ExpectedFsCalls: nil,
+			ExpectedResult:  RepairResult{Removed: []string{"vol-2"}},
+			ExpectedVolumes: []string{"vol-1"},
+			Error:           false,
+		},
+		{
+			Name:   "Report policy leaves the volume",
+			Policy: RepairPolicyReport,
+			Setup: func(fake *awsfake.Ec2, volume *Volume, fs *recordingFS) {
+				fs.DeviceList = []string{attachVolume(fake, volume, "vol-1", "a")}
+				attachVolume(fake, volume, "vol-2", "b")
+			},
+			ExpectedFsCalls: nil,
+			ExpectedResult:  RepairResult{Ignored: []string{"vol-2"}},
+			ExpectedVolumes: []string{"vol-1", "vol-2"},
+			Error:           false,
+		},
+	}
+
+	for _, i := range tests {
+
+		fake := awsfake.NewEc2()
+		volume := newFakeVolume(t, fake)
+		volume.RepairPolicy = i.Policy
+		var calls []string
+		fs := recordingFS{mockFS: mockFS{MountPoint: aws.String("/mnt/mock")}, calls: &calls}
+		i.Setup(fake, &volume, &fs)
+		volume.Fs = fs
+
+		result, err := volume.Repair(context.Background())
+
+		if (err == nil) == i.Error {
+			t.Errorf("Repair(%s) Returned an unexpected error: %s", i.Name, err)
+		}
+		assert.DeepEqual(t, calls, i.ExpectedFsCalls, cmpopts.EquateEmpty())
+		assert.DeepEqual(t, *result, i.ExpectedResult, cmpopts.EquateEmpty())
+		remaining := make([]string, 0)
+		for _, v := range fake.Volumes() {
+			remaining = append(remaining, *v.VolumeId)
+		}
+		assert.DeepEqual(t, remaining, i.ExpectedVolumes, cmpopts.EquateEmpty(), cmpopts.SortSlices(func(a, b string) bool { return a < b }))
+		assert.DeepEqual(t, volumeIds(volume.ManagedVolumes), i.ExpectedVolumes, cmpopts.EquateEmpty(), cmpopts.SortSlices(func(a, b string) bool { return a < b }))
+	}
+}
+
+func TestRepairLocked(t *testing.T) {
+
+	stateDir := t.TempDir()
+	owner, err := NewJournal(stateDir, "vol_id")
+	if err != nil {
+		t.Fatalf("NewJournal Returned an unexpected error: %s", err)
+	}
+	other, err := NewJournal(stateDir, "vol_id")
+	if err != nil {
+		t.Fatalf("NewJournal Returned an unexpected error: %s", err)
+	}
+
+	// Another process is part way through a grow, its attached volume is not yet part of the filesystem
+	if err := owner.Begin(operationGrow, 25); err != nil {
+		t.Fatalf("Journal.Begin Returned an unexpected error: %s", err)
+	}
+	defer owner.Complete()
+
+	fake := awsfake.NewEc2()
+	volume := newFakeVolume(t, fake)
+	volume.RepairPolicy = RepairPolicyRemove
+	volume.Journal = other
+	var calls []string
+	volume.Fs = recordingFS{mockFS: mockFS{MountPoint: aws.String("/mnt/mock"), DeviceList: []string{}}, calls: &calls}
+	putManagedVolume(fake, "vol-1", volume.Id, volume.Host.InstanceId, volume.devicePrefix+"a")
+
+	if _, err := volume.Repair(context.Background()); !errors.Is(err, errJournalLocked) {
+		t.Errorf("Repair Expected: %s Got: %s", errJournalLocked, err)
+	}
+	assert.Equal(t, len(fake.Volumes()), 1)
+	assert.Equal(t, len(calls), 0)
+}
+
+func TestRepairUnknownPolicy(t *testing.T) {
+
+	volume := newFakeVolume(t, awsfake.NewEc2())
+	volume.RepairPolicy = "unknown"
+
+	if _, err := volume.Repair(context.Background()); err == nil {
+		t.Errorf("Repair Expected an error for an unknown policy")
+	}
+}
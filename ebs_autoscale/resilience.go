@@ -0,0 +1,138 @@
+package ebs_autoscale
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/smithy-go"
+	"log/slog"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxBackoffSec   = 300
+	defaultBreakerFailures = 5
+	defaultBreakerResetSec = 1800
+)
+
+// errTimedOut is wrapped by the errors of the waits that expire
+var errTimedOut = errors.New("timed out")
+
+// retryableCodes the api error codes of throttling and service errors, which may succeed when retried
+var retryableCodes = map[string]bool{
+	"Throttling":                 true,
+	"ThrottlingException":        true,
+	"RequestLimitExceeded":       true,
+	"RequestThrottled":           true,
+	"RequestThrottledException":  true,
+	"TooManyRequestsException":   true,
+	"InsufficientVolumeCapacity": true,
+	"ServiceUnavailable":         true,
+	"Unavailable":                true,
+	"InternalError":              true,
+}
+
+// retryableError marks an error as worth retrying
+type retryableError struct {
+	err error
+}
+
+func (r retryableError) Error() string {
+	return r.err.Error()
+}
+
+func (r retryableError) Unwrap() error {
+	return r.err
+}
+
+// retryable marks the error as worth retrying, i.e. a transient failure reading the filesystem
+func retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return retryableError{err: err}
+}
+
+// isRetryable whether the error may succeed when retried: throttling, waits that timed out and errors marked as
+// retryable. Any other error is fatal, retrying it is not expected to help.
+func isRetryable(err error) bool {
+
+	if errors.As(err, &retryableError{}) || errors.Is(err, errTimedOut) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, errJournalLocked) {
+		return true
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && retryableCodes[apiErr.ErrorCode()] {
+		return true
+	}
+	// the sdk waiters return an unwrapped error once their max wait time is exceeded
+	return strings.Contains(err.Error(), "exceeded max wait time")
+}
+
+// nextPoll returns the delay before the next assessment. After a retryable error the delay backs off exponentially,
+// with jitter, up to MaxBackoffSec. Every error is logged, the monitor carries on regardless.
+func (m *MonitorVolume) nextPoll(err error) time.Duration {
+
+	interval := m.pollInterval()
+	if err == nil {
+		m.failures = 0
+		return interval
+	}
+
+	if !isRetryable(err) {
+		m.failures = 0
+		slog.Error(fmt.Sprintf("nextPoll: assessment failed, retrying in %s: %s", interval, err))
+		return interval
+	}
+
+	maxBackoff := time.Duration(m.MaxBackoffSec) * time.Second
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoffSec * time.Second
+	}
+	m.failures++
+	backoff := interval << min(m.failures, 16)
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	// full delays from several hosts throttled together would retry in step, so between half and the full delay is used,
+	// never less than 1ns as the ticker does not take 0
+	backoff -= rand.N(backoff/2 + 1)
+	slog.Warn(fmt.Sprintf("nextPoll: assessment failed %d time(s), retrying in %s: %s", m.failures, backoff.Round(time.Millisecond), err))
+	return backoff
+}
+
+// breakerOpen whether grows are stopped by the circuit breaker at the given time, and when the next attempt is allowed.
+// The breaker opens after BreakerFailures consecutive failed grows, once BreakerResetSec has passed a single grow is
+// allowed, closing it again when it succeeds.
+func (m *MonitorVolume) breakerOpen(now time.Time) (bool, time.Time) {
+
+	if m.BreakerFailures <= 0 || m.growFailures < m.BreakerFailures {
+		return false, time.Time{}
+	}
+	retryAt := m.breakerOpened.Add(time.Duration(m.BreakerResetSec) * time.Second)
+	return now.Before(retryAt), retryAt
+}
+
+// growFailed counts a failed grow towards the circuit breaker. A fatal error opens the breaker at once.
+func (m *MonitorVolume) growFailed(now time.Time, err error) {
+
+	m.growFailures++
+	if !isRetryable(err) {
+		m.growFailures = max(m.growFailures, m.BreakerFailures)
+	}
+	if m.BreakerFailures > 0 && m.growFailures >= m.BreakerFailures {
+		m.breakerOpened = now
+		slog.Error(fmt.Sprintf("growFailed: circuit breaker opened after %d failed grow(s), grows stopped for %ds: %s", m.growFailures, m.BreakerResetSec, err))
+	}
+}
+
+// growSucceeded closes the circuit breaker
+func (m *MonitorVolume) growSucceeded() {
+
+	if m.BreakerFailures > 0 && m.growFailures >= m.BreakerFailures {
+		slog.Info(fmt.Sprintf("growSucceeded: circuit breaker closed: %s", m.Volume.Fs.GetMountPoint()))
+	}
+	m.growFailures = 0
+}
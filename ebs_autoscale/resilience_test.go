@@ -0,0 +1,244 @@
+package ebs_autoscale
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/smithy-go"
+	"gotest.tools/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+type TestIsRetryableInputs struct {
+	Name     string
+	Err      error
+	Expected bool
+}
+
+func TestIsRetryable(t *testing.T) {
+
+	tests := []TestIsRetryableInputs{
+		{
+			Name:     "Throttled",
+			Err:      &smithy.GenericAPIError{Code: "RequestLimitExceeded"},
+			Expected: true,
+		},
+		{
+			Name:     "Wrapped throttle",
+			Err:      fmt.Errorf("CreateVolume: %w", &smithy.GenericAPIError{Code: "Throttling"}),
+			Expected: true,
+		},
+		{
+			Name:     "Not authorised",
+			Err:      &smithy.GenericAPIError{Code: "UnauthorizedOperation"},
+			Expected: false,
+		},
+		{
+			Name:     "Wait timed out",
+			Err:      fmt.Errorf("waiting for device: /dev/xvdba appears to have %w", errTimedOut),
+			Expected: true,
+		},
+		{
+			Name:     "Sdk waiter timed out",
+			Err:      fmt.Errorf("exceeded max wait time for VolumeAvailable waiter"),
+			Expected: true,
+		},
+		{
+			Name:     "Marked as retryable",
+			Err:      retryable(os.ErrDeadlineExceeded),
+			Expected: true,
+		},
+		{
+			Name:     "Limit reached",
+			Err:      fmt.Errorf("cannot grow, max volumes reached"),
+			Expected: false,
+		},
+	}
+
+	for _, i := range tests {
+		assert.Equal(t, isRetryable(i.Err), i.Expected, i.Name)
+	}
+}
+
+func TestNextPoll(t *testing.T) {
+
+	volume := defaultVolume
+	monitor := NewMonitor(&volume, 10*time.Second, 50)
+	monitor.MaxBackoffSec = 60
+	throttled := &smithy.GenericAPIError{Code: "RequestLimitExceeded"}
+
+	// Each retryable failure doubles the delay, which is jittered between half and all of it, up to the max
+	for n, expected := range []time.Duration{20 * time.Second, 40 * time.Second, 60 * time.Second, 60 * time.Second} {
+		delay := monitor.nextPoll(throttled)
+		if delay < expected/2 || delay > expected {
+			t.Errorf("nextPoll(failure %d) Expected between: %s and %s Got: %s", n+1, expected/2, expected, delay)
+		}
+	}
+
+	// A fatal error is retried at the poll interval, as is the assessment after a success
+	assert.Equal(t, monitor.nextPoll(fmt.Errorf("mock error")), 10*time.Second)
+	monitor.nextPoll(throttled)
+	assert.Equal(t, monitor.nextPoll(nil), 10*time.Second)
+	assert.Equal(t, monitor.failures, 0)
+}
+
+type TestMonitorCircuitBreakerInputs struct {
+	Name string
+	// Ticks when each assessment is made after the first
+	Ticks []time.Duration
+	// Errs the error CreateVolume returns at each tick, nil for none
+	Errs []error
+	// ExpectedCreates the CreateVolume calls made
+	ExpectedCreates int
+	ExpectedGrows   int
+}
+
+func TestMonitorCircuitBreaker(t *testing.T) {
+
+	throttled := &smithy.GenericAPIError{Code: "RequestLimitExceeded"}
+	unauthorised := &smithy.GenericAPIError{Code: "UnauthorizedOperation"}
+
+	tests := []TestMonitorCircuitBreakerInputs{
+		{
+			Name:            "Opens after consecutive failures",
+			Ticks:           []time.Duration{0, time.Minute, 2 * time.Minute, 3 * time.Minute},
+			Errs:            []error{throttled, throttled, nil, nil},
+			ExpectedCreates: 2,
+			ExpectedGrows:   0,
+		},
+		{
+			Name:            "Fatal error opens at once",
+			Ticks:           []time.Duration{0, time.Minute, 2 * time.Minute},
+			Errs:            []error{unauthorised, nil, nil},
+			ExpectedCreates: 1,
+			ExpectedGrows:   0,
+		},
+		{
+			Name:            "Success resets the failures",
+			Ticks:           []time.Duration{0, time.Minute, 2 * time.Minute, 3 * time.Minute},
+			Errs:            []error{throttled, nil, throttled, nil},
+			ExpectedCreates: 4,
+			ExpectedGrows:   2,
+		},
+		{
+			Name:            "Closes after a grow once reset",
+			Ticks:           []time.Duration{0, time.Minute, 2 * time.Minute, 12 * time.Minute, 13 * time.Minute},
+			Errs:            []error{throttled, throttled, nil, nil, nil},
+			ExpectedCreates: 4,
+			ExpectedGrows:   2,
+		},
+	}
+
+	for _, i := range tests {
+
+		monitor := newTestMonitor(t, 100)
+		monitor.setUsed(90)
+		monitor.BreakerFailures = 2
+		monitor.BreakerResetSec = 600
+
+		for n, tk := range i.Ticks {
+			monitor.at(tk)
+			if i.Errs[n] != nil {
+				monitor.fake.FailNext("CreateVolume", i.Errs[n])
+			}
+			_ = monitor.assessAndGrow(context.Background())
+		}
+
+		creates := 0
+		for _, c := range monitor.fake.Calls() {
+			if c == "CreateVolume" {
+				creates++
+			}
+		}
+		assert.Equal(t, creates, i.ExpectedCreates, i.Name)
+		assert.Equal(t, len(monitor.fake.Volumes()), i.ExpectedGrows, i.Name)
+	}
+}
+
+type TestMonitorReconcileFailsInputs struct {
+	Name string
+	// Setup fails the reconcile of the monitor
+	Setup             func(m *testMonitor)
+	ExpectedRetryable bool
+}
+
+func TestMonitorReconcileFails(t *testing.T) {
+
+	tests := []TestMonitorReconcileFailsInputs{
+		{
+			Name: "Throttled",
+			Setup: func(m *testMonitor) {
+				m.fake.FailNext("DescribeVolumes", &smithy.GenericAPIError{Code: "RequestLimitExceeded"})
+			},
+			ExpectedRetryable: true,
+		},
+		{
+			Name: "Unauthorised",
+			Setup: func(m *testMonitor) {
+				m.fake.FailNext("DescribeVolumes", &smithy.GenericAPIError{Code: "UnauthorizedOperation"})
+			},
+			ExpectedRetryable: false,
+		},
+		{
+			Name: "Listing the devices fails",
+			Setup: func(m *testMonitor) {
+				fs := m.fs
+				fs.Err = fmt.Errorf("mock error")
+				m.Volume.Fs = fs
+			},
+			ExpectedRetryable: true,
+		},
+	}
+
+	for _, i := range tests {
+
+		monitor := newTestMonitor(t, 100)
+		i.Setup(monitor)
+
+		err := monitor.assessAndGrow(context.Background())
+		if err == nil {
+			t.Fatalf("assessAndGrow(%s) Expected an error", i.Name)
+		}
+		assert.Equal(t, isRetryable(err), i.ExpectedRetryable, i.Name)
+	}
+}
+
+func TestMonitorNothingToAdd(t *testing.T) {
+
+	monitor := newTestMonitor(t, 100)
+	monitor.setUsed(90)
+	// no room is left under the max size
+	monitor.Volume.MaxLogicalSizeGb = 0
+	monitor.BreakerFailures = 2
+
+	// A grow with nothing to add is not a failure, so it neither returns an error nor opens the circuit breaker
+	if err := monitor.assessAndGrow(context.Background()); err != nil {
+		t.Errorf("assessAndGrow Returned an unexpected error: %s", err)
+	}
+	open, _ := monitor.breakerOpen(monitor.now())
+	assert.Assert(t, !open)
+	assert.Equal(t, monitor.growFailures, int32(0))
+}
+
+func TestMonitorRunRepairFails(t *testing.T) {
+
+	monitor := newTestMonitor(t, 100)
+	monitor.setUsed(10)
+	monitor.PollInterval = 10 * time.Millisecond
+
+	// A failed repair at startup is retried rather than stopping the monitor
+	monitor.fake.FailNext("DescribeVolumes", &smithy.GenericAPIError{Code: "RequestLimitExceeded"})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := monitor.Run(ctx); err != nil {
+		t.Errorf("Run Returned an unexpected error: %s", err)
+	}
+	describes := 0
+	for _, c := range monitor.fake.Calls() {
+		if c == "DescribeVolumes" {
+			describes++
+		}
+	}
+	assert.Assert(t, describes > 1)
+}
@@ -0,0 +1,126 @@
+package ebs_autoscale
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+const (
+	// GrowStrategyAttach grows the filesystem by attaching a new volume
+	GrowStrategyAttach = "attach"
+	// GrowStrategyModify grows a managed volume in place, attaching a new volume when none can be modified
+	GrowStrategyModify = "modify"
+
+	defaultGrowStrategy = GrowStrategyAttach
+)
+
+// modificationCooldown is the time ebs requires between modifications of a volume
+var modificationCooldown = 6 * time.Hour
+
+// growInPlace enlarges one of the managed volumes by sizeGb and grows the filesystem to fill it. false is returned,
+// without an error, when no volume can be modified: the provider cannot resize volumes, or every volume is within its
+// modification cooldown or would exceed the size limit of its type.
+func (v *Volume) growInPlace(ctx context.Context, sizeGb int32) (bool, error) {
+
+	resizer, ok := v.Provider.(VolumeResizer)
+	if !ok {
+		slog.Warn("growInPlace: the provider cannot resize volumes")
+		return false, nil
+	}
+
+	volSize := v.managedVolumeSizeGb()
+	if volSize > v.MaxLogicalSizeGb {
+		return false, fmt.Errorf("growInPlace: MaxLogicalSizeGb exceeded: max:%dGb observed:%dGb: %w", v.MaxLogicalSizeGb, volSize, errNothingToAdd)
+	}
+
+	mv, err := v.resizeCandidate(ctx, resizer, sizeGb, time.Now)
+	if err != nil || mv == nil {
+		return false, err
+	}
+
+	// The modification cannot be undone, so the journal only records it for the filesystem resize to be retried
+	err = v.Journal.Begin(operationResize, sizeGb)
+	if err != nil {
+		return false, err
+	}
+	v.Journal.Step(StepModifying, func(e *JournalEntry) {
+		e.VolumeId = mv.VolumeId
+		e.Device = mv.Device
+	})
+
+	slog.Info(fmt.Sprintf("growInPlace: resizing volume %s from %dGb to %dGb", mv.VolumeId, mv.SizeGb, mv.SizeGb+sizeGb))
+	err = resizer.ResizeVolume(ctx, mv.VolumeId, mv.SizeGb+sizeGb)
+	if err != nil {
+		return false, err
+	}
+	localDevice, err := v.Provider.ResolveDevice(ctx, *mv)
+	if err != nil {
+		return false, err
+	}
+	// A failure here leaves the journal in place, the next start will retry the resize
+	err = v.Fs.ResizeDevice(localDevice)
+	if err != nil {
+		return false, err
+	}
+
+	for i := range v.ManagedVolumes {
+		if v.ManagedVolumes[i].VolumeId == mv.VolumeId {
+			v.ManagedVolumes[i].SizeGb = mv.SizeGb + sizeGb
+		}
+	}
+	v.Journal.Complete()
+	return true, nil
+}
+
+// resizeCandidate returns the smallest managed volume that can grow by sizeGb, nil if there is none. A volume cannot
+// grow while within modificationCooldown of its last modification, or beyond the size limit of its type.
+func (v *Volume) resizeCandidate(ctx context.Context, resizer VolumeResizer, sizeGb int32, now func() time.Time) (*ManagedVolume, error) {
+
+	candidates := append([]ManagedVolume{}, v.ManagedVolumes...)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].SizeGb < candidates[j].SizeGb
+	})
+
+	for _, mv := range candidates {
+		if limit := resizer.MaxVolumeSizeGb(mv.Type); limit > 0 && mv.SizeGb+sizeGb > limit {
+			slog.Info(fmt.Sprintf("resizeCandidate: volume %s would exceed the %dGb limit of %s", mv.VolumeId, limit, mv.Type))
+			continue
+		}
+		lastModified, err := resizer.LastModified(ctx, mv.VolumeId)
+		if err != nil {
+			return nil, err
+		}
+		if wait := lastModified.Add(modificationCooldown).Sub(now()); wait > 0 {
+			slog.Info(fmt.Sprintf("resizeCandidate: volume %s can next be modified in %s", mv.VolumeId, wait.Round(time.Minute)))
+			continue
+		}
+		return &mv, nil
+	}
+	return nil, nil
+}
+
+// rollForwardResize grows the filesystem to fill a volume whose modification was interrupted. A volume that is no
+// longer attached has nothing to resize.
+func (v *Volume) rollForwardResize(ctx context.Context, entry JournalEntry) error {
+
+	managed, err := listManagedVolumes(ctx, v.Provider, v.Host, v.Id)
+	if err != nil {
+		return err
+	}
+	for _, mv := range managed {
+		if mv.VolumeId != entry.VolumeId {
+			continue
+		}
+		localDevice, err := v.Provider.ResolveDevice(ctx, mv)
+		if err != nil {
+			return err
+		}
+		slog.Info(fmt.Sprintf("Replay: resizing the filesystem on: %s", localDevice))
+		return v.Fs.ResizeDevice(localDevice)
+	}
+	slog.Warn(fmt.Sprintf("Replay: volume %s is no longer attached, nothing to resize", entry.VolumeId))
+	return nil
+}
@@ -0,0 +1,140 @@
+package ebs_autoscale
+
+import (
+	"context"
+	"fmt"
+	"github.com/BobTheTerrible/ebs-autoscale/ebs_autoscale/awsfake"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"gotest.tools/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+type TestGrowVolumeModifyInputs struct {
+	Name string
+	// Setup prepares the fake and volume before the call
+	Setup           func(fake *awsfake.Ec2, volume *Volume)
+	ExpectedFsCalls []string
+	// ExpectedSizes the sizes of the volumes in ec2 afterwards, keyed by id
+	ExpectedSizes map[string]int32
+	Error         bool
+}
+
+func TestGrowVolumeModify(t *testing.T) {
+
+	volumeAvailableTimeout = time.Second
+	volumeModifiedTimeout = time.Second
+	deviceAvailableTimeout = 200 * time.Millisecond
+
+	// modifiedAt records a modification of the volume at the given time
+	modifiedAt := func(fake *awsfake.Ec2, volumeId string, start time.Time) {
+		fake.PutVolumeModification(types.VolumeModification{
+			VolumeId:          aws.String(volumeId),
+			ModificationState: types.VolumeModificationStateCompleted,
+			StartTime:         aws.Time(start),
+		})
+	}
+
+	tests := []TestGrowVolumeModifyInputs{
+		{
+			Name:            "Modifies a volume",
+			Setup:           func(fake *awsfake.Ec2, volume *Volume) {},
+			ExpectedFsCalls: []string{"ResizeDevice"},
+			ExpectedSizes:   map[string]int32{"vol-1": 125, "vol-2": 50},
+			Error:           false,
+		},
+		{
+			Name: "Skips a volume in cooldown",
+			Setup: func(fake *awsfake.Ec2, volume *Volume) {
+				modifiedAt(fake, "vol-1", time.Now().Add(-time.Hour))
+			},
+			ExpectedFsCalls: []string{"ResizeDevice"},
+			ExpectedSizes:   map[string]int32{"vol-1": 50, "vol-2": 125},
+			Error:           false,
+		},
+		{
+			Name: "Cooldown has passed",
+			Setup: func(fake *awsfake.Ec2, volume *Volume) {
+				modifiedAt(fake, "vol-1", time.Now().Add(-7*time.Hour))
+			},
+			ExpectedFsCalls: []string{"ResizeDevice"},
+			ExpectedSizes:   map[string]int32{"vol-1": 125, "vol-2": 50},
+			Error:           false,
+		},
+		{
+			Name: "Every volume in cooldown attaches a volume",
+			Setup: func(fake *awsfake.Ec2, volume *Volume) {
+				modifiedAt(fake, "vol-1", time.Now().Add(-time.Hour))
+				modifiedAt(fake, "vol-2", time.Now().Add(-time.Hour))
+			},
+			ExpectedFsCalls: []string{"GrowFileSystem"},
+			ExpectedSizes:   map[string]int32{"vol-1": 50, "vol-2": 50, "vol-00000000000000001": 75},
+			Error:           false,
+		},
+		{
+			Name: "Type size limit attaches a volume",
+			Setup: func(fake *awsfake.Ec2, volume *Volume) {
+				volume.MaxLogicalSizeGb = 40000
+			},
+			ExpectedFsCalls: []string{"GrowFileSystem"},
+			ExpectedSizes:   map[string]int32{"vol-1": 50, "vol-2": 50, "vol-00000000000000001": 19975},
+			Error:           false,
+		},
+		{
+			Name: "Failed modification",
+			Setup: func(fake *awsfake.Ec2, volume *Volume) {
+				fake.FailNext("ModifyVolume", fmt.Errorf("mock error"))
+			},
+			ExpectedFsCalls: nil,
+			ExpectedSizes:   map[string]int32{"vol-1": 50, "vol-2": 50},
+			Error:           true,
+		},
+	}
+
+	for _, i := range tests {
+
+		fake := awsfake.NewEc2()
+		volume := newFakeVolume(t, fake)
+		volume.GrowStrategy = GrowStrategyModify
+		var calls []string
+		volume.Fs = recordingFS{mockFS: mockFS{MountPoint: aws.String("/mnt/mock")}, calls: &calls}
+		for n, id := range []string{"vol-1", "vol-2"} {
+			device := volume.devicePrefix + string(rune('a'+n))
+			putManagedVolume(fake, id, volume.Id, volume.Host.InstanceId, device)
+			_ = os.WriteFile(device, []byte{}, 0600)
+			v, _ := fake.Volume(id)
+			v.VolumeType = types.VolumeTypeGp3
+			fake.PutVolume(v)
+		}
+		managed, err := listManagedVolumes(context.Background(), volume.Provider, volume.Host, volume.Id)
+		if err != nil {
+			t.Fatalf("listManagedVolumes(%s) Returned an unexpected error: %s", i.Name, err)
+		}
+		volume.ManagedVolumes = managed
+		i.Setup(fake, &volume)
+
+		err = volume.GrowVolume(context.Background())
+
+		if (err == nil) == i.Error {
+			t.Errorf("GrowVolume(%s) Returned an unexpected error: %s", i.Name, err)
+		}
+		assert.DeepEqual(t, calls, i.ExpectedFsCalls, cmpopts.EquateEmpty())
+		sizes := map[string]int32{}
+		for _, v := range fake.Volumes() {
+			sizes[*v.VolumeId] = *v.Size
+		}
+		assert.DeepEqual(t, sizes, i.ExpectedSizes)
+		assert.Equal(t, volume.managedVolumeSizeGb(), sumSizes(i.ExpectedSizes), i.Name)
+	}
+}
+
+func sumSizes(sizes map[string]int32) int32 {
+	total := int32(0)
+	for _, s := range sizes {
+		total += s
+	}
+	return total
+}
@@ -0,0 +1,203 @@
+package ebs_autoscale
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+const defaultSizingPolicy = "even"
+
+// errNothingToAdd is returned when a grow has nothing to add, the sizing policy sees no need for more space or the
+// limits of the volume leave no room. It is not a failure of the grow.
+var errNothingToAdd = errors.New("nothing to add")
+
+func init() {
+	RegisterSizingPolicy("even", func(_ map[string]interface{}) (SizingPolicy, error) {
+		return EvenSizing{}, nil
+	})
+	RegisterSizingPolicy("fixed", func(options map[string]interface{}) (SizingPolicy, error) {
+		sizeGb, err := requiredNumberOption(options, "size-gb")
+		if err != nil {
+			return nil, err
+		}
+		if sizeGb < 1 {
+			return nil, fmt.Errorf("fixed sizing: size-gb must be at least 1: %v", sizeGb)
+		}
+		return FixedSizing{SizeGb: int32(sizeGb)}, nil
+	})
+	RegisterSizingPolicy("percent", func(options map[string]interface{}) (SizingPolicy, error) {
+		percent, err := requiredNumberOption(options, "percent")
+		if err != nil {
+			return nil, err
+		}
+		if percent <= 0 {
+			return nil, fmt.Errorf("percent sizing: percent must be positive: %v", percent)
+		}
+		return PercentSizing{Percent: percent}, nil
+	})
+	RegisterSizingPolicy("steps", func(options map[string]interface{}) (SizingPolicy, error) {
+		raw, ok := options["steps-gb"].([]interface{})
+		if !ok || len(raw) == 0 {
+			return nil, fmt.Errorf("steps sizing: steps-gb must be a list of sizes: %v", options["steps-gb"])
+		}
+		steps := make([]int32, 0, len(raw))
+		for _, r := range raw {
+			step, ok := toNumber(r)
+			if !ok || step < 1 {
+				return nil, fmt.Errorf("steps sizing: steps-gb must be sizes of at least 1: %v", r)
+			}
+			steps = append(steps, int32(step))
+		}
+		return StepSizing{StepsGb: steps}, nil
+	})
+	RegisterSizingPolicy("target-usage", func(options map[string]interface{}) (SizingPolicy, error) {
+		targetPc, err := requiredNumberOption(options, "target-pc")
+		if err != nil {
+			return nil, err
+		}
+		if targetPc <= 0 || targetPc >= 100 {
+			return nil, fmt.Errorf("target-usage sizing: target-pc must be between 0 and 100: %v", targetPc)
+		}
+		return TargetUsageSizing{TargetPc: targetPc}, nil
+	})
+}
+
+// SizingPolicy decides how much space each grow adds to the filesystem. The size returned is clamped to the room left
+// under MaxLogicalSizeGb by the caller.
+type SizingPolicy interface {
+	// NextSizeGb returns the size in Gb the next grow should add
+	NextSizeGb(state SizingState) (int32, error)
+}
+
+// SizingState describes the filesystem to a SizingPolicy
+type SizingState struct {
+	// CurrentSizeGb the combined size of the managed volumes
+	CurrentSizeGb     int32
+	InitialSizeGb     int32
+	MaxLogicalSizeGb  int32
+	MaxCreatedVolumes int32
+	// Stat returns the total, used and free bytes of the filesystem
+	Stat func() (uint64, uint64, uint64, error)
+}
+
+type sizingConstructor func(options map[string]interface{}) (SizingPolicy, error)
+
+var sizingPolicies = map[string]sizingConstructor{}
+
+// RegisterSizingPolicy allows adding a new sizing policy type to the registry
+func RegisterSizingPolicy(name string, constructor sizingConstructor) {
+	sizingPolicies[name] = constructor
+}
+
+// GetSizingPolicy returns the configured sizing policy
+func GetSizingPolicy(policyType string, options map[string]interface{}) (SizingPolicy, error) {
+	if constructor, exists := sizingPolicies[policyType]; exists {
+		return constructor(options)
+	}
+	return nil, fmt.Errorf("unsupported sizing policy: %s", policyType)
+}
+
+// EvenSizing splits the room between the initial and maximum size evenly across the volumes still to be created
+type EvenSizing struct{}
+
+func (EvenSizing) NextSizeGb(state SizingState) (int32, error) {
+	difference := state.MaxLogicalSizeGb - state.InitialSizeGb
+	if difference <= 0 {
+		return 0, fmt.Errorf("EvenSizing: Cannot grow, the volume size is already at or beyond max size: %w", errNothingToAdd)
+	}
+	if state.MaxCreatedVolumes <= 1 {
+		return 0, fmt.Errorf("EvenSizing: Cannot grow, MaxCreatedVolumes only allows for the initial volume")
+	}
+
+	// Calculate the size increase per volume, rounding down to the nearest GB
+	// Subtract 1 from MaxCreatedVolumes to account for the initial volume already created
+	return int32(math.Floor(float64(difference) / float64(state.MaxCreatedVolumes-1))), nil
+}
+
+// FixedSizing grows by the same size every time
+type FixedSizing struct {
+	SizeGb int32
+}
+
+func (f FixedSizing) NextSizeGb(_ SizingState) (int32, error) {
+	return f.SizeGb, nil
+}
+
+// PercentSizing grows by a percentage of the current size, so the filesystem grows geometrically. The size is rounded
+// up to the next Gb.
+type PercentSizing struct {
+	Percent float64
+}
+
+func (p PercentSizing) NextSizeGb(state SizingState) (int32, error) {
+	current := state.CurrentSizeGb
+	if current <= 0 {
+		current = state.InitialSizeGb
+	}
+	return int32(math.Max(1, math.Ceil(float64(current)*p.Percent/100))), nil
+}
+
+// StepSizing grows by each size of the table in turn, the last size is repeated once the table is exhausted. The step
+// is chosen by how much the filesystem has already grown beyond its initial size, so it survives restarts and works
+// with either grow strategy.
+type StepSizing struct {
+	StepsGb []int32
+}
+
+func (s StepSizing) NextSizeGb(state SizingState) (int32, error) {
+	grown := state.CurrentSizeGb - state.InitialSizeGb
+	added := int32(0)
+	for _, step := range s.StepsGb {
+		added += step
+		if grown < added {
+			return step, nil
+		}
+	}
+	return s.StepsGb[len(s.StepsGb)-1], nil
+}
+
+// TargetUsageSizing grows by the size needed to bring the usage of the filesystem back down to TargetPc
+type TargetUsageSizing struct {
+	TargetPc float64
+}
+
+func (t TargetUsageSizing) NextSizeGb(state SizingState) (int32, error) {
+	total, used, _, err := state.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	requiredBytes := float64(used)/(t.TargetPc/100) - float64(total)
+	if requiredBytes <= 0 {
+		return 0, fmt.Errorf("TargetUsageSizing: usage is already at or under the target of %.1f%%: %w", t.TargetPc, errNothingToAdd)
+	}
+	return int32(math.Ceil(requiredBytes / (1 << 30))), nil
+}
+
+// requiredNumberOption returns the numeric option, which the yaml and json decoders produce as either int or float64
+func requiredNumberOption(options map[string]interface{}, key string) (float64, error) {
+	value, ok := options[key]
+	if !ok {
+		return 0, fmt.Errorf("sizing option %s is required", key)
+	}
+	n, ok := toNumber(value)
+	if !ok {
+		return 0, fmt.Errorf("sizing option %s must be a number: %v", key, value)
+	}
+	return n, nil
+}
+
+func toNumber(value interface{}) (float64, bool) {
+	switch n := value.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
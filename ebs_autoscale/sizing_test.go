@@ -0,0 +1,167 @@
+package ebs_autoscale
+
+import (
+	"fmt"
+	"testing"
+)
+
+type TestSizingPolicyInputs struct {
+	Name    string
+	Type    string
+	Options map[string]interface{}
+	// CurrentSizeGb the combined size of the managed volumes
+	CurrentSizeGb int32
+	// Used the used bytes of the 100Gb filesystem
+	Used     uint64
+	Expected int32
+	Error    bool
+}
+
+func TestSizingPolicy(t *testing.T) {
+
+	gb := uint64(1 << 30)
+
+	tests := []TestSizingPolicyInputs{
+		{
+			Name:          "Even",
+			Type:          "even",
+			CurrentSizeGb: 100,
+			Expected:      75, // (200 - 50) / (3 - 1) = 75
+		},
+		{
+			Name:          "Fixed",
+			Type:          "fixed",
+			Options:       map[string]interface{}{"size-gb": 20},
+			CurrentSizeGb: 100,
+			Expected:      20,
+		},
+		{
+			Name:          "Fixed without a size",
+			Type:          "fixed",
+			Options:       map[string]interface{}{},
+			CurrentSizeGb: 100,
+			Error:         true,
+		},
+		{
+			Name:          "Percent of the current size",
+			Type:          "percent",
+			Options:       map[string]interface{}{"percent": 50.0},
+			CurrentSizeGb: 100,
+			Expected:      50,
+		},
+		{
+			Name:          "Percent rounds up",
+			Type:          "percent",
+			Options:       map[string]interface{}{"percent": 10},
+			CurrentSizeGb: 55,
+			Expected:      6,
+		},
+		{
+			Name:          "First step",
+			Type:          "steps",
+			Options:       map[string]interface{}{"steps-gb": []interface{}{10, 20, 40}},
+			CurrentSizeGb: 50,
+			Expected:      10,
+		},
+		{
+			Name:          "Third step",
+			Type:          "steps",
+			Options:       map[string]interface{}{"steps-gb": []interface{}{10, 20, 40}},
+			CurrentSizeGb: 80,
+			Expected:      40,
+		},
+		{
+			Name:          "Last step repeats",
+			Type:          "steps",
+			Options:       map[string]interface{}{"steps-gb": []interface{}{10, 20, 40}},
+			CurrentSizeGb: 160,
+			Expected:      40,
+		},
+		{
+			Name:          "Steps must be sizes",
+			Type:          "steps",
+			Options:       map[string]interface{}{"steps-gb": []interface{}{10, "large"}},
+			CurrentSizeGb: 50,
+			Error:         true,
+		},
+		{
+			Name:          "Fill to target usage",
+			Type:          "target-usage",
+			Options:       map[string]interface{}{"target-pc": 60},
+			CurrentSizeGb: 100,
+			Used:          90 * gb,
+			Expected:      50, // 90Gb used at 60% needs 150Gb
+		},
+		{
+			Name:          "Already under target usage falls back to even",
+			Type:          "target-usage",
+			Options:       map[string]interface{}{"target-pc": 60},
+			CurrentSizeGb: 100,
+			Used:          40 * gb,
+			Expected:      75,
+		},
+		{
+			Name:          "Clamped to the max size",
+			Type:          "fixed",
+			Options:       map[string]interface{}{"size-gb": 80},
+			CurrentSizeGb: 150,
+			Expected:      50,
+		},
+		{
+			Name:          "Max size reached",
+			Type:          "fixed",
+			Options:       map[string]interface{}{"size-gb": 80},
+			CurrentSizeGb: 200,
+			Error:         true,
+		},
+		{
+			Name:  "Unknown policy",
+			Type:  "exponential",
+			Error: true,
+		},
+	}
+
+	for _, i := range tests {
+
+		got, err := func() (int32, error) {
+			sizing, err := GetSizingPolicy(i.Type, i.Options)
+			if err != nil {
+				return 0, err
+			}
+			volume := defaultVolume
+			volume.InitialSizeGb = 50
+			volume.MaxLogicalSizeGb = 200
+			volume.MaxCreatedVolumes = 3
+			volume.ManagedVolumes = []ManagedVolume{{VolumeId: "vol-1", SizeGb: i.CurrentSizeGb}}
+			volume.Sizing = sizing
+			total, used, free := 100*gb, i.Used, 100*gb-i.Used
+			volume.Fs = mockFS{Size: &total, Used: &used, Free: &free}
+			return volume.calculateSizeIncreasePerVolume()
+		}()
+
+		if (err == nil) == i.Error {
+			t.Errorf("calculateSizeIncreasePerVolume(%s) Returned an unexpected error: %s", i.Name, err)
+		}
+		if got != i.Expected {
+			t.Errorf("calculateSizeIncreasePerVolume(%s) Expected: %d Got: %d", i.Name, i.Expected, got)
+		}
+	}
+}
+
+func TestGetSizingPolicyOptions(t *testing.T) {
+
+	// numbers may be decoded as int or float64
+	for _, n := range []interface{}{20, 20.0, int64(20)} {
+		sizing, err := GetSizingPolicy("fixed", map[string]interface{}{"size-gb": n})
+		if err != nil {
+			t.Fatalf("GetSizingPolicy(%T) Returned an unexpected error: %s", n, err)
+		}
+		if sizing.(FixedSizing).SizeGb != 20 {
+			t.Errorf("GetSizingPolicy(%T) Expected: 20 Got: %s", n, fmt.Sprint(sizing))
+		}
+	}
+
+	if _, err := GetSizingPolicy("target-usage", map[string]interface{}{"target-pc": 100}); err == nil {
+		t.Errorf("GetSizingPolicy Expected an error for a target-pc of 100")
+	}
+}
@@ -0,0 +1,93 @@
+package ebs_autoscale
+
+import (
+	"context"
+	"github.com/BobTheTerrible/ebs-autoscale/ebs_autoscale/filesystem"
+)
+
+// VolumeStatus describes a managed filesystem, its volumes and the room it has left to grow
+type VolumeStatus struct {
+	Id         string `json:"id"`
+	MountPoint string `json:"mount-point"`
+	Backend    string `json:"backend"`
+	// Filesystem the usage of the filesystem, nil when it could not be read i.e. it is not mounted
+	Filesystem      *FilesystemStatus `json:"filesystem"`
+	FilesystemError string            `json:"filesystem-error,omitempty"`
+	ManagedVolumes  []ManagedVolume   `json:"managed-volumes"`
+	Headroom        Headroom          `json:"headroom"`
+	// NextGrowSizeGb the size of the volume the next grow will add, nil when the volume cannot grow
+	NextGrowSizeGb *int32 `json:"next-grow-size-gb"`
+	NextGrowError  string `json:"next-grow-error,omitempty"`
+	Drift          *Drift `json:"drift,omitempty"`
+}
+
+// FilesystemStatus the usage of the filesystem in bytes
+type FilesystemStatus struct {
+	TotalBytes uint64 `json:"total-bytes"`
+	UsedBytes  uint64 `json:"used-bytes"`
+	FreeBytes  uint64 `json:"free-bytes"`
+	// Usage the detailed usage of the filesystem, nil when the backend could not report it
+	Usage           *filesystem.Usage `json:"usage,omitempty"`
+	MetadataPercent *float32          `json:"metadata-percent,omitempty"`
+}
+
+// Headroom the room left under the configured limits
+type Headroom struct {
+	SizeGb           int32 `json:"size-gb"`
+	MaxLogicalSizeGb int32 `json:"max-logical-size-gb"`
+	Volumes          int32 `json:"volumes"`
+	MaxVolumes       int32 `json:"max-volumes"`
+}
+
+// Status reconciles the managed volumes and reports them along with the filesystem usage and the room left to grow.
+// The filesystem of the given backend type need not be mounted.
+func (v *Volume) Status(ctx context.Context, backend string) (*VolumeStatus, error) {
+
+	drift, err := v.Reconcile(ctx)
+	if err != nil {
+		// the devices of a filesystem that is not mounted cannot be listed, report the volumes regardless
+		managed, err2 := listManagedVolumes(ctx, v.Provider, v.Host, v.Id)
+		if err2 != nil {
+			return nil, err2
+		}
+		v.ManagedVolumes = managed
+		drift = nil
+	}
+
+	status := VolumeStatus{
+		Id:             v.Id,
+		MountPoint:     v.Fs.GetMountPoint(),
+		Backend:        backend,
+		ManagedVolumes: v.ManagedVolumes,
+		Headroom: Headroom{
+			SizeGb:           v.MaxLogicalSizeGb - v.managedVolumeSizeGb(),
+			MaxLogicalSizeGb: v.MaxLogicalSizeGb,
+			Volumes:          v.MaxCreatedVolumes - int32(len(v.ManagedVolumes)),
+			MaxVolumes:       v.MaxCreatedVolumes,
+		},
+	}
+	if drift != nil && drift.HasDrift() {
+		status.Drift = drift
+	}
+
+	total, used, free, err := v.Fs.Stat()
+	if err != nil {
+		status.FilesystemError = err.Error()
+	} else {
+		status.Filesystem = &FilesystemStatus{TotalBytes: total, UsedBytes: used, FreeBytes: free}
+		if usage, err := v.Fs.Usage(); err == nil && usage != nil {
+			metadataPc := usage.MetadataPercent()
+			status.Filesystem.Usage = usage
+			status.Filesystem.MetadataPercent = &metadataPc
+		}
+	}
+
+	size, err := v.calculateSizeIncreasePerVolume()
+	if err != nil {
+		status.NextGrowError = err.Error()
+	} else {
+		status.NextGrowSizeGb = &size
+	}
+
+	return &status, nil
+}
@@ -0,0 +1,66 @@
+package ebs_autoscale
+
+import (
+	"context"
+	"fmt"
+	"github.com/BobTheTerrible/ebs-autoscale/ebs_autoscale/awsfake"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"gotest.tools/assert"
+	"testing"
+)
+
+func TestStatus(t *testing.T) {
+
+	fake := awsfake.NewEc2()
+	volume := newFakeVolume(t, fake)
+	putManagedVolume(fake, "vol-1", volume.Id, volume.Host.InstanceId, "/dev/xvdba")
+	volume.ManagedVolumes = []ManagedVolume{{VolumeId: "vol-1"}}
+	volume.Fs = mockFS{
+		Size:       aws.Uint64(200),
+		Used:       aws.Uint64(50),
+		Free:       aws.Uint64(150),
+		MountPoint: aws.String("/mnt/mock"),
+		DeviceList: []string{"/dev/xvdba"},
+	}
+
+	status, err := volume.Status(context.Background(), "btrfs")
+	if err != nil {
+		t.Fatalf("Status Returned an unexpected error: %s", err)
+	}
+
+	assert.Equal(t, status.Id, volume.Id)
+	assert.Equal(t, status.MountPoint, "/mnt/mock")
+	assert.Equal(t, status.Backend, "btrfs")
+	assert.DeepEqual(t, volumeIds(status.ManagedVolumes), []string{"vol-1"})
+	assert.DeepEqual(t, *status.Filesystem, FilesystemStatus{TotalBytes: 200, UsedBytes: 50, FreeBytes: 150})
+	// putManagedVolume creates 50Gb volumes, newFakeVolume allows 200Gb across 3 volumes
+	assert.DeepEqual(t, status.Headroom, Headroom{SizeGb: 150, MaxLogicalSizeGb: 200, Volumes: 2, MaxVolumes: 3})
+	assert.Equal(t, *status.NextGrowSizeGb, int32(75))
+	assert.Assert(t, status.Drift == nil)
+}
+
+func TestStatusUnmountedFilesystem(t *testing.T) {
+
+	fake := awsfake.NewEc2()
+	volume := newFakeVolume(t, fake)
+	volume.MaxCreatedVolumes = 1
+	putManagedVolume(fake, "vol-1", volume.Id, volume.Host.InstanceId, "/dev/xvdba")
+	volume.Fs = mockFS{
+		Size:       aws.Uint64(0),
+		Used:       aws.Uint64(0),
+		Free:       aws.Uint64(0),
+		MountPoint: aws.String("/mnt/mock"),
+		Err:        fmt.Errorf("not mounted"),
+	}
+
+	status, err := volume.Status(context.Background(), "btrfs")
+	if err != nil {
+		t.Fatalf("Status Returned an unexpected error: %s", err)
+	}
+
+	assert.DeepEqual(t, volumeIds(status.ManagedVolumes), []string{"vol-1"})
+	assert.Assert(t, status.Filesystem == nil)
+	assert.Equal(t, status.FilesystemError, "not mounted")
+	assert.Assert(t, status.NextGrowSizeGb == nil)
+	assert.Assert(t, status.NextGrowError != "")
+}
@@ -0,0 +1,66 @@
+package ebs_autoscale
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const defaultGrowCooldownSec = 60
+
+// rearm re-arms the tiers once the usage has fallen below RearmPc since the last grow
+func (m *MonitorVolume) rearm(usage float32) {
+
+	if m.disarmedBelow > 0 && usage < m.RearmPc {
+		slog.Info(fmt.Sprintf("rearm: usage (%f) fell below the rearm threshold (%f), re-armed: %s", usage, m.RearmPc, m.Volume.Fs.GetMountPoint()))
+		m.disarmedBelow = 0
+	}
+}
+
+// suppressed returns why a grow at the given time is not allowed, or an empty string when it is. A grow is suppressed
+// while the circuit breaker is open, within CooldownSec of the last grow, or once MaxGrowsPerHour or MaxGrowsPerDay
+// have been made.
+func (m *MonitorVolume) suppressed(now time.Time) string {
+
+	if open, retryAt := m.breakerOpen(now); open {
+		return fmt.Sprintf("circuit breaker open after %d failed grow(s) until %s", m.growFailures, retryAt.Format(time.RFC3339))
+	}
+
+	// grows older than a day no longer count towards any limit
+	keep := 0
+	for keep < len(m.grows) && now.Sub(m.grows[keep]) >= 24*time.Hour {
+		keep++
+	}
+	m.grows = m.grows[keep:]
+
+	if len(m.grows) > 0 {
+		cooldown := time.Duration(m.CooldownSec) * time.Second
+		if since := now.Sub(m.grows[len(m.grows)-1]); since < cooldown {
+			return fmt.Sprintf("in cooldown for another %s", (cooldown - since).Round(time.Second))
+		}
+	}
+
+	lastHour := 0
+	for _, grown := range m.grows {
+		if now.Sub(grown) < time.Hour {
+			lastHour++
+		}
+	}
+	if m.MaxGrowsPerHour > 0 && lastHour >= int(m.MaxGrowsPerHour) {
+		return fmt.Sprintf("%d grows in the last hour reached max-grows-per-hour", lastHour)
+	}
+	if m.MaxGrowsPerDay > 0 && len(m.grows) >= int(m.MaxGrowsPerDay) {
+		return fmt.Sprintf("%d grows in the last day reached max-grows-per-day", len(m.grows))
+	}
+	return ""
+}
+
+// recordGrow counts a grow attempt towards the cooldown and limits. When RearmPc is set, the tiers up to the one the
+// usage is within are disarmed, a higher tier still triggers.
+func (m *MonitorVolume) recordGrow(now time.Time) {
+
+	m.grows = append(m.grows, now)
+	if m.RearmPc > 0 {
+		m.disarmedBelow = max(m.disarmedBelow, m.tier+1)
+	}
+}
@@ -0,0 +1,80 @@
+package ebs_autoscale
+
+import (
+	"gotest.tools/assert"
+	"testing"
+	"time"
+)
+
+// tick is a monitor assessment made At after the first, with the filesystem UsedPc full
+type tick struct {
+	At     time.Duration
+	UsedPc uint64
+}
+
+type TestMonitorGrowLimitsInputs struct {
+	Name            string
+	CooldownSec     int32
+	RearmPc         float32
+	MaxGrowsPerHour int32
+	MaxGrowsPerDay  int32
+	Ticks           []tick
+	ExpectedGrows   int
+}
+
+func TestMonitorGrowLimits(t *testing.T) {
+
+	tests := []TestMonitorGrowLimitsInputs{
+		{
+			Name:          "No limits grows every tick",
+			Ticks:         []tick{{0, 90}, {time.Second, 90}, {2 * time.Second, 90}},
+			ExpectedGrows: 3,
+		},
+		{
+			Name:          "Cooldown",
+			CooldownSec:   60,
+			Ticks:         []tick{{0, 90}, {30 * time.Second, 90}, {61 * time.Second, 90}},
+			ExpectedGrows: 2,
+		},
+		{
+			Name:            "Max grows per hour",
+			MaxGrowsPerHour: 2,
+			Ticks:           []tick{{0, 90}, {10 * time.Minute, 90}, {20 * time.Minute, 90}, {61 * time.Minute, 90}},
+			ExpectedGrows:   3,
+		},
+		{
+			Name:           "Max grows per day",
+			MaxGrowsPerDay: 2,
+			Ticks:          []tick{{0, 90}, {2 * time.Hour, 90}, {4 * time.Hour, 90}, {25 * time.Hour, 90}},
+			ExpectedGrows:  3,
+		},
+		{
+			Name:          "Not re-armed while usage stays high",
+			RearmPc:       40,
+			Ticks:         []tick{{0, 90}, {time.Minute, 90}, {2 * time.Minute, 45}, {3 * time.Minute, 90}},
+			ExpectedGrows: 1,
+		},
+		{
+			Name:          "Re-armed once usage falls",
+			RearmPc:       40,
+			Ticks:         []tick{{0, 90}, {time.Minute, 30}, {2 * time.Minute, 90}},
+			ExpectedGrows: 2,
+		},
+	}
+
+	for _, i := range tests {
+
+		monitor := newTestMonitor(t, 100)
+		monitor.CooldownSec = i.CooldownSec
+		monitor.RearmPc = i.RearmPc
+		monitor.MaxGrowsPerHour = i.MaxGrowsPerHour
+		monitor.MaxGrowsPerDay = i.MaxGrowsPerDay
+
+		for _, tk := range i.Ticks {
+			monitor.at(tk.At)
+			monitor.setUsed(tk.UsedPc)
+			monitor.tick(t, i.Name)
+		}
+		assert.Equal(t, len(monitor.fake.Volumes()), i.ExpectedGrows, i.Name)
+	}
+}
@@ -0,0 +1,81 @@
+package ebs_autoscale
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Tier is a usage threshold of the monitor, with the sizing of the grows it triggers
+type Tier struct {
+	ThresholdPc float32
+	// Sizing when set replaces the volume's sizing policy for the grows the tier triggers
+	Sizing SizingPolicy
+	// PollInterval when set replaces the monitor's poll interval while the usage is within the tier
+	PollInterval time.Duration
+}
+
+// NewTiers builds the monitor tiers of the config, ordered by threshold. A tier sized by size-gb grows by that fixed
+// size.
+func NewTiers(cfgs []TierCfg) ([]Tier, error) {
+
+	tiers := make([]Tier, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		if cfg.ThresholdPc <= 0 || cfg.ThresholdPc > 100 {
+			return nil, fmt.Errorf("NewTiers: threshold-pc must be between 0 and 100: %f", cfg.ThresholdPc)
+		}
+		if cfg.SizeGb != 0 && cfg.Sizing != nil {
+			return nil, fmt.Errorf("NewTiers: the %f tier sets both size-gb and sizing", cfg.ThresholdPc)
+		}
+
+		if cfg.Interval < 0 {
+			return nil, fmt.Errorf("NewTiers: the %f tier interval must not be negative: %s", cfg.ThresholdPc, time.Duration(cfg.Interval))
+		}
+
+		tier := Tier{ThresholdPc: cfg.ThresholdPc, PollInterval: time.Duration(cfg.Interval)}
+		switch {
+		case cfg.SizeGb < 0:
+			return nil, fmt.Errorf("NewTiers: the %f tier size-gb must be at least 1: %d", cfg.ThresholdPc, cfg.SizeGb)
+		case cfg.SizeGb > 0:
+			tier.Sizing = FixedSizing{SizeGb: cfg.SizeGb}
+		case cfg.Sizing != nil:
+			sizing, err := GetSizingPolicy(cfg.Sizing.Type, cfg.Sizing.Options)
+			if err != nil {
+				return nil, err
+			}
+			tier.Sizing = sizing
+		}
+		tiers = append(tiers, tier)
+	}
+
+	sort.Slice(tiers, func(i, j int) bool {
+		return tiers[i].ThresholdPc < tiers[j].ThresholdPc
+	})
+	for i := 1; i < len(tiers); i++ {
+		if tiers[i].ThresholdPc == tiers[i-1].ThresholdPc {
+			return nil, fmt.Errorf("NewTiers: more than one tier has the threshold %f", tiers[i].ThresholdPc)
+		}
+	}
+	return tiers, nil
+}
+
+// tiers returns the configured tiers, or the single PercentageFull threshold when there are none
+func (m *MonitorVolume) tiers() []Tier {
+
+	if len(m.Tiers) > 0 {
+		return m.Tiers
+	}
+	return []Tier{{ThresholdPc: m.PercentageFull}}
+}
+
+// matchTier returns the index of the highest tier the usage has reached, -1 when it is under every threshold
+func (m *MonitorVolume) matchTier(usage float32) int {
+
+	tiers := m.tiers()
+	for i := len(tiers) - 1; i >= 0; i-- {
+		if usage >= tiers[i].ThresholdPc {
+			return i
+		}
+	}
+	return -1
+}
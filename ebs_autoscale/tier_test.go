@@ -0,0 +1,135 @@
+package ebs_autoscale
+
+import (
+	"gotest.tools/assert"
+	"testing"
+	"time"
+)
+
+type TestNewTiersInputs struct {
+	Name     string
+	Cfgs     []TierCfg
+	Expected []Tier
+	Error    bool
+}
+
+func TestNewTiers(t *testing.T) {
+
+	tests := []TestNewTiersInputs{
+		{
+			Name:     "No tiers",
+			Cfgs:     nil,
+			Expected: []Tier{},
+		},
+		{
+			Name: "Ordered by threshold",
+			Cfgs: []TierCfg{
+				{ThresholdPc: 90, SizeGb: 200, Interval: Duration(time.Second)},
+				{ThresholdPc: 70, Sizing: &SizingCfg{Type: "percent", Options: map[string]interface{}{"percent": 10}}},
+			},
+			Expected: []Tier{
+				{ThresholdPc: 70, Sizing: PercentSizing{Percent: 10}},
+				{ThresholdPc: 90, Sizing: FixedSizing{SizeGb: 200}, PollInterval: time.Second},
+			},
+		},
+		{
+			Name:  "Both size and sizing",
+			Cfgs:  []TierCfg{{ThresholdPc: 70, SizeGb: 10, Sizing: &SizingCfg{Type: "even"}}},
+			Error: true,
+		},
+		{
+			Name:  "Unknown sizing",
+			Cfgs:  []TierCfg{{ThresholdPc: 70, Sizing: &SizingCfg{Type: "exponential"}}},
+			Error: true,
+		},
+		{
+			Name:  "Threshold out of range",
+			Cfgs:  []TierCfg{{ThresholdPc: 0}},
+			Error: true,
+		},
+		{
+			Name:  "Negative interval",
+			Cfgs:  []TierCfg{{ThresholdPc: 70, Interval: Duration(-time.Second)}},
+			Error: true,
+		},
+		{
+			Name:  "Duplicate threshold",
+			Cfgs:  []TierCfg{{ThresholdPc: 70}, {ThresholdPc: 70, SizeGb: 10}},
+			Error: true,
+		},
+	}
+
+	for _, i := range tests {
+
+		got, err := NewTiers(i.Cfgs)
+
+		if (err == nil) == i.Error {
+			t.Errorf("NewTiers(%s) Returned an unexpected error: %s", i.Name, err)
+		}
+		if !i.Error {
+			assert.DeepEqual(t, got, i.Expected)
+		}
+	}
+}
+
+type TestMonitorTiersInputs struct {
+	Name    string
+	RearmPc float32
+	// UsedPc the usage of the filesystem at each tick
+	UsedPc []uint64
+	// ExpectedSizes the sizes of the volumes created
+	ExpectedSizes []int32
+	// ExpectedInterval the poll interval after the last tick
+	ExpectedInterval time.Duration
+}
+
+func TestMonitorTiers(t *testing.T) {
+
+	tiers := []Tier{
+		{ThresholdPc: 70, Sizing: FixedSizing{SizeGb: 10}},
+		{ThresholdPc: 90, Sizing: FixedSizing{SizeGb: 100}, PollInterval: time.Second},
+	}
+
+	tests := []TestMonitorTiersInputs{
+		{
+			Name:             "Under every tier",
+			UsedPc:           []uint64{60},
+			ExpectedSizes:    []int32{},
+			ExpectedInterval: 5 * time.Second,
+		},
+		{
+			Name:             "Lower tier",
+			UsedPc:           []uint64{75},
+			ExpectedSizes:    []int32{10},
+			ExpectedInterval: 5 * time.Second,
+		},
+		{
+			Name:             "Highest tier reached",
+			UsedPc:           []uint64{95},
+			ExpectedSizes:    []int32{100},
+			ExpectedInterval: time.Second,
+		},
+		{
+			Name:             "Escalates past a disarmed tier",
+			RearmPc:          50,
+			UsedPc:           []uint64{75, 80, 92, 95},
+			ExpectedSizes:    []int32{10, 100},
+			ExpectedInterval: time.Second,
+		},
+	}
+
+	for _, i := range tests {
+
+		monitor := newTestMonitor(t, 100)
+		monitor.PollInterval = 5 * time.Second
+		monitor.Tiers = tiers
+		monitor.RearmPc = i.RearmPc
+
+		for _, pc := range i.UsedPc {
+			monitor.setUsed(pc)
+			monitor.tick(t, i.Name)
+		}
+		assert.DeepEqual(t, monitor.sizes(), i.ExpectedSizes)
+		assert.Equal(t, monitor.pollInterval(), i.ExpectedInterval, i.Name)
+	}
+}
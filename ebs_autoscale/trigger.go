@@ -0,0 +1,107 @@
+package ebs_autoscale
+
+import (
+	"fmt"
+	"github.com/BobTheTerrible/ebs-autoscale/ebs_autoscale/filesystem"
+	"log/slog"
+	"time"
+)
+
+const (
+	// TriggerAny grows when any of the configured triggers fires
+	TriggerAny = "any"
+	// TriggerAll grows only when every configured trigger fires
+	TriggerAll = "all"
+
+	defaultTriggerMode = TriggerAny
+)
+
+// evaluateTriggers evaluates the usage threshold, MinFreeGb, MinHoursToFull, MetadataThresholdPc and InodeThresholdPc
+// triggers, all but the first only when set. It returns the reasons of the triggers that fired, and whether they
+// trigger a grow under TriggerMode. The inodes only trigger a grow when the filesystem reports a fixed number of them
+// and growing adds inodes, see alertInodes. Otherwise they do not count towards the triggers TriggerAll waits for.
+func (m *MonitorVolume) evaluateTriggers(usage float32, free uint64, detail *filesystem.Usage) ([]string, bool) {
+
+	var fired []string
+	configured := 1
+
+	if m.tier >= 0 && m.tier >= m.disarmedBelow {
+		fired = append(fired, fmt.Sprintf("usage threshold (%f) exceeded (%f)", m.tiers()[m.tier].ThresholdPc, usage))
+	}
+
+	if m.MinFreeGb > 0 {
+		configured++
+		if free < uint64(m.MinFreeGb)<<30 {
+			fired = append(fired, fmt.Sprintf("free space (%dGb) under min-free-gb (%d)", free>>30, m.MinFreeGb))
+		}
+	}
+
+	if m.MinHoursToFull > 0 {
+		configured++
+		minTimeToFull := time.Duration(float64(m.MinHoursToFull) * float64(time.Hour))
+		if timeToFull, ok := m.fillRate.TimeToFull(free); ok && timeToFull < minTimeToFull {
+			fired = append(fired, fmt.Sprintf("projected to fill in %s, under min-hours-to-full (%g)", timeToFull.Round(time.Second), m.MinHoursToFull))
+		}
+	}
+
+	if m.MetadataThresholdPc > 0 {
+		configured++
+		if metadataPc := detail.MetadataPercent(); metadataPc >= m.MetadataThresholdPc {
+			fired = append(fired, fmt.Sprintf("metadata threshold (%f) exceeded (%f)", m.MetadataThresholdPc, metadataPc))
+		}
+	}
+
+	if m.InodeThresholdPc > 0 && detail.Inodes > 0 && m.growAddsInodes() {
+		configured++
+		if inodePc := detail.InodePercent(); inodePc >= m.InodeThresholdPc {
+			fired = append(fired, fmt.Sprintf("inode threshold (%f) exceeded (%f)", m.InodeThresholdPc, inodePc))
+		}
+	}
+
+	if m.TriggerMode == TriggerAll {
+		return fired, len(fired) == configured
+	}
+	return fired, len(fired) > 0
+}
+
+// detailedUsage returns the detailed usage of the filesystem when a trigger needs it, otherwise an empty report
+func (m *MonitorVolume) detailedUsage() (*filesystem.Usage, error) {
+
+	if m.MetadataThresholdPc <= 0 && m.InodeThresholdPc <= 0 {
+		return &filesystem.Usage{}, nil
+	}
+	usage, err := m.Volume.Fs.Usage()
+	if err != nil {
+		return nil, err
+	}
+	if usage == nil {
+		return &filesystem.Usage{}, nil
+	}
+	return usage, nil
+}
+
+// growAddsInodes whether growing the filesystem adds inodes, file systems allocating inodes on demand are assumed to
+func (m *MonitorVolume) growAddsInodes() bool {
+
+	limited, ok := m.Volume.Fs.(filesystem.InodeLimited)
+	return !ok || limited.GrowAddsInodes()
+}
+
+// alertInodes logs an error once the inode usage reaches InodeThresholdPc on a filesystem that growing does not add
+// inodes to, so running out can only be alerted on. The alert is repeated once the usage has fallen back and risen
+// again.
+func (m *MonitorVolume) alertInodes(detail *filesystem.Usage) {
+
+	if m.InodeThresholdPc <= 0 || m.growAddsInodes() {
+		return
+	}
+	inodePc := detail.InodePercent()
+	if inodePc < m.InodeThresholdPc {
+		m.inodeAlerted = false
+		return
+	}
+	if !m.inodeAlerted {
+		slog.Error(fmt.Sprintf("alertInodes: inode threshold (%f) exceeded (%f), growing does not add inodes: %s", m.InodeThresholdPc, inodePc, m.Volume.Fs.GetMountPoint()))
+		m.inodeAlerted = true
+	}
+}
@@ -0,0 +1,153 @@
+package ebs_autoscale
+
+import (
+	"github.com/BobTheTerrible/ebs-autoscale/ebs_autoscale/filesystem"
+	"gotest.tools/assert"
+	"testing"
+	"time"
+)
+
+// inodeLimitedFS is a mockFS with a limited number of inodes
+type inodeLimitedFS struct {
+	mockFS
+	addsInodes bool
+}
+
+func (i inodeLimitedFS) GrowAddsInodes() bool {
+	return i.addsInodes
+}
+
+type TestMonitorTriggersInputs struct {
+	Name           string
+	MinFreeGb      int32
+	MinHoursToFull float32
+	TriggerMode    string
+	// MetadataThresholdPc and the MetadataPc the filesystem reports
+	MetadataThresholdPc float32
+	MetadataPc          uint64
+	// InodeThresholdPc and the InodePc the filesystem reports, InodeLimited whether growing it does not add inodes
+	InodeThresholdPc float32
+	InodePc          uint64
+	InodeLimited     bool
+	// UsedGb the used Gb of the 1000Gb filesystem at each tick, an hour apart
+	UsedGb        []uint64
+	ExpectedGrows int
+}
+
+func TestMonitorTriggers(t *testing.T) {
+
+	volumeAvailableTimeout = time.Second
+	deviceAvailableTimeout = 200 * time.Millisecond
+
+	// the usage threshold is 50%
+	tests := []TestMonitorTriggersInputs{
+		{
+			Name:          "Threshold only",
+			UsedGb:        []uint64{600},
+			ExpectedGrows: 1,
+		},
+		{
+			Name:          "Any fires on free space under the threshold",
+			MinFreeGb:     700,
+			UsedGb:        []uint64{400},
+			ExpectedGrows: 1,
+		},
+		{
+			Name:          "All needs the free space as well",
+			MinFreeGb:     300,
+			TriggerMode:   TriggerAll,
+			UsedGb:        []uint64{600},
+			ExpectedGrows: 0,
+		},
+		{
+			Name:          "All fires once every trigger does",
+			MinFreeGb:     300,
+			TriggerMode:   TriggerAll,
+			UsedGb:        []uint64{750},
+			ExpectedGrows: 1,
+		},
+		{
+			// 100Gb an hour leaves 6 hours with 600Gb free
+			Name:           "Any fires on time to full",
+			MinHoursToFull: 8,
+			UsedGb:         []uint64{300, 400},
+			ExpectedGrows:  1,
+		},
+		{
+			Name:                "Any fires on metadata pressure",
+			MetadataThresholdPc: 80,
+			MetadataPc:          90,
+			UsedGb:              []uint64{400},
+			ExpectedGrows:       1,
+		},
+		{
+			Name:                "Metadata with room",
+			MetadataThresholdPc: 80,
+			MetadataPc:          40,
+			UsedGb:              []uint64{400},
+			ExpectedGrows:       0,
+		},
+		{
+			Name:             "Any fires on inode pressure",
+			InodeThresholdPc: 80,
+			InodePc:          90,
+			UsedGb:           []uint64{400},
+			ExpectedGrows:    1,
+		},
+		{
+			Name:             "Inode pressure only alerts when growing does not add inodes",
+			InodeThresholdPc: 80,
+			InodePc:          90,
+			InodeLimited:     true,
+			UsedGb:           []uint64{400, 400},
+			ExpectedGrows:    0,
+		},
+		{
+			Name:             "Inodes without a fixed number never fire",
+			InodeThresholdPc: 80,
+			UsedGb:           []uint64{400},
+			ExpectedGrows:    0,
+		},
+		{
+			Name:             "All does not wait on inodes without a fixed number",
+			InodeThresholdPc: 80,
+			TriggerMode:      TriggerAll,
+			UsedGb:           []uint64{600},
+			ExpectedGrows:    1,
+		},
+		{
+			Name:           "Time to full needs a fill rate",
+			MinHoursToFull: 8,
+			UsedGb:         []uint64{300, 300},
+			ExpectedGrows:  0,
+		},
+	}
+
+	for _, i := range tests {
+
+		gb := uint64(1 << 30)
+		monitor := newTestMonitor(t, 1000*gb)
+		monitor.Volume.MaxLogicalSizeGb = 2000
+		monitor.fs.Report = &filesystem.Usage{Metadata: filesystem.SpaceUsage{Allocated: 100, Used: i.MetadataPc}}
+		if i.InodePc > 0 {
+			monitor.fs.Report.Inodes, monitor.fs.Report.InodesFree = 100, 100-i.InodePc
+		}
+		monitor.Volume.Fs = monitor.fs
+		if i.InodeLimited {
+			monitor.Volume.Fs = inodeLimitedFS{mockFS: monitor.fs}
+		}
+		monitor.MinFreeGb = i.MinFreeGb
+		monitor.MinHoursToFull = i.MinHoursToFull
+		monitor.TriggerMode = i.TriggerMode
+		monitor.MetadataThresholdPc = i.MetadataThresholdPc
+		monitor.InodeThresholdPc = i.InodeThresholdPc
+		monitor.FillRateWindowSec = 2 * 3600
+
+		for n, usedGb := range i.UsedGb {
+			monitor.at(time.Duration(n) * time.Hour)
+			monitor.setUsed(usedGb * gb)
+			monitor.tick(t, i.Name)
+		}
+		assert.Equal(t, len(monitor.fake.Volumes()), i.ExpectedGrows, i.Name)
+	}
+}
@@ -28,3 +28,13 @@ func Md5String(s string) string {
 	md5String := md5.Sum([]byte(s)) //nolint:golint,gosec
 	return hex.EncodeToString(md5String[:])
 }
+
+// containsString reports whether the list holds the given string
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
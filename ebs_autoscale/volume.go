@@ -4,12 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/ec2"
-	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/BobTheTerrible/ebs-autoscale/ebs_autoscale/filesystem"
-	"math"
+	"log/slog"
 	"os"
 	"strings"
 	"time"
@@ -18,6 +14,7 @@ import (
 type Volume struct {
 	Host               Ec2Host
 	Fs                 filesystem.FileSystem
+	Provider           BlockProvider
 	Id                 string
 	EbsType            string
 	ThroughPut         *int32
@@ -26,74 +23,117 @@ type Volume struct {
 	MaxLogicalSizeGb   int32
 	MaxAttachedVolumes int32
 	MaxCreatedVolumes  int32
-	ManagedVolumes     []types.Volume
-	ec2Client          ec2.Client
+	ManagedVolumes     []ManagedVolume
+	// LastDrift the drift found by the most recent Reconcile, nil until it has run
+	LastDrift *Drift
+	// Journal records in-flight operations so they can be replayed after a crash, nil disables journaling
+	Journal *Journal
+	// RepairPolicy decides how Repair handles managed volumes outside the filesystem: adopt|remove|report
+	RepairPolicy string
+	// GrowStrategy decides how GrowVolume adds space: attach|modify
+	GrowStrategy string
+	// Sizing decides how much space each grow adds, nil splits the room left evenly, see EvenSizing
+	Sizing SizingPolicy
+	// Persistent volumes are not deleted on termination and are tagged with FilesystemName rather than the instance
+	Persistent     bool
+	FilesystemName string
+	// devicePrefix is the prefix used when selecting the next logical device, see getNextLogicalDevice
+	devicePrefix string
 }
 
 var (
-	volumeTypes map[string]any
+	// volumeAvailableTimeout is the maximum time to wait for a created or detached volume to become available
+	volumeAvailableTimeout = 20 * time.Second
+	// volumeDeletedTimeout is the maximum time to wait for a deleted volume to be gone, polling every
+	// volumeDeletedPollInterval
+	volumeDeletedTimeout      = 60 * time.Second
+	volumeDeletedPollInterval = 2 * time.Second
+	// volumeModifiedTimeout is the maximum time to wait for a volume modification to reach the optimizing state,
+	// polling every volumeModifiedPollInterval
+	volumeModifiedTimeout      = 5 * time.Minute
+	volumeModifiedPollInterval = 5 * time.Second
+	// deviceAvailableTimeout is the maximum time to wait for an attached volume to appear under /dev
+	deviceAvailableTimeout = 50 * time.Second
 )
 
-func init() {
-	volumeTypes = map[string]any{
-		"io1": types.VolumeTypeIo1,
-		"io2": types.VolumeTypeIo2,
-		"gp3": types.VolumeTypeGp3,
-	}
-}
+const (
+	defaultDevicePrefix = "/dev/xvdb"
+	// autoscaleIdTag identifies the volumes of a filesystem
+	autoscaleIdTag = "ebs-autoscale-id"
+	// sourceInstanceTag identifies the host a volume was created by
+	sourceInstanceTag = "source-instance"
+	// filesystemNameTag identifies the volumes of a persistent filesystem
+	filesystemNameTag = "ebs-autoscale-filesystem"
+)
+
+// NewVolume builds the Volume for the given configuration using the configured block provider. The ec2 endpoint can be
+// overridden by awsCfg.
+func NewVolume(ctx context.Context, host Ec2Host, fs filesystem.FileSystem, cfg VolumeCfg, awsCfg AwsCfg) (*Volume, error) {
 
-func NewVolume(ctx context.Context, host Ec2Host, fs filesystem.FileSystem, cfg VolumeCfg) (*Volume, error) {
+	providerType, options := "ebs", map[string]interface{}{}
+	if cfg.Provider != nil && cfg.Provider.Type != "" {
+		providerType, options = cfg.Provider.Type, cfg.Provider.Options
+	}
 
-	// Get the region from the Host instance. Use this for subsequent aws calls
-	awsConfig, err := config.LoadDefaultConfig(ctx, config.WithDefaultRegion(host.Region))
+	provider, err := GetProvider(ctx, providerType, host, options, awsCfg)
 	if err != nil {
 		return nil, err
 	}
 
-	ec2Client := ec2.NewFromConfig(awsConfig)
+	return newVolume(ctx, provider, host, fs, cfg)
+}
 
-	// Get a list of all attached volumes
-	attachedVolumesOutput, err := ec2Client.DescribeVolumes(ctx, &ec2.DescribeVolumesInput{
-		Filters: []types.Filter{
-			{
-				Name: aws.String("attachment.instance-id"),
-				Values: []string{
-					host.InstanceId,
-				},
-			},
-		},
-	})
+// newVolume builds the Volume using the given BlockProvider, discovering any volumes already managed for the mount
+// point
+func newVolume(ctx context.Context, provider BlockProvider, host Ec2Host, fs filesystem.FileSystem, cfg VolumeCfg) (*Volume, error) {
+
+	// Find the volumes created by this config that are attached to this host. Persistent volumes are identified by the
+	// filesystem name, as they move between hosts.
+	ebsAutoscaleId := Md5String(fs.GetMountPoint())
+	if cfg.Persistent {
+		if cfg.FilesystemName == "" {
+			return nil, errors.New("newVolume: persistent mode requires a filesystem-name")
+		}
+		ebsAutoscaleId = Md5String(cfg.FilesystemName)
+	}
+	managedVolumes, err := listManagedVolumes(ctx, provider, host, ebsAutoscaleId)
 	if err != nil {
 		return nil, err
 	}
 
-	attachedVolumes := attachedVolumesOutput.Volumes
+	devicePrefix := cfg.DevicePrefix
+	if devicePrefix == "" {
+		devicePrefix = defaultDevicePrefix
+	}
 
-	// Find the volumes created by this config
-	ebsAutoscaleId := Md5String(fs.GetMountPoint())
-	managedVolumes := make([]types.Volume, 0)
-	for _, v := range attachedVolumes {
-		for _, t := range v.Tags {
-			if *t.Key == "ebs-autoscale-id" && *t.Value == ebsAutoscaleId {
-				managedVolumes = append(managedVolumes, v)
-				break
-			}
-		}
+	sizingType, sizingOptions := defaultSizingPolicy, map[string]interface{}{}
+	if cfg.Sizing != nil && cfg.Sizing.Type != "" {
+		sizingType, sizingOptions = cfg.Sizing.Type, cfg.Sizing.Options
+	}
+	sizing, err := GetSizingPolicy(sizingType, sizingOptions)
+	if err != nil {
+		return nil, err
 	}
 
 	v := Volume{
 		Host:               host,
 		Fs:                 fs,
+		Provider:           provider,
 		Id:                 ebsAutoscaleId,
 		EbsType:            cfg.EbsType,
 		ThroughPut:         cfg.EbsThroughput,
 		Iops:               cfg.EbsIops,
-		InitialSizeGb:      cfg.InitialSizeGb,  // Set initial size from config
+		InitialSizeGb:      cfg.InitialSizeGb, // Set initial size from config
 		MaxLogicalSizeGb:   cfg.MaxSizeGb,
 		MaxAttachedVolumes: cfg.EbsMaxAttachedVolumes,
 		MaxCreatedVolumes:  cfg.EbsMaxCreatedVolumes,
 		ManagedVolumes:     managedVolumes,
-		ec2Client:          *ec2Client,
+		RepairPolicy:       cfg.RepairPolicy,
+		GrowStrategy:       cfg.GrowStrategy,
+		Sizing:             sizing,
+		Persistent:         cfg.Persistent,
+		FilesystemName:     cfg.FilesystemName,
+		devicePrefix:       devicePrefix,
 	}
 
 	return &v, nil
@@ -104,7 +144,7 @@ func (v Volume) managedVolumeSizeGb() int32 {
 
 	totalVolumeSize := int32(0)
 	for _, mv := range v.ManagedVolumes {
-		totalVolumeSize += *mv.Size
+		totalVolumeSize += mv.SizeGb
 	}
 	return totalVolumeSize
 }
@@ -112,69 +152,179 @@ func (v Volume) managedVolumeSizeGb() int32 {
 // TotalUsagePercent returns the usage as a percentage
 func (v Volume) TotalUsagePercent() (float32, error) {
 
-	usagePercent := float32(0)
-
 	total, used, _, err := v.Fs.Stat()
 	if err != nil {
-		return usagePercent, err
+		return 0, err
 	}
+	return usagePercent(total, used), nil
+}
+
+// usagePercent returns the used bytes as a percentage of the total, 0 for an empty filesystem
+func usagePercent(total uint64, used uint64) float32 {
 
 	if total == 0 {
-		return usagePercent, nil
+		return 0
 	}
-	usagePercent = (float32(used) / float32(total)) * 100
-	return usagePercent, nil
+	return (float32(used) / float32(total)) * 100
 }
 
-// CreateVolume creates the volume and filesystem for the given configuration
-func (v *Volume) CreateVolume(ctx context.Context) error {
+// CreateVolume creates the volume and filesystem for the given configuration. When managed volumes already exist the
+// filesystem found on them is mounted instead, it is never reformatted. In persistent mode the detached volumes of the
+// filesystem are attached first. forceNew destroys the existing volumes and creates the filesystem afresh.
+func (v *Volume) CreateVolume(ctx context.Context, forceNew bool) error {
 
-	device, err := v.createAndAttachEbsVolume(ctx, v.InitialSizeGb)
+	// an operation left in the journal by a failure is released for the next start to replay
+	defer v.Journal.Unlock()
+
+	if v.Persistent {
+		if err := v.attachPersistentSet(ctx); err != nil {
+			return err
+		}
+	}
+
+	if len(v.ManagedVolumes) > 0 {
+		if !forceNew {
+			return v.mountExisting(ctx)
+		}
+		slog.Warn(fmt.Sprintf("CreateVolume: destroying the existing filesystem at %s", v.Fs.GetMountPoint()))
+		if err := v.Destroy(ctx, false, false); err != nil {
+			return err
+		}
+	}
+
+	device, err := v.createAndAttachEbsVolume(ctx, operationInit, v.InitialSizeGb)
 	if err != nil {
 		return err
 	}
+	// A failure here leaves the journal in place, the next start will retry creating the filesystem
 	err = v.Fs.CreateFileSystem(*device)
 	if err != nil {
 		return err
 	}
 
+	v.Journal.Complete()
 	return nil
 }
 
-// GrowVolume grows the volume by the given amount
+// mountExisting mounts the filesystem found on the managed volumes. An error is returned if none of their devices
+// carry a filesystem signature, rather than formatting them.
+func (v *Volume) mountExisting(ctx context.Context) error {
+
+	for _, mv := range v.ManagedVolumes {
+		localDevice, err := v.Provider.ResolveDevice(ctx, mv)
+		if err != nil {
+			return err
+		}
+		found, err := v.Fs.HasFileSystem(localDevice)
+		if err != nil {
+			return err
+		}
+		if found {
+			slog.Info(fmt.Sprintf("CreateVolume: mounting the existing filesystem from %s", localDevice))
+			return v.Fs.MountFileSystem(localDevice)
+		}
+	}
+	return fmt.Errorf("CreateVolume: %d managed volume(s) exist without a filesystem, use force-new to recreate it", len(v.ManagedVolumes))
+}
+
+// GrowVolume grows the volume by the size decided by the sizing policy. Under the modify strategy a managed volume is
+// enlarged in place, unless none can be, in which case a new volume is attached as under the attach strategy.
 func (v *Volume) GrowVolume(ctx context.Context) error {
+	return v.GrowVolumeWith(ctx, nil, 0)
+}
+
+// GrowVolumeWith grows the volume as GrowVolume does, sized by the given sizing policy in place of the volume's when
+// set, and by no less than minSizeGb where there is room for it under MaxLogicalSizeGb
+func (v *Volume) GrowVolumeWith(ctx context.Context, sizing SizingPolicy, minSizeGb int32) error {
+
+	// an operation left in the journal by a failure is released for the next start to replay
+	defer v.Journal.Unlock()
+
+	strategy := v.GrowStrategy
+	if strategy == "" {
+		strategy = defaultGrowStrategy
+	}
+	if strategy != GrowStrategyAttach && strategy != GrowStrategyModify {
+		return fmt.Errorf("GrowVolume: unknown grow strategy: %s", strategy)
+	}
+
 	// Calculate the total available size to grow
-	sizeIncreasePerVolume, err := v.calculateSizeIncreasePerVolume()
+	if sizing == nil {
+		sizing = v.Sizing
+	}
+	sizeIncreasePerVolume, err := v.calculateSizeIncrease(sizing)
 	if err != nil {
 		return err
 	}
+	if sizeIncreasePerVolume < minSizeGb {
+		sizeIncreasePerVolume = min(minSizeGb, v.MaxLogicalSizeGb-v.managedVolumeSizeGb())
+	}
+
+	if strategy == GrowStrategyModify {
+		grown, err := v.growInPlace(ctx, sizeIncreasePerVolume)
+		if err != nil || grown {
+			return err
+		}
+		slog.Info("GrowVolume: no volume can be modified, attaching a new volume")
+	}
 
 	// Attach a new ebs volume by the calculated size increase
-	device, err := v.createAndAttachEbsVolume(ctx, sizeIncreasePerVolume)
+	device, err := v.createAndAttachEbsVolume(ctx, operationGrow, sizeIncreasePerVolume)
 	if err != nil {
 		return err
 	}
 
-	// After attaching, expand the filesystem across the new device
+	// After attaching, expand the filesystem across the new device. A failure here leaves the journal in place, the
+	// next start will retry the grow.
 	err = v.Fs.GrowFileSystem(*device)
 	if err != nil {
 		return err
 	}
 
+	v.Journal.Complete()
 	return nil
 }
 
-// calculateSizeIncreasePerVolume calculates the increase in size per volume, taking into account the max size and the initial volume.
+// calculateSizeIncreasePerVolume calculates the size the next grow adds using the volume's sizing policy
 func (v *Volume) calculateSizeIncreasePerVolume() (int32, error) {
-	difference := v.MaxLogicalSizeGb - v.InitialSizeGb
-	if difference <= 0 {
-		return 0, fmt.Errorf("calculateSizeIncreasePerVolume: Cannot grow, the volume size is already at or beyond max size")
+	return v.calculateSizeIncrease(v.Sizing)
+}
+
+// calculateSizeIncrease calculates the size the next grow adds using the given sizing policy, clamped to the room
+// left under MaxLogicalSizeGb
+func (v *Volume) calculateSizeIncrease(sizing SizingPolicy) (int32, error) {
+
+	remaining := v.MaxLogicalSizeGb - v.managedVolumeSizeGb()
+	if remaining <= 0 {
+		return 0, fmt.Errorf("calculateSizeIncreasePerVolume: Cannot grow, the volume size is already at or beyond max size: %w", errNothingToAdd)
 	}
 
-	// Calculate the size increase per volume, rounding down to the nearest GB
-	// Subtract 1 from MaxCreatedVolumes to account for the initial volume already created
-	sizeIncreasePerVolume := int32(math.Floor(float64(difference) / float64(v.MaxCreatedVolumes-1)))
-	return sizeIncreasePerVolume, nil
+	if sizing == nil {
+		sizing = EvenSizing{}
+	}
+	state := SizingState{
+		CurrentSizeGb:     v.managedVolumeSizeGb(),
+		InitialSizeGb:     v.InitialSizeGb,
+		MaxLogicalSizeGb:  v.MaxLogicalSizeGb,
+		MaxCreatedVolumes: v.MaxCreatedVolumes,
+		Stat: func() (uint64, uint64, uint64, error) {
+			return v.Fs.Stat()
+		},
+	}
+	size, err := sizing.NextSizeGb(state)
+	// a trigger other than the usage, i.e. min-free-gb, can fire while the policy sees no need for more space
+	if _, even := sizing.(EvenSizing); errors.Is(err, errNothingToAdd) && !even {
+		slog.Info(fmt.Sprintf("calculateSizeIncreasePerVolume: falling back to even sizing: %s", err))
+		size, err = EvenSizing{}.NextSizeGb(state)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if size > remaining {
+		slog.Info(fmt.Sprintf("calculateSizeIncreasePerVolume: clamping %dGb to the %dGb left under max size", size, remaining))
+		size = remaining
+	}
+	return size, nil
 }
 
 // getNextLogicalDevice attempts to determine the next Dev name for a given range of values i.e. /dev/xvda to /dev/xvdz
@@ -184,7 +334,7 @@ func (v Volume) getNextLogicalDevice() (*string, error) {
 
 		//use /dev/xvdb* device names to avoid contention for /dev/sd* and /dev/xvda names
 
-		device := "/dev/xvdb" + string(i)
+		device := v.devicePrefix + string(i)
 
 		b, err := isAvailable(device)
 		if err != nil {
@@ -215,16 +365,17 @@ func isAvailable(path string) (bool, error) {
 	return false, fmt.Errorf("isAvailable: unexpected error from os.Stat: %w", err)
 }
 
-// createAndAttachEbsVolume will create and attach an ebs volume of the given size and expand the filesystem across it
-func (v *Volume) createAndAttachEbsVolume(ctx context.Context, sizeGb int32) (*string, error) {
+// createAndAttachEbsVolume will create and attach a volume of the given size through the block provider, returning the
+// local device once it is available
+func (v *Volume) createAndAttachEbsVolume(ctx context.Context, operation string, sizeGb int32) (*string, error) {
 
 	volSize := v.managedVolumeSizeGb()
 	if volSize > v.MaxLogicalSizeGb {
-		return nil, fmt.Errorf("createAndAttachEbsVolume: MaxLogicalSizeGb exceeded: max:%dGb observed:%dGb", v.MaxLogicalSizeGb, volSize)
+		return nil, fmt.Errorf("createAndAttachEbsVolume: MaxLogicalSizeGb exceeded: max:%dGb observed:%dGb: %w", v.MaxLogicalSizeGb, volSize, errNothingToAdd)
 	}
 
 	if int32(len(v.ManagedVolumes)) == v.MaxCreatedVolumes {
-		return nil, fmt.Errorf("createAndAttachEbsVolume: MaxCreatedVolumes reached: max:%d observed:%d", v.MaxCreatedVolumes, len(v.ManagedVolumes))
+		return nil, fmt.Errorf("createAndAttachEbsVolume: MaxCreatedVolumes reached: max:%d observed:%d: %w", v.MaxCreatedVolumes, len(v.ManagedVolumes), errNothingToAdd)
 	}
 
 	// Get a list of all attached volumes - this could have changed since we last looked
@@ -233,7 +384,7 @@ func (v *Volume) createAndAttachEbsVolume(ctx context.Context, sizeGb int32) (*s
 		return nil, err
 	}
 	if !c {
-		return nil, fmt.Errorf("createAndAttachEbsVolume: MaxAttachedVolumes exceeded: max:%d observed:%d", v.MaxAttachedVolumes, totalVolumes)
+		return nil, fmt.Errorf("createAndAttachEbsVolume: MaxAttachedVolumes exceeded: max:%d observed:%d: %w", v.MaxAttachedVolumes, totalVolumes, errNothingToAdd)
 	}
 
 	device, err := v.getNextLogicalDevice()
@@ -241,115 +392,83 @@ func (v *Volume) createAndAttachEbsVolume(ctx context.Context, sizeGb int32) (*s
 		return nil, err
 	}
 
-	ec2Client := v.ec2Client
-
-	vol, err := ec2Client.CreateVolume(ctx, &ec2.CreateVolumeInput{
-		AvailabilityZone: aws.String(v.Host.AvailabilityZone),
-		VolumeType:       volumeTypes[v.EbsType].(types.VolumeType),
-		Size:             &sizeGb,
-		Iops:             v.Iops,
-		Throughput:       v.ThroughPut,
-		Encrypted:        nil,
-		TagSpecifications: []types.TagSpecification{
-			{
-				ResourceType: types.ResourceTypeVolume,
-				Tags:         v.buildVolumeTags(time.Now),
-			},
-		},
-	})
+	// Each step is journaled so a crash part way through can be finished or rolled back, see Replay
+	err = v.Journal.Begin(operation, sizeGb)
 	if err != nil {
 		return nil, err
 	}
 
-	// wait till volume is available....
-	volWaiter := ec2.NewVolumeAvailableWaiter(&ec2Client)
-
-	err = volWaiter.Wait(ctx, &ec2.DescribeVolumesInput{
-		VolumeIds: []string{*vol.VolumeId},
-	}, 20*time.Second)
+	mv, err := v.Provider.CreateVolume(ctx, VolumeSpec{
+		SizeGb:           sizeGb,
+		Type:             v.EbsType,
+		Iops:             v.Iops,
+		Throughput:       v.ThroughPut,
+		AvailabilityZone: v.Host.AvailabilityZone,
+		Tags:             v.buildVolumeTags(time.Now),
+	})
 	if err != nil {
-		// there is a problem describing the new volume, clean it up
-		err2 := v.removeVolume(ctx, *vol.VolumeId)
-		if err2 != nil {
-			return nil, errors.Join(err, err2)
+		if mv != nil {
+			// there is a problem with the new volume, clean it up
+			v.Journal.Step(StepCreated, func(e *JournalEntry) { e.VolumeId = mv.VolumeId })
+			err2 := v.removeVolume(ctx, mv.VolumeId, false)
+			if err2 != nil {
+				return nil, errors.Join(err, err2)
+			}
 		}
+		v.Journal.Complete()
 		return nil, err
 	}
+	v.Journal.Step(StepCreated, func(e *JournalEntry) { e.VolumeId = mv.VolumeId })
 
-	_, err = ec2Client.AttachVolume(ctx, &ec2.AttachVolumeInput{
-		Device:     device,
-		InstanceId: aws.String(v.Host.InstanceId),
-		VolumeId:   vol.VolumeId,
-	})
+	// Attach the volume and, unless persistent, set it to be deleted on termination
+	err = v.Provider.AttachVolume(ctx, mv.VolumeId, *device, !v.Persistent)
 	if err != nil {
-		// there is a problem attaching the new volume, clean it up
-		err2 := v.removeVolume(ctx, *vol.VolumeId)
+		// there is a problem attaching the new volume, the provider leaves it detached so clean it up
+		err2 := v.removeVolume(ctx, mv.VolumeId, false)
 		if err2 != nil {
 			return nil, errors.Join(err, err2)
 		}
+		v.Journal.Complete()
 		return nil, err
 	}
+	mv.AttachedTo = v.Host.InstanceId
+	mv.Device = *device
+	mv.DeleteOnTermination = !v.Persistent
+	v.Journal.Step(StepAttached, func(e *JournalEntry) { e.Device = *device })
 
-	v.ManagedVolumes = append(v.ManagedVolumes, createVolumeOutputToVolume(*vol))
-
-	// Set the volume to be deleted on termination
-	_, err = ec2Client.ModifyInstanceAttribute(ctx, &ec2.ModifyInstanceAttributeInput{
-		InstanceId: aws.String(v.Host.InstanceId),
-		BlockDeviceMappings: []types.InstanceBlockDeviceMappingSpecification{
-			{
-				DeviceName: device,
-				Ebs: &types.EbsInstanceBlockDeviceSpecification{
-					DeleteOnTermination: aws.Bool(true),
-					VolumeId:            vol.VolumeId,
-				},
-			},
-		},
-	})
+	// Wait till the device is actually available on the host....
+	localDevice, err := v.Provider.ResolveDevice(ctx, *mv)
 	if err != nil {
-		// if there is a problem marking the new volume for deletion, clean it up
-		err2 := v.removeVolume(ctx, *vol.VolumeId)
+		// the device never appeared so the filesystem cannot use it, clean it up
+		err2 := v.removeVolume(ctx, mv.VolumeId, true)
 		if err2 != nil {
 			return nil, errors.Join(err, err2)
 		}
+		v.Journal.Complete()
 		return nil, err
 	}
+	v.Journal.Step(StepResolved, func(e *JournalEntry) { e.LocalDevice = localDevice })
 
-	// Wait till the device is actually available in /dev....
-	err = localVolAvailabilityWaiter(ctx, *device, 50*time.Second)
-	if err != nil {
-		return nil, err
-	}
+	// Only record the volume once every step has succeeded, failed attempts have been removed above
+	v.ManagedVolumes = append(v.ManagedVolumes, *mv)
 
-	return device, nil
+	return &localDevice, nil
 }
 
-// removeVolume removes an attached volume from the instance. This is a best effort process to be used when an error
-// occurs when attaching a volume.
-func (v Volume) removeVolume(ctx context.Context, volumeId string) error {
-
-	ec2Client := v.ec2Client
+// removeVolume removes a volume, detaching it from the instance first if it may be attached. This is a best effort
+// process to be used when an error occurs when attaching a volume.
+func (v Volume) removeVolume(ctx context.Context, volumeId string, attached bool) error {
 
 	var errList []error
 
-	_, err := ec2Client.DetachVolume(ctx, &ec2.DetachVolumeInput{
-		VolumeId: aws.String(volumeId),
-	})
-	if err != nil {
-		errList = append(errList, err)
-	}
-
-	// wait till volume is available....
-	volWaiter := ec2.NewVolumeAvailableWaiter(&ec2Client)
-	err = volWaiter.Wait(ctx, &ec2.DescribeVolumesInput{
-		VolumeIds: []string{volumeId},
-	}, 20*time.Second)
-	if err != nil {
-		errList = append(errList, err)
+	if attached {
+		err := v.Provider.DetachVolume(ctx, volumeId)
+		if err != nil {
+			errList = append(errList, err)
+		}
 	}
 
-	_, err = ec2Client.DeleteVolume(ctx, &ec2.DeleteVolumeInput{
-		VolumeId: aws.String(volumeId),
-	})
+	err := v.Provider.DeleteVolume(ctx, volumeId)
 	if err != nil {
 		errList = append(errList, err)
 	}
@@ -358,25 +477,15 @@ func (v Volume) removeVolume(ctx context.Context, volumeId string) error {
 	return errors.Join(errList...)
 }
 
-// instanceHasCapacity checks to see if we have reached the maximum number of ebs volumes this instance can accept.
-// Returns true if the instance has capacity and the count of observed ebs volumes
+// instanceHasCapacity checks to see if we have reached the maximum number of volumes this instance can accept.
+// Returns true if the instance has capacity and the count of observed volumes
 func (v Volume) instanceHasCapacity(ctx context.Context) (bool, int, error) {
 
-	attachedVolumesOutput, err := v.ec2Client.DescribeVolumes(ctx, &ec2.DescribeVolumesInput{
-		Filters: []types.Filter{
-			{
-				Name: aws.String("attachment.instance-id"),
-				Values: []string{
-					v.Host.InstanceId,
-				},
-			},
-		},
-	})
+	count, err := v.Provider.AttachedVolumeCount(ctx)
 	if err != nil {
 		return false, 0, err
 	}
 
-	count := len(attachedVolumesOutput.Volumes)
 	if int32(count) > v.MaxAttachedVolumes {
 		return false, count, nil
 	}
@@ -406,63 +515,51 @@ func localVolAvailabilityWaiter(ctx context.Context, device string, timeoutLimit
 			}
 			ticker.Reset(50 * time.Millisecond)
 		case <-ctxTimeout.Done():
-			return fmt.Errorf("localVolAvailabilityWaiter: waiting for device: %s appears to have timed out", device)
+			return fmt.Errorf("localVolAvailabilityWaiter: waiting for device: %s appears to have %w", device, errTimedOut)
 		}
 	}
 }
 
-// buildVolumeTags builds a set of volume tags for the volume
-func (v Volume) buildVolumeTags(now func() time.Time) []types.Tag {
+// buildVolumeTags builds a set of volume tags for the volume. Persistent volumes are tagged with the filesystem name
+// in place of the instance.
+func (v Volume) buildVolumeTags(now func() time.Time) []Tag {
 
-	volumeTags := []types.Tag{
+	volumeTags := []Tag{
 		{
-			Key:   aws.String("source-instance"),
-			Value: aws.String(v.Host.InstanceId),
+			Key:   sourceInstanceTag,
+			Value: v.Host.InstanceId,
 		},
 		{
-			Key:   aws.String("source-instance-arn"),
-			Value: aws.String(v.Host.InstanceArn),
+			Key:   "source-instance-arn",
+			Value: v.Host.InstanceArn,
 		},
-		{
-			Key:   aws.String("ebs-autoscale-id"),
-			Value: aws.String(v.Id),
+	}
+	if v.Persistent {
+		volumeTags = []Tag{
+			{
+				Key:   filesystemNameTag,
+				Value: v.FilesystemName,
+			},
+		}
+	}
+
+	volumeTags = append(volumeTags,
+		Tag{
+			Key:   autoscaleIdTag,
+			Value: v.Id,
 		},
-		{
-			Key:   aws.String("ebs-autoscale-creation-time"),
-			Value: aws.String(now().String()),
+		Tag{
+			Key:   "ebs-autoscale-creation-time",
+			Value: now().String(),
 		},
-	}
+	)
 
 	// AWS does not allow us to use any tags that begin with 'aws:'
 	for _, t := range v.Host.Tags {
-		if !strings.HasPrefix(*t.Key, "aws:") {
+		if !strings.HasPrefix(t.Key, "aws:") {
 			volumeTags = append(volumeTags, t)
 		}
 	}
 
 	return volumeTags
 }
-
-// createVolumeOutputToVolume performs a type conversion from ec2.CreateVolumeOutput to types.Volume
-func createVolumeOutputToVolume(o ec2.CreateVolumeOutput) types.Volume {
-
-	return types.Volume{
-		Attachments:        o.Attachments,
-		AvailabilityZone:   o.AvailabilityZone,
-		CreateTime:         o.CreateTime,
-		Encrypted:          o.Encrypted,
-		FastRestored:       o.FastRestored,
-		Iops:               o.Iops,
-		KmsKeyId:           o.KmsKeyId,
-		MultiAttachEnabled: o.MultiAttachEnabled,
-		OutpostArn:         o.OutpostArn,
-		Size:               o.Size,
-		SnapshotId:         o.SnapshotId,
-		SseType:            o.SseType,
-		State:              o.State,
-		Tags:               o.Tags,
-		Throughput:         o.Throughput,
-		VolumeId:           o.VolumeId,
-		VolumeType:         o.VolumeType,
-	}
-}
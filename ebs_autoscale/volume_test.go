@@ -1,12 +1,17 @@
 package ebs_autoscale
 
 import (
+	"context"
 	"fmt"
+	"github.com/BobTheTerrible/ebs-autoscale/ebs_autoscale/awsfake"
+	"github.com/BobTheTerrible/ebs-autoscale/ebs_autoscale/filesystem"
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
-	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"gotest.tools/assert"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -22,34 +27,28 @@ var defaultVolume = Volume{
 	MaxAttachedVolumes: 0,
 	MaxCreatedVolumes:  0,
 	ManagedVolumes:     nil,
-	ec2Client:          ec2.Client{},
+	Provider:           nil,
 }
 
-var defaultEbsVolume = types.Volume{
-	Attachments:        nil,
-	AvailabilityZone:   nil,
-	CreateTime:         nil,
-	Encrypted:          nil,
-	FastRestored:       nil,
-	Iops:               nil,
-	KmsKeyId:           nil,
-	MultiAttachEnabled: nil,
-	OutpostArn:         nil,
-	Size:               nil,
-	SnapshotId:         nil,
-	SseType:            "",
-	State:              "",
-	Tags:               nil,
-	Throughput:         nil,
-	VolumeId:           nil,
-	VolumeType:         "",
+var defaultEbsVolume = ManagedVolume{
+	VolumeId:         "",
+	SizeGb:           0,
+	Type:             "",
+	Iops:             nil,
+	Throughput:       nil,
+	AvailabilityZone: "",
+	State:            "",
+	Tags:             nil,
 }
 
 type mockFS struct {
 	Size       *uint64
 	Used       *uint64
 	Free       *uint64
+	Report     *filesystem.Usage
 	MountPoint *string
+	DeviceList []string
+	HasFs      bool
 	Err        error
 }
 
@@ -69,6 +68,30 @@ func (t mockFS) GetMountPoint() string {
 	return *t.MountPoint
 }
 
+func (t mockFS) Devices() ([]string, error) {
+	return t.DeviceList, t.Err
+}
+
+func (t mockFS) HasFileSystem(device string) (bool, error) {
+	return t.HasFs, t.Err
+}
+
+func (t mockFS) MountFileSystem(device string) error {
+	return t.Err
+}
+
+func (t mockFS) DestroyFileSystem(force bool) error {
+	return t.Err
+}
+
+func (t mockFS) ResizeDevice(device string) error {
+	return t.Err
+}
+
+func (t mockFS) Usage() (*filesystem.Usage, error) {
+	return t.Report, t.Err
+}
+
 type TestManagedVolumeSizeGbInputs struct {
 	Name     string
 	Volume   Volume
@@ -82,8 +105,8 @@ func TestManagedVolumeSizeGb(t *testing.T) {
 			Expected: 51,
 			Volume: func(volume Volume) Volume {
 				vol1 := defaultEbsVolume
-				vol1.Size = aws.Int32(51)
-				volume.ManagedVolumes = []types.Volume{
+				vol1.SizeGb = 51
+				volume.ManagedVolumes = []ManagedVolume{
 					vol1,
 				}
 				return volume
@@ -94,10 +117,10 @@ func TestManagedVolumeSizeGb(t *testing.T) {
 			Expected: 61,
 			Volume: func(volume Volume) Volume {
 				vol1 := defaultEbsVolume
-				vol1.Size = aws.Int32(51)
+				vol1.SizeGb = 51
 				vol2 := defaultEbsVolume
-				vol2.Size = aws.Int32(10)
-				volume.ManagedVolumes = []types.Volume{
+				vol2.SizeGb = 10
+				volume.ManagedVolumes = []ManagedVolume{
 					vol1, vol2,
 				}
 				return volume
@@ -107,7 +130,7 @@ func TestManagedVolumeSizeGb(t *testing.T) {
 			Name:     "No Managed Volumes",
 			Expected: 0,
 			Volume: func(volume Volume) Volume {
-				volume.ManagedVolumes = []types.Volume{}
+				volume.ManagedVolumes = []ManagedVolume{}
 				return volume
 			}(defaultVolume),
 		},
@@ -210,7 +233,7 @@ func TestTotalUsagePercent(t *testing.T) {
 type TestBuildVolumeTagsInputs struct {
 	Name     string
 	Volume   Volume
-	Expected []types.Tag
+	Expected []Tag
 }
 
 func TestBuildVolumeTags(t *testing.T) {
@@ -223,57 +246,82 @@ func TestBuildVolumeTags(t *testing.T) {
 	tests := []TestBuildVolumeTagsInputs{
 		{
 			Name: "Expected tags from Volume",
-			Expected: []types.Tag{
+			Expected: []Tag{
 				{
-					Key:   aws.String("source-instance"),
-					Value: aws.String("bob"),
+					Key:   "source-instance",
+					Value: "bob",
 				},
 				{
-					Key:   aws.String("source-instance-arn"),
-					Value: aws.String("arn:bob"),
+					Key:   "source-instance-arn",
+					Value: "arn:bob",
 				},
 				{
-					Key:   aws.String("ebs-autoscale-id"),
-					Value: aws.String("vol_id"),
+					Key:   "ebs-autoscale-id",
+					Value: "vol_id",
 				},
 				{
-					Key:   aws.String("ebs-autoscale-creation-time"),
-					Value: aws.String(actualNow.String()),
+					Key:   "ebs-autoscale-creation-time",
+					Value: actualNow.String(),
 				},
 				{
-					Key:   aws.String("HostName"),
-					Value: aws.String("Mock Host Name 1"),
+					Key:   "HostName",
+					Value: "Mock Host Name 1",
 				},
 				{
-					Key:   aws.String("HostLabel"),
-					Value: aws.String("Mock Host label 1"),
+					Key:   "HostLabel",
+					Value: "Mock Host label 1",
 				},
 			},
 			Volume: func(volume Volume) Volume {
 				volume.Host.InstanceId = "bob"
 				volume.Host.InstanceArn = "arn:bob"
 				volume.Id = "vol_id"
-				volume.Host.Tags = []types.Tag{
+				volume.Host.Tags = []Tag{
 					{
-						Key:   aws.String("HostName"),
-						Value: aws.String("Mock Host Name 1"),
+						Key:   "HostName",
+						Value: "Mock Host Name 1",
 					},
 					{
-						Key:   aws.String("aws:HostLabel"),
-						Value: aws.String("This should be excluded because 'aws:' tags are not allowed"),
+						Key:   "aws:HostLabel",
+						Value: "This should be excluded because 'aws:' tags are not allowed",
 					},
 					{
-						Key:   aws.String("HostLabel"),
-						Value: aws.String("Mock Host label 1"),
+						Key:   "HostLabel",
+						Value: "Mock Host label 1",
 					},
 				}
 				return volume
 			}(defaultVolume),
 		},
+		{
+			Name: "Persistent volume is tagged with the filesystem name",
+			Expected: []Tag{
+				{
+					Key:   "ebs-autoscale-filesystem",
+					Value: "batch-scratch",
+				},
+				{
+					Key:   "ebs-autoscale-id",
+					Value: "vol_id",
+				},
+				{
+					Key:   "ebs-autoscale-creation-time",
+					Value: actualNow.String(),
+				},
+			},
+			Volume: func(volume Volume) Volume {
+				volume.Host.InstanceId = "bob"
+				volume.Host.InstanceArn = "arn:bob"
+				volume.Id = "vol_id"
+				volume.Persistent = true
+				volume.FilesystemName = "batch-scratch"
+				return volume
+			}(defaultVolume),
+		},
 	}
 
 	for _, i := range tests {
-		assert.DeepEqual(t, i.Volume.buildVolumeTags(now), i.Expected, cmp.AllowUnexported(types.Tag{}))
+		assert.DeepEqual(t, i.Volume.buildVolumeTags(now), i.Expected)
 	}
 
 }
@@ -335,3 +383,389 @@ func TestCalculateSizeIncreasePerVolume(t *testing.T) {
 		}
 	}
 }
+
+// newFakeVolume returns a Volume using the ebs provider backed by the in-memory ec2 fake. Attached devices are simulated
+// as files under a temporary directory.
+func newFakeVolume(t *testing.T, fake *awsfake.Ec2) Volume {
+
+	deviceDir := t.TempDir()
+	fake.OnAttach = func(v types.Volume, instanceId string, device string) error {
+		return os.WriteFile(device, []byte{}, 0600)
+	}
+	fake.OnDetach = func(v types.Volume, instanceId string, device string) error {
+		return os.Remove(device)
+	}
+
+	volume := defaultVolume
+	volume.Host = Ec2Host{
+		InstanceId:       "i-0123456789abcdef0",
+		InstanceArn:      "arn:aws:ec2:ap-southeast-2:123456789012:instance/i-0123456789abcdef0",
+		AvailabilityZone: "ap-southeast-2a",
+		Region:           "ap-southeast-2",
+	}
+	volume.Id = "vol_id"
+	volume.EbsType = "gp3"
+	volume.InitialSizeGb = 50
+	volume.MaxLogicalSizeGb = 200
+	volume.MaxAttachedVolumes = 16
+	volume.MaxCreatedVolumes = 3
+	volume.ManagedVolumes = []ManagedVolume{}
+	volume.Provider = NewEbsProvider(fake, volume.Host)
+	volume.devicePrefix = filepath.Join(deviceDir, "xvdb")
+	return volume
+}
+
+type TestCreateAndAttachEbsVolumeInputs struct {
+	Name string
+	// Setup prepares the fake and volume before the call
+	Setup func(fake *awsfake.Ec2, volume *Volume)
+	// ExpectedCalls the ec2 operations invoked, in order
+	ExpectedCalls []string
+	// ExpectedVolumes the number of volumes remaining in ec2
+	ExpectedVolumes int
+	// ExpectedManaged the number of volumes recorded in ManagedVolumes
+	ExpectedManaged int
+	Error           bool
+}
+
+func TestCreateAndAttachEbsVolume(t *testing.T) {
+
+	volumeAvailableTimeout = time.Second
+	deviceAvailableTimeout = 200 * time.Millisecond
+
+	mockErr := fmt.Errorf("mock error")
+	// A volume that failed to attach only needs deleting, once attached it is detached first
+	deleteCalls := []string{"DeleteVolume"}
+	cleanupCalls := []string{"DetachVolume", "DescribeVolumes", "DeleteVolume"}
+
+	tests := []TestCreateAndAttachEbsVolumeInputs{
+		{
+			Name:            "Create, attach and mark for deletion",
+			Setup:           func(fake *awsfake.Ec2, volume *Volume) {},
+			ExpectedCalls:   []string{"DescribeVolumes", "CreateVolume", "DescribeVolumes", "AttachVolume", "ModifyInstanceAttribute"},
+			ExpectedVolumes: 1,
+			ExpectedManaged: 1,
+			Error:           false,
+		},
+		{
+			Name: "MaxCreatedVolumes reached",
+			Setup: func(fake *awsfake.Ec2, volume *Volume) {
+				volume.MaxCreatedVolumes = 1
+				volume.ManagedVolumes = []ManagedVolume{{SizeGb: 50}}
+			},
+			ExpectedCalls:   nil,
+			ExpectedVolumes: 0,
+			ExpectedManaged: 1,
+			Error:           true,
+		},
+		{
+			Name: "MaxAttachedVolumes exceeded",
+			Setup: func(fake *awsfake.Ec2, volume *Volume) {
+				volume.MaxAttachedVolumes = 0
+				fake.PutVolume(types.Volume{
+					VolumeId: aws.String("vol-root"),
+					State:    types.VolumeStateInUse,
+					Attachments: []types.VolumeAttachment{
+						{InstanceId: aws.String(volume.Host.InstanceId), Device: aws.String("/dev/xvda")},
+					},
+				})
+			},
+			ExpectedCalls:   []string{"DescribeVolumes"},
+			ExpectedVolumes: 1,
+			ExpectedManaged: 0,
+			Error:           true,
+		},
+		{
+			Name: "CreateVolume fails",
+			Setup: func(fake *awsfake.Ec2, volume *Volume) {
+				fake.FailNext("CreateVolume", mockErr)
+			},
+			ExpectedCalls:   []string{"DescribeVolumes", "CreateVolume"},
+			ExpectedVolumes: 0,
+			ExpectedManaged: 0,
+			Error:           true,
+		},
+		{
+			Name: "Volume never becomes available",
+			Setup: func(fake *awsfake.Ec2, volume *Volume) {
+				fake.FailNext("DescribeVolumes", nil)
+				fake.FailNext("DescribeVolumes", mockErr)
+			},
+			ExpectedCalls:   append([]string{"DescribeVolumes", "CreateVolume", "DescribeVolumes"}, deleteCalls...),
+			ExpectedVolumes: 0,
+			ExpectedManaged: 0,
+			Error:           true,
+		},
+		{
+			Name: "AttachVolume fails",
+			Setup: func(fake *awsfake.Ec2, volume *Volume) {
+				fake.FailNext("AttachVolume", mockErr)
+			},
+			ExpectedCalls:   append([]string{"DescribeVolumes", "CreateVolume", "DescribeVolumes", "AttachVolume"}, deleteCalls...),
+			ExpectedVolumes: 0,
+			ExpectedManaged: 0,
+			Error:           true,
+		},
+		{
+			Name: "ModifyInstanceAttribute fails",
+			Setup: func(fake *awsfake.Ec2, volume *Volume) {
+				fake.FailNext("ModifyInstanceAttribute", mockErr)
+			},
+			ExpectedCalls:   append([]string{"DescribeVolumes", "CreateVolume", "DescribeVolumes", "AttachVolume", "ModifyInstanceAttribute"}, cleanupCalls...),
+			ExpectedVolumes: 0,
+			ExpectedManaged: 0,
+			Error:           true,
+		},
+		{
+			Name: "Device never appears",
+			Setup: func(fake *awsfake.Ec2, volume *Volume) {
+				fake.OnAttach = nil
+				fake.OnDetach = nil
+			},
+			ExpectedCalls:   append([]string{"DescribeVolumes", "CreateVolume", "DescribeVolumes", "AttachVolume", "ModifyInstanceAttribute"}, cleanupCalls...),
+			ExpectedVolumes: 0,
+			ExpectedManaged: 0,
+			Error:           true,
+		},
+		{
+			Name: "Cleanup fails leaving the volume behind",
+			Setup: func(fake *awsfake.Ec2, volume *Volume) {
+				fake.FailNext("AttachVolume", mockErr)
+				fake.FailNext("DeleteVolume", mockErr)
+			},
+			ExpectedCalls:   append([]string{"DescribeVolumes", "CreateVolume", "DescribeVolumes", "AttachVolume"}, deleteCalls...),
+			ExpectedVolumes: 1,
+			ExpectedManaged: 0,
+			Error:           true,
+		},
+	}
+
+	for _, i := range tests {
+
+		fake := awsfake.NewEc2()
+		volume := newFakeVolume(t, fake)
+		i.Setup(fake, &volume)
+
+		device, err := volume.createAndAttachEbsVolume(context.Background(), operationGrow, 25)
+
+		if (err == nil) == i.Error {
+			t.Errorf("createAndAttachEbsVolume(%s) Returned an unexpected error: %s", i.Name, err)
+		}
+		if err == nil {
+			if _, statErr := os.Stat(*device); statErr != nil {
+				t.Errorf("createAndAttachEbsVolume(%s) Returned device %s that does not exist", i.Name, *device)
+			}
+		}
+		assert.DeepEqual(t, fake.Calls(), i.ExpectedCalls, cmpopts.EquateEmpty())
+		if got := len(fake.Volumes()); got != i.ExpectedVolumes {
+			t.Errorf("createAndAttachEbsVolume(%s) Expected volumes: %d Got: %d", i.Name, i.ExpectedVolumes, got)
+		}
+		if got := len(volume.ManagedVolumes); got != i.ExpectedManaged {
+			t.Errorf("createAndAttachEbsVolume(%s) Expected managed volumes: %d Got: %d", i.Name, i.ExpectedManaged, got)
+		}
+	}
+}
+
+func TestCreateAndAttachEbsVolumeState(t *testing.T) {
+
+	fake := awsfake.NewEc2()
+	volume := newFakeVolume(t, fake)
+
+	device, err := volume.createAndAttachEbsVolume(context.Background(), operationGrow, 25)
+	if err != nil {
+		t.Fatalf("createAndAttachEbsVolume Returned an unexpected error: %s", err)
+	}
+
+	vols := fake.Volumes()
+	if len(vols) != 1 {
+		t.Fatalf("createAndAttachEbsVolume Expected 1 volume Got: %d", len(vols))
+	}
+	vol := vols[0]
+	assert.Equal(t, vol.State, types.VolumeStateInUse)
+	assert.Equal(t, *vol.Size, int32(25))
+	assert.Equal(t, *vol.AvailabilityZone, volume.Host.AvailabilityZone)
+	assert.Equal(t, len(vol.Attachments), 1)
+	assert.Equal(t, *vol.Attachments[0].Device, *device)
+	assert.Equal(t, *vol.Attachments[0].InstanceId, volume.Host.InstanceId)
+	assert.Equal(t, *vol.Attachments[0].DeleteOnTermination, true)
+	assert.Equal(t, volume.ManagedVolumes[0].VolumeId, *vol.VolumeId)
+
+	// A second volume takes the next free device
+	device2, err := volume.createAndAttachEbsVolume(context.Background(), operationGrow, 25)
+	if err != nil {
+		t.Fatalf("createAndAttachEbsVolume Returned an unexpected error: %s", err)
+	}
+	assert.Assert(t, *device2 != *device)
+	assert.Equal(t, volume.managedVolumeSizeGb(), int32(50))
+}
+
+type TestGrowVolumeInputs struct {
+	Name            string
+	FsErr           error
+	ExpectedManaged int
+	Error           bool
+}
+
+func TestGrowVolume(t *testing.T) {
+
+	tests := []TestGrowVolumeInputs{
+		{
+			Name:            "Grow adds a volume",
+			FsErr:           nil,
+			ExpectedManaged: 1,
+			Error:           false,
+		},
+		{
+			Name:            "Filesystem grow fails after attach",
+			FsErr:           fmt.Errorf("mock error"),
+			ExpectedManaged: 1,
+			Error:           true,
+		},
+	}
+
+	for _, i := range tests {
+
+		fake := awsfake.NewEc2()
+		volume := newFakeVolume(t, fake)
+		volume.Fs = mockFS{
+			Size:       aws.Uint64(200),
+			Used:       aws.Uint64(100),
+			Free:       aws.Uint64(100),
+			MountPoint: aws.String("/mnt/mock"),
+			Err:        i.FsErr,
+		}
+
+		err := volume.GrowVolume(context.Background())
+
+		if (err == nil) == i.Error {
+			t.Errorf("GrowVolume(%s) Returned an unexpected error: %s", i.Name, err)
+		}
+		if got := len(volume.ManagedVolumes); got != i.ExpectedManaged {
+			t.Errorf("GrowVolume(%s) Expected managed volumes: %d Got: %d", i.Name, i.ExpectedManaged, got)
+		}
+		// (200 - 50) / (3 - 1) = 75
+		assert.Equal(t, *fake.Volumes()[0].Size, int32(75))
+	}
+}
+
+type TestCreateVolumeInputs struct {
+	Name     string
+	ForceNew bool
+	// Existing whether a managed volume is already attached when init runs
+	Existing        bool
+	HasFs           bool
+	ExpectedFsCalls []string
+	// ExpectedVolumes the number of volumes remaining in ec2
+	ExpectedVolumes int
+	Error           bool
+}
+
+func TestCreateVolume(t *testing.T) {
+
+	volumeAvailableTimeout = time.Second
+	volumeDeletedTimeout = time.Second
+	deviceAvailableTimeout = 200 * time.Millisecond
+
+	tests := []TestCreateVolumeInputs{
+		{
+			Name:            "New filesystem",
+			ExpectedFsCalls: []string{"CreateFileSystem"},
+			ExpectedVolumes: 1,
+			Error:           false,
+		},
+		{
+			Name:            "Existing filesystem is mounted",
+			Existing:        true,
+			HasFs:           true,
+			ExpectedFsCalls: []string{"MountFileSystem"},
+			ExpectedVolumes: 1,
+			Error:           false,
+		},
+		{
+			Name:            "Existing volume without a filesystem is not formatted",
+			Existing:        true,
+			HasFs:           false,
+			ExpectedFsCalls: nil,
+			ExpectedVolumes: 1,
+			Error:           true,
+		},
+		{
+			Name:            "Force new recreates the filesystem",
+			ForceNew:        true,
+			Existing:        true,
+			HasFs:           true,
+			ExpectedFsCalls: []string{"DestroyFileSystem:false", "CreateFileSystem"},
+			ExpectedVolumes: 1,
+			Error:           false,
+		},
+	}
+
+	for _, i := range tests {
+
+		fake := awsfake.NewEc2()
+		volume := newFakeVolume(t, fake)
+		var calls []string
+		volume.Fs = recordingFS{mockFS: mockFS{MountPoint: aws.String("/mnt/mock"), HasFs: i.HasFs}, calls: &calls}
+		if i.Existing {
+			device := volume.devicePrefix + "a"
+			putManagedVolume(fake, "vol-existing", volume.Id, volume.Host.InstanceId, device)
+			_ = os.WriteFile(device, []byte{}, 0600)
+			managed, err := listManagedVolumes(context.Background(), volume.Provider, volume.Host, volume.Id)
+			if err != nil {
+				t.Fatalf("listManagedVolumes(%s) Returned an unexpected error: %s", i.Name, err)
+			}
+			volume.ManagedVolumes = managed
+		}
+
+		err := volume.CreateVolume(context.Background(), i.ForceNew)
+
+		if (err == nil) == i.Error {
+			t.Errorf("CreateVolume(%s) Returned an unexpected error: %s", i.Name, err)
+		}
+		assert.DeepEqual(t, calls, i.ExpectedFsCalls, cmpopts.EquateEmpty())
+		assert.Equal(t, len(fake.Volumes()), i.ExpectedVolumes)
+		if i.ForceNew {
+			_, found := fake.Volume("vol-existing")
+			assert.Assert(t, !found)
+		}
+	}
+}
+
+func TestNewVolumeEndpointOverride(t *testing.T) {
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "standin")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "standin")
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+
+	fake := awsfake.NewEc2()
+	srv := httptest.NewServer(awsfake.NewServer(fake, "123456789012"))
+	defer srv.Close()
+
+	mountPoint := "/mnt/mock"
+	host := Ec2Host{InstanceId: "i-1", AvailabilityZone: "ap-southeast-2a", Region: "ap-southeast-2"}
+	fake.PutVolume(types.Volume{
+		VolumeId: aws.String("vol-managed"),
+		Size:     aws.Int32(50),
+		State:    types.VolumeStateInUse,
+		Attachments: []types.VolumeAttachment{
+			{InstanceId: aws.String("i-1"), Device: aws.String("/dev/xvdba"), State: types.VolumeAttachmentStateAttached},
+		},
+		Tags: []types.Tag{{Key: aws.String("ebs-autoscale-id"), Value: aws.String(Md5String(mountPoint))}},
+	})
+	fake.PutVolume(types.Volume{
+		VolumeId: aws.String("vol-root"),
+		Size:     aws.Int32(8),
+		State:    types.VolumeStateInUse,
+		Attachments: []types.VolumeAttachment{
+			{InstanceId: aws.String("i-1"), Device: aws.String("/dev/xvda"), State: types.VolumeAttachmentStateAttached},
+		},
+	})
+
+	volume, err := NewVolume(context.Background(), host, mockFS{MountPoint: aws.String(mountPoint)}, VolumeCfg{}, AwsCfg{EndpointUrl: srv.URL})
+	if err != nil {
+		t.Fatalf("NewVolume Returned an unexpected error: %s", err)
+	}
+	assert.Equal(t, len(volume.ManagedVolumes), 1)
+	assert.Equal(t, volume.ManagedVolumes[0].VolumeId, "vol-managed")
+	assert.Equal(t, volume.devicePrefix, defaultDevicePrefix)
+}